@@ -170,13 +170,22 @@ class App:
             "timestamp": str(int(futuretime(duration * 60) * 1000)),
             "id": flightid,
         }
-        stream = self._send_only(None, method, query, None)
+        # Updates are pushed on streams opened by the server once subscribed
+        stream, _ = self._send(method, query, self.deadline)
+        stream.close()
+        end = futuretime(duration * 60)
+        self.stop_event = Event()
 
         def read():
-            while not stream.closed:
-                b = None
+            while time.time() < end and not (
+                self.stop_event and self.stop_event.is_set()
+            ):
+                try:
+                    pushed = self.client.accept(timeout=1)
+                except Empty:
+                    continue
                 try:
-                    b = stream.read()
+                    b = pushed.read()
                     res: Message = codec.unmarshal(b, Message())
                     if res.error:
                         raise Exception(res.error)
@@ -184,9 +193,12 @@ class App:
                     flight: Flight = codec.unmarshal(res.body, Flight())
                     print(f"{flight}\n")
                 except EOF as e:
-                    if self.stop_event:
-                        self.stop_event.set()
-                    return
+                    pass
+                finally:
+                    if not pushed.closed:
+                        pushed.close()
+            if self.stop_event:
+                self.stop_event.set()
 
         async def async_wrap(fn):
             """Wraps a function in to an async function"""
@@ -204,7 +216,9 @@ class App:
                 ],
                 return_when=asyncio.FIRST_COMPLETED,
             )
-            stream.close()
+            # Release the subscription on the server
+            stream.cancel()
+            self.stop_event.set()
 
         if blocking:
             asyncio.run(concurrent())
@@ -255,6 +269,8 @@ class App:
                 pass
             if msg.error:
                 raise Exception(f"{msg.error.error}: {msg.error.body}")
+            # Acknowledge reply so the server may release it
+            stream.ack()
             return [stream, msg]
 
         tries = 0
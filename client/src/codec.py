@@ -20,10 +20,11 @@ class Encoder:
         self.out.extend(struct.pack("<?", 1 if b else 0))
 
     def write_string(self, s: str):
-        """Encodes a string variable"""
-        self.write_int32(len(s))
+        """Encodes a string variable prefixed with its length in bytes"""
+        b = s.encode("utf-8")
+        self.write_int32(len(b))
         # < means little-endian s means string
-        self.out.extend(struct.pack(f"<{len(s)}s", s.encode("utf-8")))
+        self.out.extend(struct.pack(f"<{len(b)}s", b))
 
     def write_int(self, i: int):
         """Encodes an integer variable"""
@@ -66,7 +67,7 @@ class Encoder:
 
     def write_float64(self, i: float):
         """Encodes a 64 bit float variable"""
-        # < means little-endian d means float32
+        # < means little-endian d means float64
         self.out.extend(struct.pack("<d", i))
 
 
@@ -157,8 +158,8 @@ class Decoder:
 
     def read_float64(self):
         """Decoding a 64 bit float"""
-        # < means little-endian d means float32
-        self.out.extend(struct.pack("<d", i))
+        # < means little-endian d means float64
+        b = self.buffer_reader.read(8)
         return struct.unpack("<d", b)[0]
 
 
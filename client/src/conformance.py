@@ -0,0 +1,157 @@
+import json
+import os
+import sys
+from codec import Encoder, Decoder
+
+# Golden encodings generated by the Go server. See server/conformance
+GOLDEN = os.path.join(
+    os.path.dirname(__file__), "..", "..", "server", "conformance", "testdata", "golden.json"
+)
+
+# Encoder and Decoder methods of the Go types supported by the codec
+SCALARS = {
+    "bool": ("write_bool", "read_bool"),
+    "int": ("write_int64", "read_int64"),
+    "int32": ("write_int32", "read_int32"),
+    "int64": ("write_int64", "read_int64"),
+    "uint": ("write_uint64", "read_uint64"),
+    "uint32": ("write_uint32", "read_uint32"),
+    "uint64": ("write_uint64", "read_uint64"),
+    "float32": ("write_float32", "read_float32"),
+    "float64": ("write_float64", "read_float64"),
+    "string": ("write_string", "read_string"),
+}
+
+
+class Unsupported(Exception):
+    """Raised for Go types the codec does not implement"""
+
+
+class Conformance:
+    """
+    Verifies the codec against the golden encodings of the Positional format.
+    Values are encoded and decoded by the Go type of each vector, composing
+    the Encoder and Decoder methods by the fields of the struct types.
+    """
+
+    def __init__(self, golden: dict):
+        self.structs = golden["structs"]
+        self.vectors = [
+            v
+            for v in golden["vectors"]
+            if v["format"] == "Positional" and not v["compact"]
+        ]
+
+    def encode(self, e: Encoder, typ: str, v):
+        if typ in SCALARS:
+            return getattr(e, SCALARS[typ][0])(v)
+        if typ == "[]uint8":
+            return e.write_bytearray(bytes.fromhex(v or ""))
+        if typ.startswith("*"):
+            e.write_bool(v is None)
+            if v is not None:
+                self.encode(e, typ[1:], v)
+            return
+        if typ.startswith("[]"):
+            v = v or []
+            e.write_int64(len(v))
+            for item in v:
+                self.encode(e, typ[2:], item)
+            return
+        if typ.startswith("map[string]"):
+            v = v or {}
+            e.write_int64(len(v))
+            for key, value in v.items():
+                e.write_string(key)
+                self.encode(e, typ[len("map[string]") :], value)
+            return
+        if typ in self.structs:
+            for f in self.field_types(typ):
+                value = v[f["name"]]
+                if f.get("omitempty"):
+                    present = bool(value)
+                    e.write_bool(present)
+                    if not present:
+                        continue
+                self.encode(e, f["type"], value)
+            return
+        raise Unsupported(typ)
+
+    def decode(self, d: Decoder, typ: str):
+        if typ in SCALARS:
+            return getattr(d, SCALARS[typ][1])()
+        if typ == "[]uint8":
+            b = d.read_bytearray()
+            return bytes(b).hex() if b else None
+        if typ.startswith("*"):
+            if d.read_bool():
+                return None
+            return self.decode(d, typ[1:])
+        if typ.startswith("[]"):
+            length = d.read_int64()
+            if length == 0:
+                return None
+            return [self.decode(d, typ[2:]) for _ in range(length)]
+        if typ.startswith("map[string]"):
+            res = {}
+            for _ in range(d.read_int64()):
+                key = d.read_string()
+                res[key] = self.decode(d, typ[len("map[string]") :])
+            return res
+        if typ in self.structs:
+            res = {}
+            for f in self.field_types(typ):
+                if f.get("omitempty") and not d.read_bool():
+                    res[f["name"]] = ""
+                    continue
+                res[f["name"]] = self.decode(d, f["type"])
+            return res
+        raise Unsupported(typ)
+
+    def field_types(self, typ: str):
+        """Fields of a struct type in order of their number"""
+        for f in self.structs[typ]:
+            if f.get("varint"):
+                raise Unsupported(f["type"] + " varint")
+        return self.structs[typ]
+
+    def verify(self):
+        """Returns the number of vectors passed, skipped and their failures"""
+        passed, skipped, failures = 0, 0, []
+        for v in self.vectors:
+            try:
+                e = Encoder()
+                self.encode(e, v["type"], v["value"])
+                got = bytes(e.out).hex()
+                decoded = self.decode(Decoder(bytearray.fromhex(v["hex"])), v["type"])
+            except Unsupported:
+                skipped += 1
+                continue
+            if got != v["hex"]:
+                failures.append(f"{v['name']}: encodes to {got}, want {v['hex']}")
+            elif not same(decoded, v["value"]):
+                failures.append(f"{v['name']}: decodes to {decoded}, want {v['value']}")
+            else:
+                passed += 1
+        return passed, skipped, failures
+
+
+def same(got, want) -> bool:
+    """Compares decoded values with golden values. Empty values decode to None"""
+    if isinstance(want, dict) and isinstance(got, dict):
+        return all(same(got.get(k), w) for k, w in want.items())
+    if isinstance(want, list) and isinstance(got, list):
+        return len(got) == len(want) and all(same(g, w) for g, w in zip(got, want))
+    if want in ("", [], {}, None) and got in ("", [], {}, None):
+        return True
+    return got == want
+
+
+if __name__ == "__main__":
+    path = sys.argv[1] if len(sys.argv) > 1 else GOLDEN
+    with open(path) as f:
+        passed, skipped, failures = Conformance(json.load(f)).verify()
+    for failure in failures:
+        print(failure)
+    print(f"{passed} passed, {skipped} skipped, {len(failures)} failed")
+    sys.exit(1 if failures else 0)
@@ -19,6 +19,8 @@ class Flag(Enum):
     DNE: End of partitioned frame
     NOP: No Operation
     FIN: Finish ; terminates end of stream
+    ACK: Acknowledges a reply ; seqid carries the request sequence
+    RST: Cancels a request ; seqid carries the request sequence
     """
 
     SYN = 0
@@ -26,6 +28,8 @@ class Flag(Enum):
     DNE = 2
     NOP = 3
     FIN = 4
+    ACK = 5
+    RST = 6
 
 
 class Frame:
@@ -15,10 +15,11 @@ class Stream:
     both entities can exchange frames without a disjoint connection
     ."""
 
-    def __init__(self, sess, sid, rid, maxFrameSize, deadline):
+    def __init__(self, sess, sid, rid, maxFrameSize, deadline, seq=0):
         self.session = sess  # the session
         self.sid = sid  # session id
         self.rid = rid  # request id
+        self.seq = seq  # request sequence carried by ACK and RST frames
         self.maxFrameSize = maxFrameSize  # max frame size in the session
         self.deadline = deadline
         self.closed = False
@@ -159,6 +160,19 @@ class Stream:
         self.closed = True
         self.session.writeFrame(frame=Frame(Flag.FIN, self.sid, self.rid, 0))
 
+    def ack(self):
+        """
+        Acknowledges the reply of the stream so the server may release it
+        """
+        self.session.writeFrame(frame=Frame(Flag.ACK, self.sid, self.rid, self.seq))
+
+    def cancel(self):
+        """
+        Asks the server to abandon the request of the stream and any work it retains
+        such as subscriptions. Cancel may be called once the stream is closed.
+        """
+        self.session.writeFrame(frame=Frame(Flag.RST, self.sid, self.rid, self.seq))
+
 
 class Session:
     """
@@ -173,6 +187,9 @@ class Session:
         self.requestId = 0
         self.mtu = 1500
         self.mutex = Lock()
+        self.accepted = Queue()  # streams opened by the server
+        # Let OS assign a port with 0 port. Bound before any frame is sent
+        self.sock.bind(("0.0.0.0", 0))
         self.read_thread = Thread(target=self.recv)
         self.read_thread.daemon = True
         self.read_thread.start()
@@ -180,10 +197,9 @@ class Session:
     def recv(self):
         """
         Client implementation of recv of protocol implementation
-        Cannot serve incoming SYN requests as per the protocol.
+        Incoming SYN frames open server initiated streams e.g. pushed flight updates.
+        These streams are returned by accept.
         """
-        # Let OS assign a port with 0 port
-        self.sock.bind(("0.0.0.0", 0))
 
         # Listens for incoming frames from udp socket
         def loop():
@@ -191,7 +207,22 @@ class Session:
                 d, addr = self.sock.recvfrom(self.mtu)
                 buffer = bytearray(d)
                 header = Header(buffer)
-                if header.flag() == Flag.PSH.value:
+                if header.flag() == Flag.SYN.value:
+                    self.mutex.acquire()
+                    key = self.streamKey(header.sid(), header.rid())
+                    if key not in self.streams:
+                        stream = Stream(
+                            self,
+                            header.sid(),
+                            header.rid(),
+                            self.mtu - Header.header_size,
+                            None,
+                            header.seqId(),
+                        )
+                        self.streams[key] = stream
+                        self.accepted.put(stream)
+                    self.mutex.release()
+                elif header.flag() == Flag.PSH.value:
                     if header.length() <= 0:
                         continue
                     self.mutex.acquire()
@@ -217,6 +248,17 @@ class Session:
                         ]
                         stream.close()
                     self.mutex.release()
+                elif header.flag() == Flag.RST.value:
+                    # The server abandoned the request of the stream
+                    self.mutex.acquire()
+                    if self.streamKey(header.sid(), header.rid()) in self.streams:
+                        stream: Stream = self.streams[
+                            self.streamKey(header.sid(), header.rid())
+                        ]
+                        stream.notifyClose()
+                        stream.closed = True
+                    self.mutex.release()
+                # ACK frames acknowledge replies the client does not retain
 
         try:
             loop()
@@ -262,6 +304,13 @@ class Session:
 
         return stream
 
+    def accept(self, timeout: float = None):
+        """
+        Returns the next stream opened by the server.
+        Raises Empty if no stream is opened within timeout.
+        """
+        return self.accepted.get(block=True, timeout=timeout)
+
     # Write a frame to the server
     def writeFrame(self, frame: Frame, deadline: int = 0):
         self.sock.sendto(frame.buffer, self.target)
@@ -288,3 +337,7 @@ class Client:
     def open_with_existing(self, stream: Stream, deadline: int = None):
         """Creates a new stream with an existing stream sid"""
         return self.session.open_with_existing(stream=stream, deadline=deadline)
+
+    def accept(self, timeout: float = None):
+        """Returns the next stream opened by the server"""
+        return self.session.accept(timeout=timeout)
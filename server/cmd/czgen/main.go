@@ -0,0 +1,650 @@
+// czgen generates reflection free MarshalCZ and UnmarshalCZ methods for structs.
+// The generated methods produce the same wire format as encoding.Marshal and
+// are picked up by the encoding package automatically.
+//
+// Usage with go generate:
+//
+//	//go:generate go run ../cmd/czgen -type Flight,Message -output wire_gen.go
+//
+// Struct types of the same package referenced by the given types are generated as well.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/isaiahwong/cz4013/encoding"
+)
+
+// basicTypes maps supported basic types to the suffix of their Encoder/Decoder methods
+var basicTypes = map[string]string{
+	"bool":    "Bool",
+	"string":  "String",
+	"int":     "Int",
+	"int8":    "Int8",
+	"int16":   "Int16",
+	"int32":   "Int32",
+	"int64":   "Int64",
+	"uint":    "Uint",
+	"uint8":   "Uint8",
+	"byte":    "Uint8",
+	"uint16":  "Uint16",
+	"uint32":  "Uint32",
+	"uint64":  "Uint64",
+	"float32": "Float32",
+	"float64": "Float64",
+}
+
+// generator generates codecs of structs in a package
+type generator struct {
+	buf bytes.Buffer
+	pkg string
+
+	// Declared types of the package
+	structs map[string]*ast.StructType
+	named   map[string]string // named basic types to their underlying type
+
+	// Unique variable names
+	vars int
+}
+
+func main() {
+	var types string
+	var output string
+	var dir string
+	var encodingPkg string
+	flag.StringVar(&types, "type", "", "Comma separated struct types to generate codecs for")
+	flag.StringVar(&output, "output", "wire_gen.go", "Output file name relative to the package directory")
+	flag.StringVar(&dir, "dir", ".", "Package directory")
+	flag.StringVar(&encodingPkg, "encoding", "", "Import path of the encoding package. Derived from go.mod if empty")
+	flag.Parse()
+
+	if types == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(dir, strings.Split(types, ","), output, encodingPkg); err != nil {
+		fmt.Fprintln(os.Stderr, "czgen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string, types []string, output string, encodingPkg string) error {
+	if encodingPkg == "" {
+		module, err := modulePath(dir)
+		if err != nil {
+			return err
+		}
+		encodingPkg = module + "/encoding"
+	}
+
+	g, err := parse(dir, output)
+	if err != nil {
+		return err
+	}
+
+	// Generate requested types and the structs they reference
+	names, err := g.reachable(types)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(&g.buf, "// Code generated by czgen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&g.buf, "package %v\n\n", g.pkg)
+	fmt.Fprintf(&g.buf, "import %q\n", encodingPkg)
+	for _, name := range names {
+		if err := g.generate(name); err != nil {
+			return err
+		}
+	}
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting output: %v", err)
+	}
+	return os.WriteFile(filepath.Join(dir, output), src, 0644)
+}
+
+// modulePath returns the module path of the go.mod enclosing dir
+func modulePath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		b, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(b), "\n") {
+				if strings.HasPrefix(line, "module ") {
+					return strings.TrimSpace(strings.TrimPrefix(line, "module ")), nil
+				}
+			}
+			return "", errors.New("module path not found in go.mod")
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("go.mod not found")
+		}
+		dir = parent
+	}
+}
+
+// parse collects the type declarations of the package in dir, skipping output
+func parse(dir string, output string) (*generator, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return fi.Name() != output && !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected a single package in %v, found %v", dir, len(pkgs))
+	}
+
+	g := &generator{
+		structs: make(map[string]*ast.StructType),
+		named:   make(map[string]string),
+	}
+	for name, pkg := range pkgs {
+		g.pkg = name
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					ts := spec.(*ast.TypeSpec)
+					switch t := ts.Type.(type) {
+					case *ast.StructType:
+						g.structs[ts.Name.Name] = t
+					case *ast.Ident:
+						if _, ok := basicTypes[t.Name]; ok {
+							g.named[ts.Name.Name] = t.Name
+						}
+					}
+				}
+			}
+		}
+	}
+	return g, nil
+}
+
+// reachable returns the structs referenced by types in sorted order
+func (g *generator) reachable(types []string) ([]string, error) {
+	seen := map[string]bool{}
+	var visit func(name string) error
+	var walk func(expr ast.Expr) error
+
+	walk = func(expr ast.Expr) error {
+		switch t := expr.(type) {
+		case *ast.Ident:
+			if _, ok := g.structs[t.Name]; ok {
+				return visit(t.Name)
+			}
+		case *ast.StarExpr:
+			return walk(t.X)
+		case *ast.ArrayType:
+			return walk(t.Elt)
+		case *ast.MapType:
+			if err := walk(t.Key); err != nil {
+				return err
+			}
+			return walk(t.Value)
+		}
+		return nil
+	}
+
+	visit = func(name string) error {
+		if seen[name] {
+			return nil
+		}
+		st, ok := g.structs[name]
+		if !ok {
+			return fmt.Errorf("struct type %v not found in package %v", name, g.pkg)
+		}
+		seen[name] = true
+		for _, field := range st.Fields.List {
+			if err := walk(field.Type); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, name := range types {
+		if err := visit(strings.TrimSpace(name)); err != nil {
+			return nil, err
+		}
+	}
+
+	names := []string{}
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// printf writes formatted code to the output
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// tmp returns a unique variable name
+func (g *generator) tmp(prefix string) string {
+	g.vars++
+	return fmt.Sprintf("%v%v", prefix, g.vars)
+}
+
+// field is a field of a struct to generate
+type field struct {
+	name string
+	expr ast.Expr
+	tag  encoding.Tag
+}
+
+// fields returns the encoded fields of struct name in order of their field number
+func (g *generator) fields(name string) ([]field, error) {
+	all := []field{}
+	tags := []encoding.Tag{}
+	for _, f := range g.structs[name].Fields.List {
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%v: embedded field %v is not supported", name, typeString(f.Type))
+		}
+		tag := encoding.Tag{}
+		if f.Tag != nil {
+			lit, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", name, err)
+			}
+			if tag, err = encoding.ParseTag(reflect.StructTag(lit).Get("cz")); err != nil {
+				return nil, fmt.Errorf("%v.%v: %v", name, f.Names[0].Name, err)
+			}
+		}
+		for _, ident := range f.Names {
+			t := tag
+			// Unexported fields are skipped like encoding.Marshal does
+			if !ident.IsExported() {
+				t.Skip = true
+			}
+			all = append(all, field{name: ident.Name, expr: f.Type})
+			tags = append(tags, t)
+		}
+	}
+
+	order, err := encoding.NumberFields(tags)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", name, err)
+	}
+	fields := []field{}
+	for _, i := range order {
+		f := all[i]
+		f.tag = tags[i]
+		if f.tag.Varint {
+			if _, underlying, ok := g.basic(f.expr); !ok || !isInteger(underlying) {
+				return nil, fmt.Errorf("%v.%v: varint requires an integer type", name, f.name)
+			}
+		}
+		if f.tag.OmitEmpty && g.zero(f.expr) == "" {
+			return nil, fmt.Errorf("%v.%v: omitempty is not supported on %v", name, f.name, typeString(f.expr))
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// generate writes the methods of struct name
+func (g *generator) generate(name string) error {
+	fields, err := g.fields(name)
+	if err != nil {
+		return err
+	}
+
+	// Marshal
+	g.vars = 0
+	g.printf("\n// MarshalCZ encodes %v to e without reflection\n", name)
+	g.printf("func (x *%v) MarshalCZ(e *encoding.Encoder) error {\n", name)
+	for _, f := range fields {
+		v := "x." + f.name
+		if f.tag.OmitEmpty {
+			// Omitted fields are prefixed with a presence flag
+			g.printf("if err := e.WriteBool(%v != %v); err != nil {\nreturn err\n}\n", g.length(v, f.expr), g.zero(f.expr))
+			g.printf("if %v != %v {\n", g.length(v, f.expr), g.zero(f.expr))
+		}
+		if f.tag.Varint {
+			g.encodeVarint(v, f.expr)
+		} else if bits := g.fixed(f); bits != "" {
+			g.printf("if err := e.WriteFixed%v(uint%v(%v)); err != nil {\nreturn err\n}\n", bits, bits, v)
+		} else if err := g.encode(v, f.expr); err != nil {
+			return fmt.Errorf("%v.%v: %v", name, f.name, err)
+		}
+		if f.tag.OmitEmpty {
+			g.printf("}\n")
+		}
+	}
+	g.printf("return nil\n}\n")
+
+	// Unmarshal
+	g.vars = 0
+	g.printf("\n// UnmarshalCZ decodes %v from d without reflection\n", name)
+	g.printf("func (x *%v) UnmarshalCZ(d *encoding.Decoder) error {\n", name)
+	g.printf("if err := d.Enter(); err != nil {\nreturn err\n}\ndefer d.Leave()\n")
+	for _, f := range fields {
+		v := "x." + f.name
+		if f.tag.OmitEmpty {
+			present := g.tmp("present")
+			g.printf("%v, err := d.ReadBool()\nif err != nil {\nreturn err\n}\n", present)
+			g.printf("if !%v {\n%v = %v\n} else {\n", present, v, g.zeroValue(f.expr))
+		}
+		if f.tag.Varint {
+			g.decodeVarint(v, f.expr)
+		} else if bits := g.fixed(f); bits != "" {
+			n := g.tmp("n")
+			g.printf("%v, err := d.ReadFixed%v()\nif err != nil {\nreturn err\n}\n", n, bits)
+			g.printf("%v = %v(%v)\n", v, typeString(f.expr), n)
+		} else if err := g.decode(v, f.expr); err != nil {
+			return fmt.Errorf("%v.%v: %v", name, f.name, err)
+		}
+		if f.tag.OmitEmpty {
+			g.printf("}\n")
+		}
+	}
+	g.printf("return nil\n}\n")
+
+	// encoding.BinaryMarshaler
+	g.printf("\n// MarshalBinary encodes %v in the wire format of encoding.Marshal\n", name)
+	g.printf("func (x *%v) MarshalBinary() ([]byte, error) {\nreturn encoding.Marshal(x)\n}\n", name)
+	g.printf("\n// UnmarshalBinary decodes %v in the wire format of encoding.Unmarshal\n", name)
+	g.printf("func (x *%v) UnmarshalBinary(b []byte) error {\nreturn encoding.Unmarshal(b, x)\n}\n", name)
+	return nil
+}
+
+// fixed returns the width of an integer field tagged fixed, which is
+// written with fixed width in compact mode. Empty for other fields.
+func (g *generator) fixed(f field) string {
+	if !f.tag.Fixed {
+		return ""
+	}
+	_, underlying, ok := g.basic(f.expr)
+	if !ok {
+		return ""
+	}
+	switch underlying {
+	case "int16", "uint16":
+		return "16"
+	case "int32", "uint32":
+		return "32"
+	case "int", "int64", "uint", "uint64":
+		return "64"
+	}
+	return ""
+}
+
+// isInteger returns true if the basic type is an integer
+func isInteger(basic string) bool {
+	switch basic {
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "byte", "uint16", "uint32", "uint64":
+		return true
+	}
+	return false
+}
+
+// length returns the expression compared against zero to test if v of type expr is empty
+func (g *generator) length(v string, expr ast.Expr) string {
+	switch expr.(type) {
+	case *ast.ArrayType, *ast.MapType:
+		return "len(" + v + ")"
+	}
+	return v
+}
+
+// zero returns the literal an empty v of type expr compares equal to.
+// Empty if the type cannot be tested for emptiness.
+func (g *generator) zero(expr ast.Expr) string {
+	if _, underlying, ok := g.basic(expr); ok {
+		switch underlying {
+		case "bool":
+			return "false"
+		case "string":
+			return `""`
+		}
+		return "0"
+	}
+	switch expr.(type) {
+	case *ast.StarExpr:
+		return "nil"
+	case *ast.ArrayType, *ast.MapType:
+		return "0"
+	}
+	return ""
+}
+
+// zeroValue returns the zero value of type expr
+func (g *generator) zeroValue(expr ast.Expr) string {
+	switch expr.(type) {
+	case *ast.ArrayType, *ast.MapType:
+		return "nil"
+	}
+	return g.zero(expr)
+}
+
+// encodeVarint writes the varint encoding of integer v of type expr
+func (g *generator) encodeVarint(v string, expr ast.Expr) {
+	_, underlying, _ := g.basic(expr)
+	wide, suffix := "uint64", "Uvarint"
+	if strings.HasPrefix(underlying, "int") {
+		wide, suffix = "int64", "Varint"
+	}
+	if typeString(expr) != wide {
+		v = fmt.Sprintf("%v(%v)", wide, v)
+	}
+	g.printf("if err := e.Write%v(%v); err != nil {\nreturn err\n}\n", suffix, v)
+}
+
+// decodeVarint writes the varint decoding into integer v of type expr
+func (g *generator) decodeVarint(v string, expr ast.Expr) {
+	_, underlying, _ := g.basic(expr)
+	n := g.tmp("n")
+	wide, suffix := "uint64", "Uvarint"
+	if strings.HasPrefix(underlying, "int") {
+		wide, suffix = "int64", "Varint"
+	}
+	g.printf("%v, err := d.Read%v()\nif err != nil {\nreturn err\n}\n", n, suffix)
+	if underlying != wide {
+		g.printf("if %v(%v(%v)) != %v {\nreturn encoding.ErrOverflow\n}\n", wide, underlying, n, n)
+	}
+	if typeString(expr) == wide {
+		g.printf("%v = %v\n", v, n)
+	} else {
+		g.printf("%v = %v(%v)\n", v, typeString(expr), n)
+	}
+}
+
+// basic returns the Encoder/Decoder method suffix and underlying type of a basic type
+func (g *generator) basic(expr ast.Expr) (string, string, bool) {
+	ident, ok := expr.(*ast.Ident)
+	if !ok {
+		return "", "", false
+	}
+	if suffix, ok := basicTypes[ident.Name]; ok {
+		return suffix, ident.Name, true
+	}
+	if underlying, ok := g.named[ident.Name]; ok {
+		return basicTypes[underlying], underlying, true
+	}
+	return "", "", false
+}
+
+// isByte returns true if expr is a byte type
+func isByte(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && (ident.Name == "byte" || ident.Name == "uint8")
+}
+
+// typeString returns the source of a type expression
+func typeString(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return "*" + typeString(t.X)
+	case *ast.ArrayType:
+		return "[]" + typeString(t.Elt)
+	case *ast.MapType:
+		return "map[" + typeString(t.Key) + "]" + typeString(t.Value)
+	case *ast.SelectorExpr:
+		return typeString(t.X) + "." + t.Sel.Name
+	}
+	return fmt.Sprintf("%T", expr)
+}
+
+// encode writes the encoding of v of type expr
+func (g *generator) encode(v string, expr ast.Expr) error {
+	if suffix, underlying, ok := g.basic(expr); ok {
+		if underlying != typeString(expr) {
+			v = fmt.Sprintf("%v(%v)", underlying, v)
+		}
+		g.printf("if err := e.Write%v(%v); err != nil {\nreturn err\n}\n", suffix, v)
+		return nil
+	}
+
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if _, ok := g.structs[t.Name]; ok {
+			g.printf("if err := %v.MarshalCZ(e); err != nil {\nreturn err\n}\n", v)
+			return nil
+		}
+	case *ast.StarExpr:
+		// Pointers are prefixed with a nil flag
+		g.printf("if err := e.WriteBool(%v == nil); err != nil {\nreturn err\n}\n", v)
+		g.printf("if %v != nil {\n", v)
+		if err := g.encode("(*"+v+")", t.X); err != nil {
+			return err
+		}
+		g.printf("}\n")
+		return nil
+	case *ast.ArrayType:
+		if t.Len != nil {
+			break
+		}
+		// Slices are prefixed with their length
+		g.printf("if err := e.WriteUint64(uint64(len(%v))); err != nil {\nreturn err\n}\n", v)
+		if isByte(t.Elt) {
+			g.printf("if err := e.WriteBytes(%v); err != nil {\nreturn err\n}\n", v)
+			return nil
+		}
+		elem := g.tmp("v")
+		g.printf("for _, %v := range %v {\n", elem, v)
+		if err := g.encode(elem, t.Elt); err != nil {
+			return err
+		}
+		g.printf("}\n")
+		return nil
+	case *ast.MapType:
+		// Maps are prefixed with their length
+		g.printf("if err := e.WriteUint64(uint64(len(%v))); err != nil {\nreturn err\n}\n", v)
+		key, val := g.tmp("k"), g.tmp("v")
+		g.printf("for %v, %v := range %v {\n", key, val, v)
+		if err := g.encode(key, t.Key); err != nil {
+			return err
+		}
+		if err := g.encode(val, t.Value); err != nil {
+			return err
+		}
+		g.printf("}\n")
+		return nil
+	}
+	return fmt.Errorf("unsupported type %v", typeString(expr))
+}
+
+// decode writes the decoding into v of type expr
+func (g *generator) decode(v string, expr ast.Expr) error {
+	if suffix, underlying, ok := g.basic(expr); ok {
+		n := g.tmp("n")
+		g.printf("%v, err := d.Read%v()\nif err != nil {\nreturn err\n}\n", n, suffix)
+		if underlying == typeString(expr) {
+			g.printf("%v = %v\n", v, n)
+		} else {
+			g.printf("%v = %v(%v)\n", v, typeString(expr), n)
+		}
+		return nil
+	}
+
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if _, ok := g.structs[t.Name]; ok {
+			g.printf("if err := %v.UnmarshalCZ(d); err != nil {\nreturn err\n}\n", v)
+			return nil
+		}
+	case *ast.StarExpr:
+		// A nil pointer sets the value to nil
+		isNil := g.tmp("isNil")
+		g.printf("%v, err := d.ReadBool()\nif err != nil {\nreturn err\n}\n", isNil)
+		g.printf("if %v {\n%v = nil\n} else {\n", isNil, v)
+		g.printf("if %v == nil {\n%v = new(%v)\n}\n", v, v, typeString(t.X))
+		if err := g.decode("(*"+v+")", t.X); err != nil {
+			return err
+		}
+		g.printf("}\n")
+		return nil
+	case *ast.ArrayType:
+		if t.Len != nil {
+			break
+		}
+		// An empty slice sets the value to nil
+		l := g.tmp("l")
+		if isByte(t.Elt) {
+			g.printf("%v, err := d.ReadLength()\nif err != nil {\nreturn err\n}\n", l)
+			g.printf("if %v > 0 {\n", l)
+			b := g.tmp("b")
+			g.printf("%v, err := d.ReadBytes(%v)\nif err != nil {\nreturn err\n}\n", b, l)
+			g.printf("%v = %v\n", v, b)
+			g.printf("} else {\n%v = nil\n}\n", v)
+			return nil
+		}
+		// Elements beyond the capacity are appended as they decode
+		g.printf("%v, err := d.ReadLength()\nif err != nil {\nreturn err\n}\n", l)
+		g.printf("if %v > 0 {\n", l)
+		i, elem := g.tmp("i"), g.tmp("e")
+		g.printf("%v = make(%v, 0, d.Capacity(%v))\n", v, typeString(t), l)
+		g.printf("for %v := 0; %v < %v; %v++ {\n", i, i, l, i)
+		g.printf("var %v %v\n", elem, typeString(t.Elt))
+		if err := g.decode(elem, t.Elt); err != nil {
+			return err
+		}
+		g.printf("%v = append(%v, %v)\n", v, v, elem)
+		g.printf("}\n} else {\n%v = nil\n}\n", v)
+		return nil
+	case *ast.MapType:
+		l, i := g.tmp("l"), g.tmp("i")
+		g.printf("%v, err := d.ReadLength()\nif err != nil {\nreturn err\n}\n", l)
+		g.printf("%v = make(%v)\n", v, typeString(t))
+		g.printf("for %v := 0; %v < %v; %v++ {\n", i, i, l, i)
+		key, val := g.tmp("k"), g.tmp("v")
+		g.printf("var %v %v\nvar %v %v\n", key, typeString(t.Key), val, typeString(t.Value))
+		if err := g.decode(key, t.Key); err != nil {
+			return err
+		}
+		if err := g.decode(val, t.Value); err != nil {
+			return err
+		}
+		g.printf("%v[%v] = %v\n}\n", v, key, val)
+		return nil
+	}
+	return fmt.Errorf("unsupported type %v", typeString(expr))
+}
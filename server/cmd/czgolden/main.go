@@ -0,0 +1,40 @@
+// czgolden writes the golden encodings of the conformance package as JSON.
+// Clients in other languages encode the value of each vector in its format
+// and compare the result with its hex, then decode the hex back to the value.
+//
+// Usage with go generate:
+//
+//	//go:generate go run ../cmd/czgolden -output testdata/golden.json
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/isaiahwong/cz4013/conformance"
+)
+
+func main() {
+	var output string
+	flag.StringVar(&output, "output", "golden.json", "Output file")
+	flag.Parse()
+
+	if err := run(output); err != nil {
+		fmt.Fprintln(os.Stderr, "czgolden:", err)
+		os.Exit(1)
+	}
+}
+
+func run(output string) error {
+	g, err := conformance.Generate()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, append(b, '\n'), 0644)
+}
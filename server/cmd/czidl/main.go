@@ -0,0 +1,597 @@
+// czidl compiles an IDL of messages and services into the rpc package.
+// It generates the message structs, typed requests of methods, a server
+// interface registering the methods of a service, typed client stubs and
+// descriptors of the methods, along with a JSON schema for other clients.
+//
+// Usage with go generate:
+//
+//	//go:generate go run ../cmd/czidl -output flight_gen.go -schema flight.schema.json flight.idl
+//
+// Codecs of the generated messages are generated by czgen.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// basicTypes of message fields and query params
+var basicTypes = map[string]bool{
+	"bool":    true,
+	"string":  true,
+	"int":     true,
+	"int8":    true,
+	"int16":   true,
+	"int32":   true,
+	"int64":   true,
+	"uint":    true,
+	"uint8":   true,
+	"byte":    true,
+	"uint16":  true,
+	"uint32":  true,
+	"uint64":  true,
+	"float32": true,
+	"float64": true,
+}
+
+// initialisms are upper cased in Go names of query params
+var initialisms = map[string]bool{
+	"id":  true,
+	"url": true,
+	"rpc": true,
+}
+
+func main() {
+	var output string
+	var schema string
+	var encodingPkg string
+	flag.StringVar(&output, "output", "", "Output Go file. Derived from the IDL file if empty")
+	flag.StringVar(&schema, "schema", "", "Output JSON schema file. No schema is written if empty")
+	flag.StringVar(&encodingPkg, "encoding", "", "Import path of the encoding package. Derived from go.mod if empty")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	input := flag.Arg(0)
+	if output == "" {
+		output = strings.TrimSuffix(input, filepath.Ext(input)) + "_gen.go"
+	}
+
+	if err := run(input, output, schema, encodingPkg); err != nil {
+		fmt.Fprintln(os.Stderr, "czidl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(input string, output string, schema string, encodingPkg string) error {
+	dir := filepath.Dir(input)
+	if encodingPkg == "" {
+		module, err := modulePath(dir)
+		if err != nil {
+			return err
+		}
+		encodingPkg = module + "/encoding"
+	}
+	pkg, err := packageName(dir, filepath.Base(output))
+	if err != nil {
+		return err
+	}
+
+	r, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := parseFile(input, r)
+	if err != nil {
+		return err
+	}
+	if err = check(f); err != nil {
+		return err
+	}
+
+	g := &generator{file: f}
+	g.printf("// Code generated by czidl from %v. DO NOT EDIT.\n\n", filepath.Base(input))
+	g.printf("package %v\n\n", pkg)
+	g.printf("import (\n\"context\"\n\"reflect\"\n\"strconv\"\n\n%q\n)\n", encodingPkg)
+	g.generate()
+	// strconv is only used by requests with params other than strings
+	if !g.strconv {
+		g.printf("\nvar _ = strconv.Itoa\n")
+	}
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting output: %v", err)
+	}
+	if err = os.WriteFile(output, src, 0644); err != nil {
+		return err
+	}
+
+	if schema == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(newSchema(f), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(schema, append(b, '\n'), 0644)
+}
+
+// modulePath returns the module path of the go.mod enclosing dir
+func modulePath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		b, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err == nil {
+			for _, line := range strings.Split(string(b), "\n") {
+				if strings.HasPrefix(line, "module ") {
+					return strings.TrimSpace(strings.TrimPrefix(line, "module ")), nil
+				}
+			}
+			return "", errors.New("module path not found in go.mod")
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("go.mod not found")
+		}
+		dir = parent
+	}
+}
+
+// packageName returns the name of the package in dir, skipping output
+func packageName(dir string, output string) (string, error) {
+	pkgs, err := goparser.ParseDir(token.NewFileSet(), dir, func(fi os.FileInfo) bool {
+		return fi.Name() != output && !strings.HasSuffix(fi.Name(), "_test.go")
+	}, goparser.PackageClauseOnly)
+	if err != nil {
+		return "", err
+	}
+	if len(pkgs) != 1 {
+		return "", fmt.Errorf("expected a single package in %v, found %v", dir, len(pkgs))
+	}
+	for name := range pkgs {
+		return name, nil
+	}
+	return "", nil
+}
+
+// check validates the names and types of the declarations of f
+func check(f *file) error {
+	messages := make(map[string]bool)
+	for _, m := range f.messages {
+		if messages[m.name] || basicTypes[m.name] {
+			return fmt.Errorf("duplicate message %v", m.name)
+		}
+		messages[m.name] = true
+	}
+
+	// valid reports whether typ is a basic type or message with pointer and slice prefixes
+	valid := func(typ string) bool {
+		name := strings.TrimLeft(typ, "*[]")
+		return basicTypes[name] || messages[name]
+	}
+
+	for _, m := range f.messages {
+		fields := make(map[string]bool)
+		for _, field := range m.fields {
+			if fields[field.name] {
+				return fmt.Errorf("%v: duplicate field %v", m.name, field.name)
+			}
+			fields[field.name] = true
+			if !isExported(field.name) {
+				return fmt.Errorf("%v.%v: fields must be exported", m.name, field.name)
+			}
+			if !valid(field.typ) {
+				return fmt.Errorf("%v.%v: unknown type %v", m.name, field.name, field.typ)
+			}
+		}
+	}
+
+	methods := make(map[string]bool)
+	for _, s := range f.services {
+		for _, m := range s.methods {
+			if methods[m.name] {
+				return fmt.Errorf("%v: duplicate method %v", s.name, m.name)
+			}
+			methods[m.name] = true
+			if !isExported(m.name) {
+				return fmt.Errorf("%v.%v: methods must be exported", s.name, m.name)
+			}
+
+			params := make(map[string]bool)
+			for _, p := range m.params {
+				if params[p.name] {
+					return fmt.Errorf("%v.%v: duplicate param %v", s.name, m.name, p.name)
+				}
+				params[p.name] = true
+				if !basicTypes[p.typ] {
+					return fmt.Errorf("%v.%v: param %v must be of a basic type, found %v", s.name, m.name, p.name, p.typ)
+				}
+			}
+			for _, typ := range []string{m.returns, m.pushes} {
+				if typ != "" && !valid(typ) {
+					return fmt.Errorf("%v.%v: unknown type %v", s.name, m.name, typ)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func isExported(name string) bool {
+	return name != "" && unicode.IsUpper(rune(name[0]))
+}
+
+// goName returns the Go name of query param name e.g. meal_id is MealID
+func goName(name string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part == "" {
+			continue
+		}
+		if initialisms[strings.ToLower(part)] {
+			b.WriteString(strings.ToUpper(part))
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// generator generates the Go code of an IDL file
+type generator struct {
+	buf  bytes.Buffer
+	file *file
+
+	// Whether strconv is used
+	strconv bool
+}
+
+// printf writes formatted code to the output
+func (g *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&g.buf, format, args...)
+}
+
+// doc writes doc comments
+func (g *generator) doc(doc []string) {
+	for _, line := range doc {
+		g.printf("// %v\n", line)
+	}
+}
+
+func (g *generator) generate() {
+	for _, s := range g.file.services {
+		g.printf("\n// Methods of the %v service\nconst (\n", s.name)
+		for _, m := range s.methods {
+			g.printf("Method%v = %q\n", m.name, m.name)
+		}
+		g.printf(")\n")
+	}
+
+	for _, m := range g.file.messages {
+		g.printf("\n")
+		g.doc(m.doc)
+		g.printf("type %v struct {\n", m.name)
+		for _, f := range m.fields {
+			g.printf("%v %v\n", f.name, f.typ)
+		}
+		g.printf("}\n")
+	}
+
+	for _, s := range g.file.services {
+		for _, m := range s.methods {
+			g.request(m)
+		}
+		g.server(s)
+		g.descriptor(s)
+		g.client(s)
+	}
+}
+
+// request generates the request type of method m
+func (g *generator) request(m *method) {
+	name := m.name + "Request"
+	g.printf("\n// %v is the request of %v sent as query params\n", name, m.name)
+	g.printf("type %v struct {\n", name)
+	for _, p := range m.params {
+		if p.optional {
+			g.printf("%v *%v // Optional\n", goName(p.name), p.typ)
+		} else {
+			g.printf("%v %v\n", goName(p.name), p.typ)
+		}
+	}
+	g.printf("}\n")
+
+	// Query
+	g.printf("\n// Query returns the query params of the request\n")
+	g.printf("func (x *%v) Query() map[string]string {\n", name)
+	g.printf("q := make(map[string]string)\n")
+	for _, p := range m.params {
+		v := "x." + goName(p.name)
+		if p.optional {
+			g.printf("if %v != nil {\n", v)
+			v = "*" + v
+		}
+		g.printf("q[%q] = %v\n", p.name, g.format(v, p.typ))
+		if p.optional {
+			g.printf("}\n")
+		}
+	}
+	g.printf("return q\n}\n")
+
+	// ParseQuery
+	g.printf("\n// ParseQuery sets the request from query params. Empty params are missing\n")
+	g.printf("func (x *%v) ParseQuery(q map[string]string) error {\n", name)
+	for _, p := range m.params {
+		field := "x." + goName(p.name)
+		if p.optional {
+			g.printf("if v := q[%q]; v != \"\" {\n", p.name)
+		} else {
+			g.printf("if v := q[%q]; v == \"\" {\nreturn invalidParam(%q, nil)\n} else {\n", p.name, p.name)
+		}
+		value := "v"
+		if p.typ != "string" {
+			g.printf("n, err := %v\nif err != nil {\nreturn invalidParam(%q, err)\n}\n", g.parse("v", p.typ), p.name)
+			value = fmt.Sprintf("%v(n)", p.typ)
+		}
+		if p.optional {
+			g.printf("%v = new(%v)\n*%v = %v\n", field, p.typ, field, value)
+		} else {
+			g.printf("%v = %v\n", field, value)
+		}
+		g.printf("}\n")
+	}
+	g.printf("return nil\n}\n")
+}
+
+// format returns the expression formatting v of basic type typ as a string
+func (g *generator) format(v string, typ string) string {
+	if typ != "string" {
+		g.strconv = true
+	}
+	switch typ {
+	case "string":
+		return v
+	case "bool":
+		return fmt.Sprintf("strconv.FormatBool(%v)", v)
+	case "float32":
+		return fmt.Sprintf("strconv.FormatFloat(float64(%v), 'g', -1, 32)", v)
+	case "float64":
+		return fmt.Sprintf("strconv.FormatFloat(%v, 'g', -1, 64)", v)
+	}
+	if strings.HasPrefix(typ, "int") {
+		return fmt.Sprintf("strconv.FormatInt(int64(%v), 10)", v)
+	}
+	return fmt.Sprintf("strconv.FormatUint(uint64(%v), 10)", v)
+}
+
+// parse returns the expression parsing string v to basic type typ
+func (g *generator) parse(v string, typ string) string {
+	g.strconv = true
+	bits := strings.TrimLeft(typ, "uintfloat")
+	if typ == "byte" {
+		bits = "8"
+	}
+	if bits == "" {
+		bits = "0"
+	}
+	switch {
+	case typ == "bool":
+		return fmt.Sprintf("strconv.ParseBool(%v)", v)
+	case strings.HasPrefix(typ, "float"):
+		return fmt.Sprintf("strconv.ParseFloat(%v, %v)", v, bits)
+	case strings.HasPrefix(typ, "int"):
+		return fmt.Sprintf("strconv.ParseInt(%v, 10, %v)", v, bits)
+	default:
+		return fmt.Sprintf("strconv.ParseUint(%v, 10, %v)", v, bits)
+	}
+}
+
+// server generates the server interface of s and its registration
+func (g *generator) server(s *service) {
+	name := s.name + "Server"
+	g.printf("\n// %v handles the methods of the %v service\n", name, s.name)
+	g.printf("type %v interface {\n", name)
+	for i, m := range s.methods {
+		if i > 0 {
+			g.printf("\n")
+		}
+		g.doc(m.doc)
+		if m.returns == "" {
+			g.printf("%v(ctx context.Context, req *%vRequest) error\n", m.name, m.name)
+		} else {
+			g.printf("%v(ctx context.Context, req *%vRequest) (%v, error)\n", m.name, m.name, m.returns)
+		}
+	}
+	g.printf("}\n")
+
+	// Replies of methods pushing bodies acknowledge the subscription hence are not dropped
+	g.printf("\n// Register%v registers the methods of the %v service handled by srv\n", name, s.name)
+	g.printf("func Register%v(r *RPC, srv %v) {\n", name, name)
+	for _, m := range s.methods {
+		opts := fmt.Sprintf("WithIdempotency(%v)", idempotencies[m.idempotency])
+		if m.pushes != "" {
+			opts += ", WithLossless()"
+		}
+		if m.returns == "" {
+			g.printf("Register(r, Method%v, func(ctx context.Context, req *%vRequest) (Empty, error) {\n", m.name, m.name)
+			g.printf("return Empty{}, srv.%v(ctx, req)\n}, %v)\n", m.name, opts)
+		} else {
+			g.printf("Register(r, Method%v, srv.%v, %v)\n", m.name, m.name, opts)
+		}
+	}
+	g.printf("}\n")
+}
+
+// typeOf returns the expression of the reflect.Type of typ
+func typeOf(typ string) string {
+	if typ == "" {
+		return "nil"
+	}
+	return fmt.Sprintf("reflect.TypeOf((%v)(nil)).Elem()", "*"+typ)
+}
+
+// descriptor generates the descriptor of s
+func (g *generator) descriptor(s *service) {
+	g.printf("\n// %vService describes the %v service\n", s.name, s.name)
+	g.printf("var %vService = ServiceDesc{\nName: %q,\nMethods: []MethodDesc{\n", s.name, s.name)
+	for _, m := range s.methods {
+		g.printf("{\nName: Method%v,\nIdempotency: %v,\n", m.name, idempotencies[m.idempotency])
+		if len(m.params) > 0 {
+			g.printf("Params: []ParamDesc{\n")
+			for _, p := range m.params {
+				if p.optional {
+					g.printf("{Name: %q, Type: %q, Optional: true},\n", p.name, p.typ)
+				} else {
+					g.printf("{Name: %q, Type: %q},\n", p.name, p.typ)
+				}
+			}
+			g.printf("},\n")
+		}
+		g.printf("Request: %v,\n", typeOf(m.name+"Request"))
+		if m.returns != "" {
+			g.printf("Response: %v,\n", typeOf(m.returns))
+		}
+		if m.pushes != "" {
+			g.printf("Push: %v,\n", typeOf(m.pushes))
+		}
+		g.printf("},\n")
+	}
+	g.printf("},\n}\n")
+}
+
+// client generates the client stubs of s
+func (g *generator) client(s *service) {
+	name := s.name + "Client"
+	g.printf("\n// %v calls the methods of the %v service through an Invoker\n", name, s.name)
+	g.printf("type %v struct {\ninvoker Invoker\nopts []encoding.Option\n}\n", name)
+	g.printf("\n// New%v returns a client of invoker decoding replies with opts\n", name)
+	g.printf("func New%v(invoker Invoker, opts ...encoding.Option) *%v {\n", name, name)
+	g.printf("return &%v{invoker: invoker, opts: opts}\n}\n", name)
+
+	for _, m := range s.methods {
+		g.printf("\n")
+		g.doc(m.doc)
+		req := m.name + "Request"
+		if m.returns == "" {
+			g.printf("func (c *%v) %v(ctx context.Context, in *%v) error {\n", name, m.name, req)
+			g.printf("return invoke(ctx, c.invoker, Method%v, in.Query(), nil, c.opts)\n}\n", m.name)
+		} else {
+			g.printf("func (c *%v) %v(ctx context.Context, in *%v) (%v, error) {\n", name, m.name, req, m.returns)
+			g.printf("%v\n", g.declare("out", m.returns))
+			g.printf("err := invoke(ctx, c.invoker, Method%v, in.Query(), %v, c.opts)\n", m.name, g.target("out", m.returns))
+			g.printf("return out, err\n}\n")
+		}
+
+		if m.pushes != "" {
+			g.printf("\n// Decode%v decodes a body pushed by %v\n", m.name, m.name)
+			g.printf("func (c *%v) Decode%v(body []byte) (%v, error) {\n", name, m.name, m.pushes)
+			g.printf("%v\n", g.declare("out", m.pushes))
+			g.printf("err := encoding.Unmarshal(body, %v, c.opts...)\n", g.target("out", m.pushes))
+			g.printf("return out, err\n}\n")
+		}
+	}
+}
+
+// declare declares v of typ to decode a body into. Pointers are allocated.
+func (g *generator) declare(v string, typ string) string {
+	if strings.HasPrefix(typ, "*") {
+		return fmt.Sprintf("%v := new(%v)", v, typ[1:])
+	}
+	return fmt.Sprintf("var %v %v", v, typ)
+}
+
+// target returns the expression to decode a body into v of typ.
+// Values other than pointers are decoded through a pointer.
+func (g *generator) target(v string, typ string) string {
+	if strings.HasPrefix(typ, "*") {
+		return v
+	}
+	return "&" + v
+}
+
+// schema is the machine readable description of an IDL file
+type schema struct {
+	Messages []schemaMessage `json:"messages"`
+	Services []schemaService `json:"services"`
+}
+
+type schemaMessage struct {
+	Name   string        `json:"name"`
+	Doc    string        `json:"doc,omitempty"`
+	Fields []schemaField `json:"fields"`
+}
+
+type schemaField struct {
+	Name   string `json:"name"`
+	Number int    `json:"number"`
+	Type   string `json:"type"`
+}
+
+type schemaService struct {
+	Name    string         `json:"name"`
+	Doc     string         `json:"doc,omitempty"`
+	Methods []schemaMethod `json:"methods"`
+}
+
+type schemaMethod struct {
+	Name        string        `json:"name"`
+	Doc         string        `json:"doc,omitempty"`
+	Idempotency string        `json:"idempotency"`
+	Params      []schemaParam `json:"params"`
+	Returns     string        `json:"returns,omitempty"`
+	Pushes      string        `json:"pushes,omitempty"`
+}
+
+type schemaParam struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// newSchema returns the schema of f. Fields are numbered in order as encoded.
+func newSchema(f *file) *schema {
+	s := &schema{Messages: []schemaMessage{}, Services: []schemaService{}}
+	for _, m := range f.messages {
+		sm := schemaMessage{Name: m.name, Doc: strings.Join(m.doc, "\n"), Fields: []schemaField{}}
+		for i, field := range m.fields {
+			sm.Fields = append(sm.Fields, schemaField{Name: field.name, Number: i + 1, Type: field.typ})
+		}
+		s.Messages = append(s.Messages, sm)
+	}
+	for _, svc := range f.services {
+		ss := schemaService{Name: svc.name, Doc: strings.Join(svc.doc, "\n"), Methods: []schemaMethod{}}
+		for _, m := range svc.methods {
+			sm := schemaMethod{
+				Name:        m.name,
+				Doc:         strings.Join(m.doc, "\n"),
+				Idempotency: idempotencies[m.idempotency],
+				Params:      []schemaParam{},
+				Returns:     m.returns,
+				Pushes:      m.pushes,
+			}
+			for _, p := range m.params {
+				sm.Params = append(sm.Params, schemaParam{Name: p.name, Type: p.typ, Optional: p.optional})
+			}
+			ss.Methods = append(ss.Methods, sm)
+		}
+		s.Services = append(s.Services, ss)
+	}
+	return s
+}
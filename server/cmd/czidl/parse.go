@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/scanner"
+)
+
+// The grammar of an IDL file. Comments preceding a declaration document it.
+//
+//	file        = { message | service } .
+//	message     = "message" name "{" { field } "}" .
+//	field       = name type .
+//	service     = "service" name "{" { method } "}" .
+//	method      = idempotency name "(" [ param { "," param } ] ")" [ "returns" type ] [ "pushes" type ] .
+//	idempotency = "readonly" | "idempotent" | "nonidempotent" .
+//	param       = [ "optional" ] name type .
+//	type        = { "*" | "[" "]" } name .
+
+// file is a parsed IDL file
+type file struct {
+	messages []*message
+	services []*service
+}
+
+// message is a struct type encoded by the encoding package
+type message struct {
+	doc    []string
+	name   string
+	fields []*messageField
+}
+
+type messageField struct {
+	name string
+	typ  string
+}
+
+// service is a set of methods served by an RPC
+type service struct {
+	doc     []string
+	name    string
+	methods []*method
+}
+
+type method struct {
+	doc         []string
+	name        string
+	idempotency string
+	params      []*param
+	returns     string // Type of the reply body. Empty if the reply has no body
+	pushes      string // Type of bodies pushed to the client. Empty if none are pushed
+}
+
+// param is a query param of a request
+type param struct {
+	name     string
+	typ      string
+	optional bool
+}
+
+// idempotencies maps IDL keywords to rpc.Idempotency constants
+var idempotencies = map[string]string{
+	"readonly":      "ReadOnly",
+	"idempotent":    "Idempotent",
+	"nonidempotent": "NonIdempotent",
+}
+
+// parser parses an IDL file
+type parser struct {
+	s   scanner.Scanner
+	tok rune
+
+	// Comment lines preceding the current token
+	comments []string
+	// Line of the last comment
+	commentLine int
+
+	err error
+}
+
+// parseFile parses the IDL of r named filename
+func parseFile(filename string, r io.Reader) (*file, error) {
+	p := &parser{}
+	p.s.Init(r)
+	p.s.Filename = filename
+	p.s.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanComments
+	p.s.Error = func(s *scanner.Scanner, msg string) {
+		p.fail(msg)
+	}
+	p.next()
+
+	f := &file{}
+	for p.tok != scanner.EOF && p.err == nil {
+		doc := p.doc()
+		switch p.ident() {
+		case "message":
+			m := p.message()
+			m.doc = doc
+			f.messages = append(f.messages, m)
+		case "service":
+			s := p.service()
+			s.doc = doc
+			f.services = append(f.services, s)
+		default:
+			p.fail("expected message or service")
+		}
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return f, nil
+}
+
+// next advances to the next token, collecting comments
+func (p *parser) next() {
+	for {
+		p.tok = p.s.Scan()
+		if p.tok != scanner.Comment {
+			return
+		}
+		line := p.s.Position.Line
+		if line > p.commentLine+1 {
+			p.comments = nil
+		}
+		text := strings.TrimSpace(strings.TrimPrefix(p.s.TokenText(), "//"))
+		p.comments = append(p.comments, text)
+		p.commentLine = line
+	}
+}
+
+// doc returns the comments directly preceding the current token
+func (p *parser) doc() []string {
+	doc := p.comments
+	if p.commentLine != p.s.Position.Line-1 {
+		doc = nil
+	}
+	p.comments = nil
+	return doc
+}
+
+// fail records the first error at the current position
+func (p *parser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("%v: %v", p.s.Position, fmt.Sprintf(format, args...))
+	}
+	p.tok = scanner.EOF
+}
+
+// expect consumes tok
+func (p *parser) expect(tok rune) {
+	if p.tok != tok {
+		p.fail("expected %v, found %v", scanner.TokenString(tok), scanner.TokenString(p.tok))
+		return
+	}
+	p.next()
+}
+
+// ident consumes an identifier and returns it
+func (p *parser) ident() string {
+	name := p.s.TokenText()
+	p.expect(scanner.Ident)
+	return name
+}
+
+// typ consumes a type
+func (p *parser) typ() string {
+	var prefix string
+	for {
+		if p.tok == '*' {
+			prefix += "*"
+			p.next()
+		} else if p.tok == '[' {
+			p.next()
+			p.expect(']')
+			prefix += "[]"
+		} else {
+			return prefix + p.ident()
+		}
+	}
+}
+
+func (p *parser) message() *message {
+	m := &message{name: p.ident()}
+	p.expect('{')
+	for p.tok != '}' && p.err == nil {
+		p.doc()
+		name := p.ident()
+		m.fields = append(m.fields, &messageField{name: name, typ: p.typ()})
+	}
+	p.expect('}')
+	return m
+}
+
+func (p *parser) service() *service {
+	s := &service{name: p.ident()}
+	p.expect('{')
+	for p.tok != '}' && p.err == nil {
+		doc := p.doc()
+		m := p.method()
+		m.doc = doc
+		s.methods = append(s.methods, m)
+	}
+	p.expect('}')
+	return s
+}
+
+func (p *parser) method() *method {
+	m := &method{idempotency: p.ident()}
+	if _, ok := idempotencies[m.idempotency]; !ok {
+		p.fail("unknown idempotency %v, expected readonly, idempotent or nonidempotent", m.idempotency)
+		return m
+	}
+	m.name = p.ident()
+
+	p.expect('(')
+	for p.tok != ')' && p.err == nil {
+		if len(m.params) > 0 {
+			p.expect(',')
+		}
+		param := &param{name: p.ident()}
+		if param.name == "optional" && p.tok == scanner.Ident {
+			param.optional = true
+			param.name = p.ident()
+		}
+		param.typ = p.typ()
+		m.params = append(m.params, param)
+	}
+	p.expect(')')
+
+	if p.tok == scanner.Ident && p.s.TokenText() == "returns" {
+		p.next()
+		m.returns = p.typ()
+	}
+	if p.tok == scanner.Ident && p.s.TokenText() == "pushes" {
+		p.next()
+		m.pushes = p.typ()
+	}
+	return m
+}
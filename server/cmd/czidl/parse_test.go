@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testIDL = `// Seat of a flight
+message Seat {
+	Row    int32
+	Taken  bool
+}
+
+// Cabin of seats
+// spanning rows
+message Cabin {
+	Seats [][]*Seat
+	Class string
+}
+
+// Detached comment
+
+service Booking {
+	// Book books a seat
+	nonidempotent Book(row int32, optional note string) returns *Seat
+	readonly Cabins() returns []*Cabin
+	idempotent Watch(interval uint32) pushes *Seat
+}
+`
+
+// TestParseFile verifies declarations, types, params and docs are parsed
+func TestParseFile(t *testing.T) {
+	f, err := parseFile("test.idl", strings.NewReader(testIDL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = check(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.messages) != 2 || len(f.services) != 1 {
+		t.Fatalf("got %v messages and %v services", len(f.messages), len(f.services))
+	}
+
+	seat, cabin := f.messages[0], f.messages[1]
+	if seat.name != "Seat" || !reflect.DeepEqual(seat.doc, []string{"Seat of a flight"}) {
+		t.Fatalf("got message %v documented %q", seat.name, seat.doc)
+	}
+	if !reflect.DeepEqual(cabin.doc, []string{"Cabin of seats", "spanning rows"}) {
+		t.Fatalf("got doc %q", cabin.doc)
+	}
+	fields := []messageField{}
+	for _, field := range append(seat.fields, cabin.fields...) {
+		fields = append(fields, *field)
+	}
+	want := []messageField{{"Row", "int32"}, {"Taken", "bool"}, {"Seats", "[][]*Seat"}, {"Class", "string"}}
+	if !reflect.DeepEqual(fields, want) {
+		t.Fatalf("got fields %+v, want %+v", fields, want)
+	}
+
+	s := f.services[0]
+	if s.name != "Booking" || s.doc != nil || len(s.methods) != 3 {
+		t.Fatalf("got service %v documented %q with %v methods", s.name, s.doc, len(s.methods))
+	}
+	book, cabins, watch := s.methods[0], s.methods[1], s.methods[2]
+	if book.idempotency != "nonidempotent" || book.returns != "*Seat" || book.pushes != "" ||
+		!reflect.DeepEqual(book.doc, []string{"Book books a seat"}) {
+		t.Fatalf("got %+v", book)
+	}
+	params := []param{*book.params[0], *book.params[1]}
+	if want := []param{{"row", "int32", false}, {"note", "string", true}}; !reflect.DeepEqual(params, want) {
+		t.Fatalf("got params %+v, want %+v", params, want)
+	}
+	if cabins.idempotency != "readonly" || len(cabins.params) != 0 || cabins.returns != "[]*Cabin" || cabins.doc != nil {
+		t.Fatalf("got %+v", cabins)
+	}
+	if watch.idempotency != "idempotent" || watch.returns != "" || watch.pushes != "*Seat" {
+		t.Fatalf("got %+v", watch)
+	}
+}
+
+// TestParseErrors verifies malformed and invalid IDL is rejected
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		idl  string
+		want string
+	}{
+		{"enum Seat {}", "expected message or service"},
+		{"message Seat { Row }", "test.idl:1:20: expected Ident"},
+		{"message Seat { Row [int32 }", "expected \"]\""},
+		{"service S { cached Get() }", "unknown idempotency cached"},
+		{"service S { readonly Get(a int32 b int32) }", "expected \",\""},
+		{"message Seat {", "expected Ident, found EOF"},
+		{"message Seat { Row int32 }\nmessage Seat { Row int32 }", "duplicate message Seat"},
+		{"message Seat { row int32 }", "Seat.row: fields must be exported"},
+		{"message Seat { Row Column }", "Seat.Row: unknown type Column"},
+		{"message Seat { Row int32 }\nservice S { readonly Get(seat Seat) }", "param seat must be of a basic type"},
+		{"service S { readonly Get() returns *Seat }", "S.Get: unknown type *Seat"},
+		{"service S { readonly get() }", "S.get: methods must be exported"},
+		{"service S { readonly Get() }\nservice T { readonly Get() }", "T: duplicate method Get"},
+	}
+	for _, test := range tests {
+		f, err := parseFile("test.idl", strings.NewReader(test.idl))
+		if err == nil {
+			err = check(f)
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Fatalf("%q: got %v, want %v", test.idl, err, test.want)
+		}
+	}
+}
+
+// TestParseFlightIDL verifies the IDL of the flight service parses and checks
+func TestParseFlightIDL(t *testing.T) {
+	r, err := os.Open("../../rpc/flight.idl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	f, err := parseFile("flight.idl", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = check(f); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -21,6 +21,7 @@ var (
 	GetMeals         = "GetMeals"
 	AddMeals         = "AddMeals"
 	MonitorUpdates   = "MonitorUpdates"
+	Diagnostics      = "Diagnostics"
 	Exit             = "Exit"
 )
 
@@ -77,6 +78,8 @@ func (a *App) topLevel() {
 		a.AddMeals()
 	case MonitorUpdates:
 		a.MonitorUpdates()
+	case Diagnostics:
+		a.Diagnostics()
 	case Exit:
 		close()
 		return
@@ -101,7 +104,8 @@ func New(c *client.Client) *App {
 			FindFlights, FindFlight,
 			ReserveFlight, CancelFlight,
 			ViewReservations,
-			AddMeals, MonitorUpdates, Exit,
+			AddMeals, MonitorUpdates,
+			Diagnostics, Exit,
 		},
 		Size: 10,
 	}
@@ -389,3 +389,22 @@ func (a *App) ViewReservations() {
 	w.Flush()
 
 }
+
+// Diagnostics prints the round trip estimates of the server
+func (a *App) Diagnostics() {
+	stats, ok := a.c.RTTStats()
+	if !ok {
+		a.logger.Info("No round trips sampled")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 4, ' ', tabwriter.TabIndent)
+	common.PrintTitle("Round Trip Estimates")
+	fmt.Fprint(w, common.TitleValueLine("SRTT", stats.SRTT, 1))
+	fmt.Fprint(w, common.TitleValueLine("RTTVAR", stats.RTTVar, 1))
+	fmt.Fprint(w, common.TitleValueLine("RTO", stats.RTO, 1))
+	fmt.Fprint(w, common.TitleValueLine("Last", stats.Last, 1))
+	fmt.Fprint(w, common.TitleValueLine("Samples", stats.Samples, 1))
+	// Flush the tabwriter to write the output
+	w.Flush()
+}
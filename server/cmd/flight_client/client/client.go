@@ -1,10 +1,12 @@
 package client
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/isaiahwong/cz4013/common"
@@ -21,8 +23,14 @@ type Client struct {
 	session      *protocol.Session
 	logger       *logrus.Logger
 	mtu          int
-	retries      int
 	Reservations map[string]*rpc.ReserveFlight
+
+	// Handlers for server initiated streams
+	handlersMux sync.Mutex
+	handlers    map[string]PushHandler
+
+	// Stubs of the flight service invoked through the client
+	flights *rpc.FlightSystemClient
 }
 
 func (c *Client) open() (*protocol.Stream, error) {
@@ -33,80 +41,188 @@ func (c *Client) openWithExisting(stream *protocol.Stream) (*protocol.Stream, er
 	return c.session.OpenWithExisting(c.remoteAddr, stream)
 }
 
-// sendOnly -- sends a request only
-func (c *Client) sendOnly(stream *protocol.Stream, method string, query map[string]string, deadline *time.Duration) error {
-	// Request
-	req := &rpc.Message{
-		RPC:   method,
-		Query: query,
-		Body:  []byte{},
-	}
-	b, err := encoding.Marshal(req)
+// encoding returns the options of messages exchanged on streams opened by the client
+func (c *Client) encoding() []encoding.Option {
+	return []encoding.Option{encoding.WithCompact(c.opts.compact)}
+}
+
+// RTTStats returns the round trip estimates of the server
+func (c *Client) RTTStats() (protocol.RTTStats, bool) {
+	return c.session.RTTStats(c.remoteAddr)
+}
+
+// timeout returns the response timeout of a retry attempt. The timeout is derived
+// from the estimated round trip of the server with exponential backoff and jitter.
+// initial is used as the timeout until a round trip is sampled.
+func (c *Client) timeout(initial time.Duration, attempt int) time.Duration {
+	return protocol.Backoff(c.session.RTO(c.remoteAddr, initial), attempt)
+}
+
+// result is the outcome of an attempt of a call on a stream
+type result struct {
+	m      *rpc.Message
+	stream *protocol.Stream
+	err    error
+}
+
+// call sends the request message m and waits for its reply. Failed attempts are
+// retried and outstanding attempts hedged as decided by the retry policy. Every
+// attempt is sent on a stream of the same request so the server recognises retries.
+func (c *Client) call(ctx context.Context, m *rpc.Message) (*rpc.Message, error) {
+	b, err := encoding.Marshal(m, c.encoding()...)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	stream.Write(b)
-	return nil
-}
 
-// send -- sends a request and waits for a response
-func (c *Client) send(stream *protocol.Stream, method string, query map[string]string, deadline *time.Duration) (*rpc.Message, *protocol.Stream, error) {
-	var err error
-	var m *rpc.Message
-	var n int
-	tries := 0
+	policy := c.opts.retry
+	attempts := policy.Attempts(m.RPC)
+	hedge := policy.Hedge(m.RPC)
 
-	retrySend := func(stream *protocol.Stream) (*rpc.Message, error) {
-		// Request
-		err = c.sendOnly(stream, method, query, deadline)
-		if err != nil {
-			return nil, err
+	// Streams of the attempts are closed once the call completes
+	streams := []*protocol.Stream{}
+	defer func() {
+		for _, stream := range streams {
+			stream.Close()
 		}
+	}()
 
-		// Response
-		res := make([]byte, c.mtu)
-		if deadline != nil {
-			stream.SetReadDeadline(time.Now().Add(*deadline))
+	results := make(chan result, attempts)
+	var hedgeC <-chan time.Time
+	send := func() error {
+		var stream *protocol.Stream
+		var err error
+		if len(streams) == 0 {
+			stream, err = c.open()
+		} else {
+			stream, err = c.openWithExisting(streams[0])
 		}
-		n, err = stream.Read(res)
-		if err != nil && err != io.EOF {
-			return nil, err
+		if err != nil {
+			return err
 		}
+		streams = append(streams, stream)
 
-		m = new(rpc.Message)
-		if err = encoding.Unmarshal(res[:n], m); err != nil && err != io.EOF {
-			return nil, err
+		go func(attempt int) {
+			reply, err := c.attempt(ctx, stream, b, attempt)
+			results <- result{m: reply, stream: stream, err: err}
+		}(len(streams) - 1)
+
+		hedgeC = nil
+		if hedge > 0 && len(streams) < attempts {
+			hedgeC = time.After(hedge)
 		}
-		return m, err
+		return nil
+	}
+
+	if err = send(); err != nil {
+		return nil, err
 	}
+	outstanding := 1
+	for outstanding > 0 {
+		select {
+		case <-ctx.Done():
+			// Release the work of outstanding attempts on the server
+			for _, stream := range streams {
+				stream.Cancel()
+			}
+			return nil, ctx.Err()
+
+		case <-hedgeC:
+			c.logger.Info(fmt.Sprintf("[Hedging] method=%v query=%v attempts=%v", m.RPC, m.Query, len(streams)))
+			if err = send(); err != nil {
+				return nil, err
+			}
+			outstanding++
 
-	for tries < c.retries {
-		m, err = retrySend(stream)
-		if err == nil {
-			break
+		case res := <-results:
+			outstanding--
+			if res.err == nil {
+				// Acknowledge reply so the server may release it
+				res.stream.Ack()
+				recordStream(ctx, res.stream)
+				return res.m, nil
+			}
+
+			err = res.err
+			c.logger.Error(err)
+			if !policy.Retryable(err) {
+				return nil, err
+			}
+			if outstanding > 0 || len(streams) >= attempts {
+				continue
+			}
+
+			stats, _ := c.RTTStats()
+			c.logger.WithFields(logrus.Fields{
+				"method": m.RPC,
+				"query":  m.Query,
+				"tries":  len(streams),
+				"srtt":   stats.SRTT,
+				"rttvar": stats.RTTVar,
+				"rto":    stats.RTO,
+			}).Info(fmt.Sprintf("[Retrying] method=%v query=%v tries=%v", m.RPC, m.Query, len(streams)))
+
+			if err = send(); err != nil {
+				return nil, err
+			}
+			outstanding++
 		}
+	}
+	if err == nil {
+		err = errors.New("No response received from server.")
+	}
+	return nil, err
+}
 
-		c.logger.Error(err)
-		stream.Close()
+// attempt writes the request b on stream and reads its reply. The reply is
+// awaited for the timeout of the attempt bounded by the deadline of ctx.
+func (c *Client) attempt(ctx context.Context, stream *protocol.Stream, b []byte, attempt int) (*rpc.Message, error) {
+	if _, err := stream.Write(b); err != nil {
+		return nil, err
+	}
 
-		c.logger.WithFields(logrus.Fields{
-			"method": method,
-			"query":  query,
-			"tries":  tries,
-		}).Info(fmt.Sprintf("[Retrying] method=%v query=%v tries=%v", method, query, tries))
+	deadline := time.Now().Add(c.timeout(c.opts.deadline, attempt))
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	stream.SetReadDeadline(deadline)
 
-		stream, err = c.openWithExisting(stream)
-		tries++
+	res := make([]byte, c.mtu)
+	n, err := stream.Read(res)
+	if err != nil && err != io.EOF {
+		return nil, err
 	}
 
-	if err != nil {
-		return nil, stream, err
+	m := new(rpc.Message)
+	if err = encoding.Unmarshal(res[:n], m, c.encoding()...); err != nil {
+		return nil, err
 	}
-	if m == nil {
-		return nil, stream, errors.New("No response received from server.")
+	return m, nil
+}
+
+// streamKey is the context key recording the stream of a reply
+type streamKey struct{}
+
+// withStreamRecorder returns a context recording the stream replying to a call
+// made with it into stream, e.g. to cancel the request once the call returned
+func withStreamRecorder(ctx context.Context, stream **protocol.Stream) context.Context {
+	return context.WithValue(ctx, streamKey{}, stream)
+}
+
+// recordStream records the stream replying to a call made with ctx
+func recordStream(ctx context.Context, stream *protocol.Stream) {
+	if rec, ok := ctx.Value(streamKey{}).(**protocol.Stream); ok {
+		*rec = stream
 	}
+}
 
-	return m, stream, nil
+// Invoke sends the request message m through the interceptors of the client and
+// returns its reply. The call is abandoned once ctx is done.
+// Implements rpc.Invoker for the stubs of the client.
+func (c *Client) Invoke(ctx context.Context, m *rpc.Message) (*rpc.Message, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return c.intercept(ctx, m, c.call)
 }
 
 func (c *Client) Start() (err error) {
@@ -123,7 +239,14 @@ func (c *Client) Start() (err error) {
 	}
 
 	c.session = protocol.NewSession(c.conn, true)
+	c.session.SetCompact(c.opts.compact)
+	if c.opts.faults.Enabled() {
+		c.session.SetWriteHook(newInjector(c.opts.faults, c.logger).hook)
+	}
 	c.session.Start()
+
+	// Accept server initiated streams
+	go c.serve()
 	return
 }
 
@@ -133,7 +256,8 @@ func New(opt ...Option) *Client {
 		addr:     "localhost:8080",
 		logger:   common.NewLogger(),
 		deadline: time.Second * 5,
-		retries:  0,
+		retry:    MaxAttempts(1),
+		faults:   Faults{DelayBy: time.Second},
 	}
 
 	// Apply options
@@ -141,11 +265,13 @@ func New(opt ...Option) *Client {
 		o(&opts)
 	}
 
-	return &Client{
+	c := &Client{
 		opts:         opts,
 		logger:       opts.logger,
 		mtu:          65507,
-		retries:      opts.retries,
 		Reservations: make(map[string]*rpc.ReserveFlight),
+		handlers:     make(map[string]PushHandler),
 	}
+	c.flights = rpc.NewFlightSystemClient(c, c.encoding()...)
+	return c
 }
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isaiahwong/cz4013/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// Fault is an action applied to an outgoing frame
+type Fault byte
+
+const (
+	// FaultPass sends the frame
+	FaultPass Fault = iota
+	// FaultDrop discards the frame
+	FaultDrop
+	// FaultDelay sends the frame after the fault delay
+	FaultDelay
+	// FaultDuplicate sends the frame twice
+	FaultDuplicate
+)
+
+func (f Fault) String() string {
+	switch f {
+	case FaultPass:
+		return "pass"
+	case FaultDrop:
+		return "drop"
+	case FaultDelay:
+		return "delay"
+	case FaultDuplicate:
+		return "dup"
+	default:
+		return "unknown"
+	}
+}
+
+// ScriptedFault is a fault applied to the next outgoing frame matching Flag.
+// A nil Flag matches any frame.
+type ScriptedFault struct {
+	Flag  *byte
+	Fault Fault
+}
+
+// Faults simulates an unreliable network on outgoing client frames.
+// Scripted faults are applied in order before probabilities apply.
+type Faults struct {
+	Drop      int // percentage of frames dropped
+	Delay     int // percentage of frames delayed
+	Duplicate int // percentage of frames duplicated
+	DelayBy   time.Duration
+	Script    []ScriptedFault
+}
+
+// Enabled returns true if any fault is injected
+func (f Faults) Enabled() bool {
+	return f.Drop > 0 || f.Delay > 0 || f.Duplicate > 0 || len(f.Script) > 0
+}
+
+// ParseFaultScript parses a comma separated script of faults e.g. "drop,pass,DNE:drop,ACK:dup,RST:drop".
+// A fault prefixed with a frame flag applies to the next frame with that flag.
+func ParseFaultScript(s string) ([]ScriptedFault, error) {
+	script := []ScriptedFault{}
+	if strings.TrimSpace(s) == "" {
+		return script, nil
+	}
+
+	flags := map[string]byte{}
+	for _, flag := range []byte{protocol.SYN, protocol.PSH, protocol.DNE, protocol.NOP, protocol.FIN, protocol.ACK, protocol.RST} {
+		flags[protocol.FlagName(flag)] = flag
+	}
+	faults := map[string]Fault{}
+	for _, fault := range []Fault{FaultPass, FaultDrop, FaultDelay, FaultDuplicate} {
+		faults[fault.String()] = fault
+	}
+
+	for _, token := range strings.Split(s, ",") {
+		token = strings.TrimSpace(token)
+		sf := ScriptedFault{}
+		if i := strings.Index(token, ":"); i >= 0 {
+			flag, ok := flags[strings.ToUpper(token[:i])]
+			if !ok {
+				return nil, fmt.Errorf("unknown frame flag %q", token[:i])
+			}
+			sf.Flag = &flag
+			token = token[i+1:]
+		}
+		fault, ok := faults[strings.ToLower(token)]
+		if !ok {
+			return nil, fmt.Errorf("unknown fault %q", token)
+		}
+		sf.Fault = fault
+		script = append(script, sf)
+	}
+	return script, nil
+}
+
+// injector applies faults to outgoing frames of a session
+type injector struct {
+	mu     sync.Mutex
+	faults Faults
+	script []ScriptedFault
+	rand   *rand.Rand
+	logger *logrus.Logger
+}
+
+func newInjector(faults Faults, logger *logrus.Logger) *injector {
+	return &injector{
+		faults: faults,
+		script: faults.Script,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		logger: logger,
+	}
+}
+
+// next returns the fault of an outgoing frame
+func (i *injector) next(f protocol.Frame) Fault {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if len(i.script) > 0 {
+		head := i.script[0]
+		if head.Flag == nil || *head.Flag == f.Flag {
+			i.script = i.script[1:]
+			return head.Fault
+		}
+	}
+
+	r := i.rand.Intn(100)
+	switch {
+	case r < i.faults.Drop:
+		return FaultDrop
+	case r < i.faults.Drop+i.faults.Delay:
+		return FaultDelay
+	case r < i.faults.Drop+i.faults.Delay+i.faults.Duplicate:
+		return FaultDuplicate
+	default:
+		return FaultPass
+	}
+}
+
+// hook intercepts outgoing frames of a session
+func (i *injector) hook(f protocol.Frame, write func(protocol.Frame)) {
+	fault := i.next(f)
+	if fault != FaultPass {
+		i.logger.Info(fmt.Sprintf("[Fault] %v %v frame seq=%v", fault, protocol.FlagName(f.Flag), f.SeqId))
+	}
+
+	switch fault {
+	case FaultDrop:
+	case FaultDelay:
+		// Data of the frame may be reused by the stream once the write returns
+		data := make([]byte, len(f.Data))
+		copy(data, f.Data)
+		f.Data = data
+		time.AfterFunc(i.faults.DelayBy, func() {
+			write(f)
+		})
+	case FaultDuplicate:
+		write(f)
+		write(f)
+	default:
+		write(f)
+	}
+}
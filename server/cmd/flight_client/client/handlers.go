@@ -1,14 +1,15 @@
 package client
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"text/tabwriter"
 	"time"
 
-	"github.com/isaiahwong/cz4013/encoding"
+	"github.com/isaiahwong/cz4013/protocol"
 	"github.com/isaiahwong/cz4013/rpc"
 )
 
@@ -18,271 +19,135 @@ type Placeholder struct {
 
 // FindFlights is a rpc method that finds flights by source and destination
 func (c *Client) FindFlights(source string, destination string) ([]*rpc.Flight, error) {
-	method := "FindFlights"
-	// Open a new stream
-	stream, err := c.open()
-	if err != nil {
-		return nil, err
-	}
-
-	req := map[string]string{
-		"source":      source,
-		"destination": destination,
-	}
-	res, stream, err := c.send(stream, method, req, &c.opts.deadline)
-	if err != nil {
-		return nil, err
-	}
-	if res.Error != nil {
-		return nil, errors.New(res.Error.Body)
-	}
-
-	flights := []*rpc.Flight{}
-	if err = encoding.Unmarshal(res.Body, &flights); err != nil && err != io.EOF {
-		return nil, err
-	}
-	return flights, stream.Close()
+	return c.flights.FindFlights(context.Background(), &rpc.FindFlightsRequest{
+		Source:      source,
+		Destination: destination,
+	})
 }
 
 // FindFlight is a rpc method that finds a flight by id
 func (c *Client) FindFlight(id string) (*rpc.Flight, error) {
-	method := "FindFlight"
-	// Open a new stream
-	stream, err := c.open()
+	fid, err := parseID("id", id)
 	if err != nil {
 		return nil, err
 	}
-
-	req := map[string]string{
-		"id": id,
-	}
-
-	res, stream, err := c.send(stream, method, req, &c.opts.deadline)
-	if err != nil {
-		return nil, err
-	}
-	if res.Error != nil {
-		return nil, errors.New(res.Error.Body)
-	}
-
-	flight := new(rpc.Flight)
-	if err = encoding.Unmarshal(res.Body, flight); err != nil && err != io.EOF {
-		return nil, err
-	}
-	return flight, stream.Close()
+	return c.flights.FindFlight(context.Background(), &rpc.FindFlightRequest{ID: fid})
 }
 
 // ReserveFlight is a rpc method that reserves a flight by id and number of seats
 func (c *Client) ReserveFlight(id string, seats int) (*rpc.ReserveFlight, error) {
-	method := "ReserveFlight"
-	// Open a new stream
-	stream, err := c.open()
+	fid, err := parseID("id", id)
 	if err != nil {
 		return nil, err
 	}
-
-	req := map[string]string{
-		"id":    id,
-		"seats": fmt.Sprint(seats),
-	}
-
-	res, stream, err := c.send(stream, method, req, &c.opts.deadline)
-	if err != nil {
-		return nil, err
-	}
-	if res.Error != nil {
-		return nil, errors.New(res.Error.Body)
-	}
-
-	reservation := new(rpc.ReserveFlight)
-	if err = encoding.Unmarshal(res.Body, reservation); err != nil && err != io.EOF {
-		return nil, err
-	}
-	return reservation, stream.Close()
+	return c.flights.ReserveFlight(context.Background(), &rpc.ReserveFlightRequest{
+		ID:    fid,
+		Seats: int32(seats),
+	})
 }
 
 // CheckInFlight is a rpc method that checks in a flight by reservation id
 func (c *Client) CheckInFlight(id string) (*rpc.ReserveFlight, error) {
-	method := "CheckInFlight"
-	// Open a new stream
-	stream, err := c.open()
-	if err != nil {
-		return nil, err
-	}
-
-	req := map[string]string{
-		"id": id,
-	}
-
-	res, stream, err := c.send(stream, method, req, &c.opts.deadline)
-	if err != nil {
-		return nil, err
-	}
-	if res.Error != nil {
-		return nil, errors.New(res.Error.Body)
-	}
-
-	reservation := new(rpc.ReserveFlight)
-	if err = encoding.Unmarshal(res.Body, reservation); err != nil && err != io.EOF {
-		return nil, err
-	}
-	return reservation, stream.Close()
+	return c.flights.CheckInFlight(context.Background(), &rpc.CheckInFlightRequest{ID: id})
 }
 
 func (c *Client) GetMeals() ([]*rpc.Food, error) {
-	method := "GetMeals"
-	// Open a new stream
-	stream, err := c.open()
-	if err != nil {
-		return nil, err
-	}
-	req := map[string]string{}
-	res, stream, err := c.send(stream, method, req, &c.opts.deadline)
-	if err != nil {
-		return nil, err
-	}
-	if res.Error != nil {
-		return nil, errors.New(res.Error.Body)
-	}
-
-	meals := []*rpc.Food{}
-	if err = encoding.Unmarshal(res.Body, &meals); err != nil && err != io.EOF {
-		return nil, err
-	}
-	return meals, stream.Close()
+	return c.flights.GetMeals(context.Background(), &rpc.GetMealsRequest{})
 }
 
 func (c *Client) AddMeals(id string, mealId string) (*rpc.ReserveFlight, error) {
-	method := "AddMeals"
-	// Open a new stream
-	stream, err := c.open()
-	if err != nil {
-		return nil, err
-	}
-	req := map[string]string{
-		"id":      id,
-		"meal_id": mealId,
-	}
-	res, stream, err := c.send(stream, method, req, &c.opts.deadline)
+	mid, err := parseID("meal_id", mealId)
 	if err != nil {
 		return nil, err
 	}
-	if res.Error != nil {
-		return nil, errors.New(res.Error.Body)
-	}
-
-	reservation := new(rpc.ReserveFlight)
-	if err = encoding.Unmarshal(res.Body, reservation); err != nil && err != io.EOF {
-		return nil, err
-	}
-	return reservation, stream.Close()
+	return c.flights.AddMeals(context.Background(), &rpc.AddMealsRequest{
+		ID:     id,
+		MealID: mid,
+	})
 }
 
 // CancelFlight is a rpc method that cancels a flight by reservation id
 func (c *Client) CancelFlight(id string) (*rpc.ReserveFlight, error) {
-	method := "CancelFlight"
-	// Open a new stream
-	stream, err := c.open()
-	if err != nil {
-		return nil, err
-	}
-
-	req := map[string]string{
-		"id": id,
-	}
-
-	res, stream, err := c.send(stream, method, req, &c.opts.deadline)
+	reserveFlight, err := c.flights.CancelFlight(context.Background(), &rpc.CancelFlightRequest{ID: id})
 	if err != nil {
 		return nil, err
 	}
-	if res.Error != nil {
-		return nil, errors.New(res.Error.Body)
-	}
 
 	// remove reservation
 	delete(c.Reservations, id)
+	return reserveFlight, nil
+}
 
-	reserveFlight := new(rpc.ReserveFlight)
-	if err = encoding.Unmarshal(res.Body, reserveFlight); err != nil && err != io.EOF {
-		return nil, err
+// parseID parses the id of query param key
+func parseID(key string, id string) (int32, error) {
+	n, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v: %v", rpc.ErrInvalidParams, key, err)
 	}
-	return reserveFlight, stream.Close()
+	return int32(n), nil
 }
 
 // MonitorUpdates is a rpc method that monitors updates for a duration.
+// Updates are pushed by the server on server initiated streams.
 // The method is a blocking call
 func (c *Client) MonitorUpdates(flightId string, duration time.Duration, interruptCh chan Placeholder) error {
-	method := "MonitorUpdates"
-	// Open a new stream
-	stream, err := c.open()
-	defer stream.Close()
-
-	dataCh := make(chan []byte, 1)
-
-	if err != nil {
-		return err
+	method := rpc.MethodMonitorUpdates
+	req := &rpc.MonitorUpdatesRequest{Timestamp: time.Now().Add(duration).Unix() * 1000}
+	if flightId != "" {
+		fid, err := parseID("id", flightId)
+		if err != nil {
+			return err
+		}
+		req.ID = &fid
 	}
+	dataCh := make(chan []byte, 1)
 
-	// Inline blocking read function
-	read := func() {
-		for {
-			res := make([]byte, c.mtu)
-			n, err := stream.Read(res)
-
-			if err != nil && err != io.EOF {
-				if err == io.ErrClosedPipe {
-					return
-				}
-				c.logger.WithError(err).Error(method)
-				return
-			}
-
-			if err == io.EOF {
-				return
-			}
-
-			m := new(rpc.Message)
-			if err = encoding.Unmarshal(res[:n], m); err != nil && err != io.EOF {
-				c.logger.WithError(err).Error(method)
-				return
-			}
-			select {
-			case dataCh <- m.Body:
-			}
+	// Register handler before subscribing so no update is missed
+	c.Handle(method, func(m *rpc.Message) {
+		select {
+		case dataCh <- m.Body:
+		default:
+			c.logger.Warn("Dropped flight update, monitor is busy")
 		}
-	}
+	})
+	defer c.Handle(method, nil)
 
-	req := map[string]string{
-		"timestamp": fmt.Sprintf("%v", time.Now().Add(duration).Unix()*1000),
-		"id":        fmt.Sprintf("%v", flightId),
+	// Record the stream of the subscription to cancel it on interrupt
+	var stream *protocol.Stream
+	ctx := withStreamRecorder(context.Background(), &stream)
+	if err := c.flights.MonitorUpdates(ctx, req); err != nil {
+		return err
 	}
-	err = c.sendOnly(stream, method, req, &c.opts.deadline)
-
-	// Listen on goroutine
-	go read()
 
-	// Listens for either interrupt or flight data from data channel
-	for !stream.IsClosed() {
+	deadline := time.After(duration)
+	// Listens for either interrupt, deadline or flight data from data channel
+	for {
 		var body []byte
 		select {
 		case <-interruptCh:
+			// Release the subscription on the server
+			if err := stream.Cancel(); err != nil {
+				c.logger.WithError(err).Warn("Unable to cancel monitor")
+			}
+			return nil
+		case <-deadline:
+			c.logger.Info("Monitor ended")
 			return nil
 		case body = <-dataCh:
 		}
 
-		if body == nil || len(body) <= 0 {
+		if len(body) <= 0 {
 			continue
 		}
 
-		flight := new(rpc.Flight)
-		if err = encoding.Unmarshal(body, flight); err != nil && err != io.EOF {
+		flight, err := c.flights.DecodeMonitorUpdates(body)
+		if err != nil && err != io.EOF {
 			return err
 		}
 		fmt.Println("New Updated flight")
 		w := tabwriter.NewWriter(os.Stdout, 0, 8, 4, ' ', tabwriter.TabIndent)
 		fmt.Println("Flight")
 		fmt.Fprintln(w, flight.String())
+		w.Flush()
 	}
-	c.logger.Info("Monitor ended")
-	return nil
 }
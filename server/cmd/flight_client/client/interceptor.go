@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/isaiahwong/cz4013/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+// InvokeFunc sends a request message and returns its reply
+type InvokeFunc func(ctx context.Context, m *rpc.Message) (*rpc.Message, error)
+
+// Interceptor intercepts every call of the client. The interceptor continues
+// the call by calling invoke, which attempts the call with the retry policy.
+type Interceptor func(ctx context.Context, m *rpc.Message, invoke InvokeFunc) (*rpc.Message, error)
+
+// intercept calls invoke through the interceptors of the client
+func (c *Client) intercept(ctx context.Context, m *rpc.Message, invoke InvokeFunc) (*rpc.Message, error) {
+	for i := len(c.opts.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := c.opts.interceptors[i], invoke
+		invoke = func(ctx context.Context, m *rpc.Message) (*rpc.Message, error) {
+			return interceptor(ctx, m, next)
+		}
+	}
+	return invoke(ctx, m)
+}
+
+// Logging returns an Interceptor logging calls, their duration and errors
+func Logging(logger *logrus.Logger) Interceptor {
+	return func(ctx context.Context, m *rpc.Message, invoke InvokeFunc) (*rpc.Message, error) {
+		start := time.Now()
+		reply, err := invoke(ctx, m)
+		fields := logrus.Fields{
+			"method":  m.RPC,
+			"query":   m.Query,
+			"elapsed": time.Since(start),
+		}
+		switch {
+		case err != nil:
+			logger.WithFields(fields).WithError(err).Error(fmt.Sprintf("[%v] failed", m.RPC))
+		case reply.Error != nil:
+			logger.WithFields(fields).Warn(fmt.Sprintf("[%v] replied with error: %v", m.RPC, reply.Err()))
+		default:
+			logger.WithFields(fields).Debug(fmt.Sprintf("[%v] replied", m.RPC))
+		}
+		return reply, err
+	}
+}
+
+// Timeout returns an Interceptor bounding every call by timeout including its retries
+func Timeout(timeout time.Duration) Interceptor {
+	return func(ctx context.Context, m *rpc.Message, invoke InvokeFunc) (*rpc.Message, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return invoke(ctx, m)
+	}
+}
@@ -10,7 +10,12 @@ type options struct {
 	logger   *logrus.Logger
 	deadline time.Duration
 	addr     string
-	retries  int
+	retry    RetryPolicy
+	faults   Faults
+	compact  bool
+
+	// Interceptors of calls in order from the outermost
+	interceptors []Interceptor
 }
 
 // Option sets options for Server.
@@ -23,6 +28,8 @@ func WithAddr(a string) Option {
 	}
 }
 
+// WithDeadline returns an Option which sets the response timeout used
+// until a round trip to the server has been estimated.
 func WithDeadline(deadline time.Duration) Option {
 	return func(o *options) {
 		o.deadline = deadline
@@ -36,8 +43,49 @@ func WithLogger(l *logrus.Logger) Option {
 	}
 }
 
+// WithRetries returns an Option which attempts calls of every method up to i times.
+// Equivalent to WithRetryPolicy(MaxAttempts(i)).
 func WithRetries(i int) Option {
+	return WithRetryPolicy(MaxAttempts(i))
+}
+
+// WithRetryPolicy returns an Option which sets the policy attempting calls
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(o *options) {
+		if p != nil {
+			o.retry = p
+		}
+	}
+}
+
+// WithInterceptors returns an Option which appends interceptors of calls.
+// The first interceptor is the outermost.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// WithCompact returns an Option which negotiates the compact encoding
+// of integers and lengths on streams opened to the server
+func WithCompact(compact bool) Option {
+	return func(o *options) {
+		o.compact = compact
+	}
+}
+
+// WithFaults returns an Option which injects faults into outgoing client frames
+// to simulate lost, delayed and duplicated requests
+func WithFaults(f Faults) Option {
 	return func(o *options) {
-		o.retries = i
+		for _, rate := range []*int{&f.Drop, &f.Delay, &f.Duplicate} {
+			if *rate < 0 || *rate > 100 {
+				*rate = 0
+			}
+		}
+		if f.DelayBy <= 0 {
+			f.DelayBy = o.faults.DelayBy
+		}
+		o.faults = f
 	}
 }
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"io"
+	"time"
+
+	"github.com/isaiahwong/cz4013/encoding"
+	"github.com/isaiahwong/cz4013/protocol"
+	"github.com/isaiahwong/cz4013/rpc"
+)
+
+// PushHandler handles a message pushed by the server on a server initiated stream
+type PushHandler func(m *rpc.Message)
+
+// Handle registers a handler for messages pushed by the server for method.
+// A nil handler removes the registered handler.
+func (c *Client) Handle(method string, h PushHandler) {
+	c.handlersMux.Lock()
+	defer c.handlersMux.Unlock()
+	if h == nil {
+		delete(c.handlers, method)
+		return
+	}
+	c.handlers[method] = h
+}
+
+// handler returns the registered handler for method
+func (c *Client) handler(method string) (PushHandler, bool) {
+	c.handlersMux.Lock()
+	defer c.handlersMux.Unlock()
+	h, ok := c.handlers[method]
+	return h, ok
+}
+
+// serve accepts server initiated streams and dispatches them to registered handlers
+func (c *Client) serve() {
+	for {
+		stream, err := c.session.Accept()
+		if err != nil {
+			if err != io.ErrClosedPipe {
+				c.logger.WithError(err).Error("Unable to accept stream")
+			}
+			return
+		}
+		go c.dispatch(stream)
+	}
+}
+
+// dispatch reads a pushed message from stream and calls its handler
+func (c *Client) dispatch(stream *protocol.Stream) {
+	defer stream.Close()
+
+	res := make([]byte, c.mtu)
+	stream.SetReadDeadline(time.Now().Add(c.opts.deadline))
+	n, err := stream.Read(res)
+	if err != nil && err != io.EOF {
+		c.logger.WithError(err).Error("Unable to read pushed message")
+		return
+	}
+
+	m := new(rpc.Message)
+	if err = encoding.Unmarshal(res[:n], m, encoding.WithCompact(stream.Compact())); err != nil && err != io.EOF {
+		c.logger.WithError(err).Error("Unable to unmarshal pushed message")
+		return
+	}
+
+	h, ok := c.handler(m.RPC)
+	if !ok {
+		c.logger.Warn("No handler registered for pushed message ", m.RPC)
+		return
+	}
+	h(m)
+}
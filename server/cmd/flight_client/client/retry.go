@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/isaiahwong/cz4013/protocol"
+	"github.com/isaiahwong/cz4013/rpc"
+)
+
+// RetryPolicy decides how calls of methods are attempted
+type RetryPolicy interface {
+	// Attempts returns the maximum number of attempts of a call of method
+	Attempts(method string) int
+
+	// Retryable reports whether a call failing with err is attempted again
+	Retryable(err error) bool
+
+	// Hedge returns the delay after which another attempt of a call of method is
+	// sent while the previous attempts are outstanding. Zero disables hedging.
+	Hedge(method string) time.Duration
+}
+
+// IsRetryable reports whether err is a transport failure that may succeed once
+// attempted again such as a timeout or a malformed reply. Canceled calls and a
+// closed session are not retryable. Errors replied by the server are returned
+// by the stubs once the call succeeds hence never retried.
+func IsRetryable(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, io.ErrClosedPipe), errors.Is(err, protocol.ErrCanceled):
+		return false
+	}
+	return err != nil
+}
+
+// maxAttempts attempts every method up to a number of times
+type maxAttempts int
+
+// MaxAttempts returns a RetryPolicy attempting calls of every method up to n times
+// while they fail with retryable errors. Calls are attempted at least once.
+func MaxAttempts(n int) RetryPolicy {
+	if n < 1 {
+		n = 1
+	}
+	return maxAttempts(n)
+}
+
+func (p maxAttempts) Attempts(method string) int {
+	return int(p)
+}
+
+func (p maxAttempts) Retryable(err error) bool {
+	return IsRetryable(err)
+}
+
+func (p maxAttempts) Hedge(method string) time.Duration {
+	return 0
+}
+
+// methodAttempts overrides the attempts of methods of a policy
+type methodAttempts struct {
+	RetryPolicy
+	attempts map[string]int
+}
+
+// MethodAttempts returns a RetryPolicy overriding the attempts of p per method
+func MethodAttempts(p RetryPolicy, attempts map[string]int) RetryPolicy {
+	return &methodAttempts{RetryPolicy: p, attempts: attempts}
+}
+
+func (p *methodAttempts) Attempts(method string) int {
+	if n, ok := p.attempts[method]; ok {
+		if n < 1 {
+			return 1
+		}
+		return n
+	}
+	return p.RetryPolicy.Attempts(method)
+}
+
+// retryOn classifies retryable errors of a policy
+type retryOn struct {
+	RetryPolicy
+	retryable func(err error) bool
+}
+
+// RetryOn returns a RetryPolicy of p attempting calls again only if retryable reports their error
+func RetryOn(p RetryPolicy, retryable func(err error) bool) RetryPolicy {
+	return &retryOn{RetryPolicy: p, retryable: retryable}
+}
+
+func (p *retryOn) Retryable(err error) bool {
+	return p.retryable(err)
+}
+
+// hedged hedges calls of methods of a policy that are safe to repeat
+type hedged struct {
+	RetryPolicy
+	delay time.Duration
+}
+
+// Hedged returns a RetryPolicy of p sending another attempt of read only and
+// idempotent methods every delay while the previous attempts are outstanding.
+// The first reply is returned. Non idempotent methods are never hedged.
+func Hedged(p RetryPolicy, delay time.Duration) RetryPolicy {
+	return &hedged{RetryPolicy: p, delay: delay}
+}
+
+func (p *hedged) Hedge(method string) time.Duration {
+	m, ok := rpc.FlightSystemService.Method(method)
+	if !ok || m.Idempotency == rpc.NonIdempotent {
+		return p.RetryPolicy.Hedge(method)
+	}
+	return p.delay
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/isaiahwong/cz4013/protocol"
+	"github.com/isaiahwong/cz4013/rpc"
+)
+
+// TestRetryPolicies verifies the attempts, classification and hedging of policies
+func TestRetryPolicies(t *testing.T) {
+	p := Hedged(MethodAttempts(MaxAttempts(3), map[string]int{rpc.MethodReserveFlight: 1}), 50*time.Millisecond)
+	if n := p.Attempts(rpc.MethodFindFlight); n != 3 {
+		t.Fatalf("got %v attempts, want 3", n)
+	}
+	if n := p.Attempts(rpc.MethodReserveFlight); n != 1 {
+		t.Fatalf("got %v attempts, want 1", n)
+	}
+	if d := p.Hedge(rpc.MethodFindFlight); d != 50*time.Millisecond {
+		t.Fatalf("got hedge %v of a read only method", d)
+	}
+	if d := p.Hedge(rpc.MethodReserveFlight); d != 0 {
+		t.Fatalf("got hedge %v of a non idempotent method", d)
+	}
+
+	for err, want := range map[error]bool{
+		protocol.ErrTimeout:                        true,
+		context.Canceled:                           false,
+		fmt.Errorf("%w", context.DeadlineExceeded): false,
+		protocol.ErrCanceled:                       false,
+	} {
+		if got := p.Retryable(err); got != want {
+			t.Fatalf("%v: got retryable %v, want %v", err, got, want)
+		}
+	}
+
+	p = RetryOn(p, func(err error) bool { return errors.Is(err, protocol.ErrTimeout) })
+	if p.Retryable(errors.New("Malformed reply")) || !p.Retryable(protocol.ErrTimeout) {
+		t.Fatal("RetryOn did not classify errors")
+	}
+	if n := MaxAttempts(0).Attempts(rpc.MethodGetMeals); n != 1 {
+		t.Fatalf("got %v attempts, want 1", n)
+	}
+}
@@ -14,7 +14,45 @@ import (
 var c *client.Client
 var a *app.App
 
-func prompt() *client.Client {
+// promptRate prompts for a percentage
+func promptRate(label string) int {
+	p := promptui.Prompt{
+		Label:    label,
+		Default:  "0",
+		Validate: common.ValidateRange(0, 100),
+	}
+	input, err := p.Run()
+	if common.HandleInterrupt(err) != nil {
+		panic(err)
+	}
+	rate, _ := strconv.ParseInt(input, 10, 32)
+	return int(rate)
+}
+
+// promptFaults prompts for faults injected into outgoing client frames
+func promptFaults() client.Faults {
+	faults := client.Faults{
+		Drop:      promptRate("Enter client drop rate 0 - 100"),
+		Delay:     promptRate("Enter client delay rate 0 - 100"),
+		Duplicate: promptRate("Enter client duplicate rate 0 - 100"),
+	}
+
+	scriptP := promptui.Prompt{
+		Label: "Enter fault script e.g. drop,pass,DNE:drop,RST:dup (optional)",
+		Validate: func(input string) error {
+			_, err := client.ParseFaultScript(input)
+			return err
+		},
+	}
+	input, err := scriptP.Run()
+	if common.HandleInterrupt(err) != nil {
+		panic(err)
+	}
+	faults.Script, _ = client.ParseFaultScript(input)
+	return faults
+}
+
+func prompt(faults client.Faults, compact bool) *client.Client {
 	loadDefault := "Load default config"
 	customConfig := "Custom config"
 	sp := promptui.Select{
@@ -31,12 +69,7 @@ func prompt() *client.Client {
 	}
 
 	if input == loadDefault {
-		return client.New(
-			client.WithAddr("localhost:8080"),
-			client.WithDeadline(2*time.Second),
-			client.WithRetries(5),
-			client.WithLogger(logrus.New()),
-		)
+		return newClient("localhost:8080", 2*time.Second, 5, faults, compact)
 	}
 
 	remoteAddrP := promptui.Prompt{
@@ -67,16 +100,30 @@ func prompt() *client.Client {
 	}
 	retryInt, _ := strconv.ParseInt(retry, 10, 32)
 
+	return newClient(remoteAddr, time.Duration(timeoutInt)*time.Second, int(retryInt), faults, compact)
+}
+
+// newClient creates a client of a config. faults are prompted if none are given.
+func newClient(addr string, deadline time.Duration, retries int, faults client.Faults, compact bool) *client.Client {
+	if !faults.Enabled() {
+		faults = promptFaults()
+	}
+
 	return client.New(
-		client.WithAddr(remoteAddr),
-		client.WithDeadline(time.Duration(timeoutInt)*time.Second),
-		client.WithRetries(int(retryInt)),
+		client.WithAddr(addr),
+		client.WithDeadline(deadline),
+		client.WithRetries(retries),
 		client.WithLogger(logrus.New()),
+		client.WithFaults(faults),
+		client.WithCompact(compact),
 	)
 }
 
-func Start() {
-	c = prompt()
+// Start starts the client. faults are injected into outgoing frames
+// and prompted if none are given. compact negotiates
+// the compact encoding of messages with the server.
+func Start(faults client.Faults, compact bool) {
+	c = prompt(faults, compact)
 	a = app.New(c)
 	if err := a.Start(); err != nil {
 		panic(err)
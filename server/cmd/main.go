@@ -2,8 +2,10 @@ package main
 
 import (
 	"flag"
+	"time"
 
 	"github.com/isaiahwong/cz4013/cmd/flight_client"
+	"github.com/isaiahwong/cz4013/cmd/flight_client/client"
 	"github.com/isaiahwong/cz4013/cmd/server"
 	"github.com/isaiahwong/cz4013/common"
 	"github.com/manifoldco/promptui"
@@ -30,18 +32,35 @@ func prompt() {
 	case s:
 		server.Start()
 	default:
-		flight_client.Start()
+		flight_client.Start(client.Faults{}, false)
 	}
 }
 
-// runClient starts the client
-func runClient() {
-	flight_client.Start()
+// runClient starts the client with faults injected into outgoing frames
+func runClient(drop int, delay int, duplicate int, delayBy int, script string, compact bool) {
+	faults, err := client.ParseFaultScript(script)
+	if err != nil {
+		panic(err)
+	}
+	flight_client.Start(client.Faults{
+		Drop:      drop,
+		Delay:     delay,
+		Duplicate: duplicate,
+		DelayBy:   time.Duration(delayBy) * time.Millisecond,
+		Script:    faults,
+	}, compact)
 }
 
 // runServer starts the server with the specified parameters
-func runServer(deadline int, semantics int, port string, lossRate int) {
-	s := server.New(semantics, deadline, lossRate, port)
+func runServer(deadline int, semantics int, port string, lossRate int, history string, journal string, methodSemantics string) {
+	if _, err := server.ParseSemantic(semantics); err != nil {
+		panic(err)
+	}
+	overrides, err := server.ParseMethodSemantics(methodSemantics)
+	if err != nil {
+		panic(err)
+	}
+	s := server.New(semantics, deadline, lossRate, port, history, journal, overrides)
 	s.Serve()
 }
 
@@ -53,15 +72,34 @@ func main() {
 	var deadline int
 	var lossRate int
 	var port string
+	var history string
+	var journal string
+	var methodSemantics string
 	var client bool
+	var clientDrop int
+	var clientDelay int
+	var clientDuplicate int
+	var clientDelayBy int
+	var clientFaults string
+	var clientCompact bool
 
 	// Setup command line arguments
 	flag.BoolVar(&interactive, "i", false, "Enables interactive mode. Other options will be ignored when interactive mode is enabled.")
 	flag.BoolVar(&client, "c", false, "Run client")
 	flag.IntVar(&deadline, "deadline", 5, "Deadline of a request response in seconds")
-	flag.IntVar(&semantics, "semantic", 0, "[Server] Semantics of server. 0: AtLeastOnce, 1: AtMostOnce")
+	flag.IntVar(&semantics, "semantic", 0, "[Server] Semantics of non idempotent methods. 0: AtMostOnce, 2: ExactlyOnce. AtLeastOnce is only supported per method with -method-semantic")
+	flag.StringVar(&methodSemantics, "method-semantic", "", "[Server] Semantics of methods overriding their defaults e.g. ReserveFlight=2,FindFlights=1")
 	flag.StringVar(&port, "port", "8080", "[Server] Server's port")
 	flag.IntVar(&lossRate, "loss", 0, "[Server] Server's loss rate")
+	flag.StringVar(&history, "history", server.DefaultHistory, "[Server] Reply log for at-most-once semantics. Empty keeps replies in memory only")
+	flag.StringVar(&journal, "journal", server.DefaultJournal, "[Server] Journal of data mutations and replies for exactly-once semantics")
+
+	flag.IntVar(&clientDrop, "client-drop", 0, "[Client] Percentage of outgoing frames dropped")
+	flag.IntVar(&clientDelay, "client-delay", 0, "[Client] Percentage of outgoing frames delayed")
+	flag.IntVar(&clientDuplicate, "client-dup", 0, "[Client] Percentage of outgoing frames duplicated")
+	flag.IntVar(&clientDelayBy, "client-delay-ms", 1000, "[Client] Delay of delayed frames in milliseconds")
+	flag.StringVar(&clientFaults, "client-faults", "", "[Client] Script of faults applied to outgoing frames in order e.g. drop,pass,DNE:drop,ACK:dup")
+	flag.BoolVar(&clientCompact, "client-compact", false, "[Client] Negotiate the compact varint encoding of messages")
 
 	flag.Usage = func() {
 		flag.PrintDefaults()
@@ -76,10 +114,10 @@ func main() {
 
 	// Starts application in client mode if specified from prompt
 	if client {
-		runClient()
+		runClient(clientDrop, clientDelay, clientDuplicate, clientDelayBy, clientFaults, clientCompact)
 		return
 	}
 
 	// Default runs to server
-	runServer(deadline, semantics, port, lossRate)
+	runServer(deadline, semantics, port, lossRate, history, journal, methodSemantics)
 }
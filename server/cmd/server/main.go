@@ -5,6 +5,7 @@ import (
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/isaiahwong/cz4013/common"
@@ -15,6 +16,12 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// DefaultHistory is the default reply log persisted next to flights.csv
+const DefaultHistory = "history.log"
+
+// DefaultJournal is the default journal of the data store for exactly-once semantics
+const DefaultJournal = "journal.log"
+
 var db *store.DB
 var flightRepo *rpc.FlightRepo
 var reservationRepo *rpc.ReservationRepo
@@ -49,17 +56,64 @@ func init() {
 	logger = logrus.New()
 }
 
-// New creates a new server with the specified parameters
-func New(semantic int, deadline int, lossRate int, port string) *protocol.Server {
-	return protocol.New(
+// ParseSemantic parses the semantic of non idempotent methods.
+// At-least-once is rejected as it only applies to methods overriding their semantic.
+func ParseSemantic(i int) (protocol.Semantics, error) {
+	semantic := protocol.IntToSemantics(i)
+	switch semantic {
+	case protocol.Unknown:
+		return semantic, fmt.Errorf("unknown semantic: %v", i)
+	case protocol.AtLeastOnce:
+		return semantic, fmt.Errorf("semantic %v is only supported per method, use -method-semantic e.g. ReserveFlight=%v", semantic, i)
+	}
+	return semantic, nil
+}
+
+// ParseMethodSemantics parses overrides of method semantics in the form Method=semantic,...
+func ParseMethodSemantics(s string) (map[string]protocol.Semantics, error) {
+	overrides := make(map[string]protocol.Semantics)
+	if strings.TrimSpace(s) == "" {
+		return overrides, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid method semantic %q", pair)
+		}
+		i, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid semantic of %v: %v", kv[0], err)
+		}
+		semantic := protocol.IntToSemantics(i)
+		if semantic == protocol.Unknown {
+			return nil, fmt.Errorf("unknown semantic of %v: %v", kv[0], i)
+		}
+		overrides[kv[0]] = semantic
+	}
+	return overrides, nil
+}
+
+// New creates a new server with the specified parameters.
+// semantic applies to non idempotent methods and must be at-most-once or exactly-once,
+// overrides apply to the named methods.
+// Replies for at-most-once semantics are persisted to the history log if specified.
+// Mutations and replies for exactly-once semantics are committed to the journal.
+func New(semantic int, deadline int, lossRate int, port string, history string, journal string, overrides map[string]protocol.Semantics) *protocol.Server {
+	opts := []protocol.Option{
 		protocol.WithSemantic(protocol.IntToSemantics(semantic)),
-		protocol.WithDeadline(time.Duration(deadline)*time.Second),
+		protocol.WithDeadline(time.Duration(deadline) * time.Second),
 		protocol.WithFlightRepo(flightRepo),
 		protocol.WithReservationRepo(reservationRepo),
 		protocol.WithLossRate(lossRate),
 		protocol.WithLogger(logger),
 		protocol.WithPort(fmt.Sprintf(":%v", port)),
-	)
+		protocol.WithReplyLog(history),
+		protocol.WithJournal(db, journal),
+	}
+	for method, semantic := range overrides {
+		opts = append(opts, protocol.WithMethodSemantic(method, semantic))
+	}
+	return protocol.New(opts...)
 }
 
 // handleInterrupt handles the interrupt keyboard interrupts
@@ -75,10 +129,12 @@ func handleInterrupt(err error) error {
 func prompt() *protocol.Server {
 	loadDefault := "Load default config"
 	customConfig := "Custom config"
+	// Non idempotent methods only run at-least-once if overridden per method
 	semantics := []protocol.Semantics{
 		protocol.AtMostOnce,
-		protocol.AtLeastOnce,
+		protocol.ExactlyOnce,
 	}
+	flags := []int{0, 2}
 
 	sp := promptui.Select{
 		Label: "Select option",
@@ -93,12 +149,12 @@ func prompt() *protocol.Server {
 	}
 
 	if input == loadDefault {
-		return New(0, 5, 0, "8080")
+		return New(0, 5, 0, "8080", DefaultHistory, DefaultJournal, nil)
 	}
 
 	// Custom config
 	semP := promptui.Select{
-		Label: "Select semantics",
+		Label: "Select semantics of non idempotent methods",
 		Items: semantics,
 	}
 
@@ -118,7 +174,7 @@ func prompt() *protocol.Server {
 	}
 	lossRateInt, _ := strconv.ParseInt(lossRateInput, 10, 32)
 
-	return New(semIdx, 5, int(lossRateInt), "8080")
+	return New(flags[semIdx], 5, int(lossRateInt), "8080", DefaultHistory, DefaultJournal, nil)
 }
 
 // Start starts the server
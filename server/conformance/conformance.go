@@ -0,0 +1,358 @@
+// Package conformance holds golden encodings of every kind supported by the
+// encoding package and of every RPC message. Clients in other languages verify
+// their codecs against testdata/golden.json, regenerated with go generate.
+package conformance
+
+//go:generate go run ../cmd/czgolden -output testdata/golden.json
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isaiahwong/cz4013/encoding"
+	"github.com/isaiahwong/cz4013/rpc"
+)
+
+// Mode is a wire format and whether it is compact
+type Mode struct {
+	Format  encoding.Format
+	Compact bool
+}
+
+func (m Mode) String() string {
+	if m.Compact {
+		return m.Format.String() + "/compact"
+	}
+	return m.Format.String()
+}
+
+// Options returns the encoding options of the mode
+func (m Mode) Options() []encoding.Option {
+	return []encoding.Option{encoding.WithFormat(m.Format), encoding.WithCompact(m.Compact)}
+}
+
+// Modes are the modes every case is encoded in
+var Modes = []Mode{
+	{encoding.Positional, false},
+	{encoding.Positional, true},
+	{encoding.TLV, false},
+	{encoding.TLV, true},
+	{encoding.SelfDescribing, false},
+}
+
+// Case is a named value encoded in every mode.
+// Maps hold a single entry as entries are encoded in random order.
+type Case struct {
+	Name  string
+	Value interface{}
+}
+
+// Tagged covers the options of cz tags. Fields are encoded in order of their number.
+type Tagged struct {
+	ID    int32  `cz:"1,varint"`
+	Count uint64 `cz:"2,varint"`
+	Fixed int64  `cz:"3,fixed"`
+	Note  string `cz:"5,omitempty"`
+	Later bool   `cz:"4"`
+	Skip  string `cz:"-"`
+}
+
+// Any holds a value of an interface, encoded with the registered name of its type
+type Any struct {
+	Value interface{}
+}
+
+// Times covers the built-in codecs
+type Times struct {
+	Time     time.Time
+	Duration time.Duration
+	UUID     uuid.UUID
+}
+
+// Cases returns the cases of the golden encodings
+func Cases() []Case {
+	i32 := int32(5)
+	return []Case{
+		{"bool", true},
+		{"int", int(-42)},
+		{"int8", int8(-8)},
+		{"int16", int16(-1600)},
+		{"int32", int32(-320000)},
+		{"int64", int64(-6400000000000)},
+		{"uint", uint(42)},
+		{"uint8", uint8(200)},
+		{"uint16", uint16(60000)},
+		{"uint32", uint32(4000000000)},
+		{"uint64", uint64(1<<63 + 5)},
+		{"uintptr", uintptr(7)},
+		{"float32", float32(499.5)},
+		{"float64", float64(-3.141592653589793)},
+		{"complex64", complex64(1.5 - 2i)},
+		{"complex128", complex128(-0.25 + 8i)},
+		{"string", "San Antonio"},
+		{"string/empty", ""},
+		{"string/utf8", "Zürich ✈"},
+		{"bytes", []byte{0x00, 0x01, 0x7f, 0xff}},
+		{"byte_array", [4]byte{0xde, 0xad, 0xbe, 0xef}},
+		{"array", [3]int16{1, -2, 3}},
+		{"slice", []int32{1, -1, 7}},
+		{"slice/strings", []string{"Steak", "Wine"}},
+		{"map", map[string]int32{"seats": 2}},
+		{"pointer", &i32},
+		{"pointer/nil", (*int32)(nil)},
+		{"interface", Any{Value: int32(4)}},
+		{"interface/nil", Any{}},
+		{"tagged", Tagged{ID: -3, Count: 300, Fixed: -1, Later: true}},
+		{"tagged/omitempty", Tagged{ID: 1, Note: "window seat"}},
+		{"time", Times{
+			Time:     time.Unix(1681260739, 123456789).UTC(),
+			Duration: 90 * time.Second,
+			UUID:     uuid.MustParse("513a3af1-7f50-46ae-8030-4982f30b920a"),
+		}},
+
+		// RPC messages
+		{"rpc/flight", flight()},
+		{"rpc/flights", []*rpc.Flight{flight(), nil}},
+		{"rpc/food", &rpc.Food{ID: 2, Name: "Wine"}},
+		{"rpc/meals", []*rpc.Food{{ID: 0, Name: "Steak"}, {ID: 3, Name: "Coke"}}},
+		{"rpc/reserve_flight", &rpc.ReserveFlight{
+			ID:           "513a3af1-7f50-46ae-8030-4982f30b920a",
+			Flight:       flight(),
+			SeatReserved: 2,
+			CheckIn:      true,
+			Meals:        []*rpc.Food{{ID: 0, Name: "Steak"}},
+		}},
+		{"rpc/request", &rpc.Message{
+			RPC:   rpc.MethodFindFlight,
+			Query: map[string]string{"id": "6734"},
+			Body:  []byte{},
+		}},
+		{"rpc/reply", &rpc.Message{
+			RPC:  rpc.MethodReserveFlight,
+			Body: []byte{0x00, 0x2a},
+		}},
+		{"rpc/error", &rpc.Message{
+			RPC:   rpc.MethodFindFlight,
+			Body:  []byte{},
+			Error: &rpc.Error{Error: "Flight not found", Body: "No flights found with 1"},
+		}},
+	}
+}
+
+func flight() *rpc.Flight {
+	return &rpc.Flight{
+		ID:              6734,
+		Source:          "Phoenix",
+		Destination:     "San Antonio",
+		Airfare:         499,
+		SeatAvailablity: 66,
+		Timestamp:       1681260739,
+	}
+}
+
+// Golden is the file of golden encodings
+type Golden struct {
+	// Fields of the struct types of the vectors in order of their number
+	Structs map[string][]Field `json:"structs"`
+	Vectors []Vector           `json:"vectors"`
+}
+
+// Field is a field of a struct type
+type Field struct {
+	Name      string `json:"name"`
+	Number    int    `json:"number"`
+	Type      string `json:"type"`
+	OmitEmpty bool   `json:"omitempty,omitempty"`
+	Varint    bool   `json:"varint,omitempty"`
+	Fixed     bool   `json:"fixed,omitempty"`
+}
+
+// Vector is the golden encoding of a case in a mode
+type Vector struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Format  string `json:"format"`
+	Compact bool   `json:"compact"`
+	// The value in JSON. Bytes are hex, complex numbers [real, imag], times
+	// RFC 3339, durations nanoseconds and interfaces {"type", "value"}
+	Value interface{} `json:"value"`
+	Hex   string      `json:"hex"`
+}
+
+// Generate encodes the cases in every mode
+func Generate() (*Golden, error) {
+	g := &Golden{Structs: make(map[string][]Field)}
+	for _, c := range Cases() {
+		t := reflect.TypeOf(c.Value)
+		if err := g.describe(t); err != nil {
+			return nil, err
+		}
+		for _, m := range Modes {
+			b, err := encoding.Marshal(c.Value, m.Options()...)
+			if err != nil {
+				return nil, fmt.Errorf("%v %v: %v", c.Name, m, err)
+			}
+			g.Vectors = append(g.Vectors, Vector{
+				Name:    c.Name,
+				Type:    t.String(),
+				Format:  m.Format.String(),
+				Compact: m.Compact,
+				Value:   jsonValue(reflect.ValueOf(c.Value)),
+				Hex:     hex.EncodeToString(b),
+			})
+		}
+	}
+	return g, nil
+}
+
+// builtins are encoded by the built-in codecs of the encoding package
+var builtins = map[reflect.Type]bool{
+	reflect.TypeOf(time.Time{}):                true,
+	reflect.TypeOf(time.Duration(0)):           true,
+	reflect.TypeOf(uuid.UUID{}):                true,
+	reflect.TypeOf((*interface{})(nil)).Elem(): true,
+}
+
+// describe adds the fields of the struct types reachable from t
+func (g *Golden) describe(t reflect.Type) error {
+	if builtins[t] {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return g.describe(t.Elem())
+	case reflect.Map:
+		if err := g.describe(t.Key()); err != nil {
+			return err
+		}
+		return g.describe(t.Elem())
+	case reflect.Struct:
+	default:
+		return nil
+	}
+	if _, ok := g.Structs[t.String()]; ok {
+		return nil
+	}
+
+	tags := make([]encoding.Tag, t.NumField())
+	for i := range tags {
+		tag, err := encoding.ParseTag(t.Field(i).Tag.Get("cz"))
+		if err != nil {
+			return fmt.Errorf("%v.%v: %v", t, t.Field(i).Name, err)
+		}
+		tags[i] = tag
+	}
+	order, err := encoding.NumberFields(tags)
+	if err != nil {
+		return fmt.Errorf("%v: %v", t, err)
+	}
+
+	fields := []Field{}
+	for _, i := range order {
+		f := t.Field(i)
+		fields = append(fields, Field{
+			Name:      f.Name,
+			Number:    tags[i].Number,
+			Type:      f.Type.String(),
+			OmitEmpty: tags[i].OmitEmpty,
+			Varint:    tags[i].Varint,
+			Fixed:     tags[i].Fixed,
+		})
+	}
+	g.Structs[t.String()] = fields
+	for _, f := range fields {
+		field, _ := t.FieldByName(f.Name)
+		if err = g.describe(field.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonValue returns the value of rv in JSON
+func jsonValue(rv reflect.Value) interface{} {
+	switch v := rv.Interface().(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return int64(v)
+	case uuid.UUID:
+		return v.String()
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return nil
+		}
+		return jsonValue(rv.Elem())
+	case reflect.Interface:
+		if rv.IsNil() {
+			return nil
+		}
+		return map[string]interface{}{
+			"type":  rv.Elem().Type().String(),
+			"value": jsonValue(rv.Elem()),
+		}
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint()
+	case reflect.Float32, reflect.Float64:
+		return rv.Float()
+	case reflect.Complex64, reflect.Complex128:
+		return []float64{real(rv.Complex()), imag(rv.Complex())}
+	case reflect.String:
+		return rv.String()
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return hex.EncodeToString(b)
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			list[i] = jsonValue(rv.Index(i))
+		}
+		return list
+	case reflect.Map:
+		if rv.IsNil() {
+			return nil
+		}
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = jsonValue(iter.Value())
+		}
+		return m
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		for i := 0; i < rv.NumField(); i++ {
+			f := rv.Type().Field(i)
+			if f.PkgPath != "" || f.Tag.Get("cz") == "-" {
+				continue
+			}
+			m[f.Name] = jsonValue(rv.Field(i))
+		}
+		return m
+	}
+	return nil
+}
+
+// Lookup returns the case of name
+func Lookup(name string) (Case, bool) {
+	for _, c := range Cases() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return Case{}, false
+}
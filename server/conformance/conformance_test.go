@@ -0,0 +1,105 @@
+package conformance
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/isaiahwong/cz4013/encoding"
+)
+
+// readGolden reads the golden encodings of testdata
+func readGolden(t *testing.T) *Golden {
+	b, err := os.ReadFile("testdata/golden.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := new(Golden)
+	if err = json.Unmarshal(b, g); err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// TestGolden verifies the cases encode to their golden encodings in every mode.
+// Run go generate ./conformance after an intended change of the wire format.
+func TestGolden(t *testing.T) {
+	golden := readGolden(t)
+	g, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Vectors) != len(golden.Vectors) {
+		t.Fatalf("%v vectors, golden has %v", len(g.Vectors), len(golden.Vectors))
+	}
+	for i, v := range g.Vectors {
+		want := golden.Vectors[i]
+		if v.Name != want.Name || v.Format != want.Format || v.Compact != want.Compact {
+			t.Fatalf("vector %v is %v %v, golden is %v %v", i, v.Name, v.Format, want.Name, want.Format)
+		}
+		if v.Hex != want.Hex {
+			t.Errorf("%v %v:\n got: %v\nwant: %v", v.Name, v.Format, v.Hex, want.Hex)
+		}
+	}
+	if !reflect.DeepEqual(g.Structs, golden.Structs) {
+		t.Errorf("structs differ from golden")
+	}
+}
+
+// TestGoldenDecode verifies the golden encodings decode to values encoding to the same bytes
+func TestGoldenDecode(t *testing.T) {
+	for _, v := range readGolden(t).Vectors {
+		c, ok := Lookup(v.Name)
+		if !ok {
+			t.Fatalf("unknown case %v", v.Name)
+		}
+		m := Mode{Compact: v.Compact}
+		for _, mode := range Modes {
+			if mode.Format.String() == v.Format {
+				m.Format = mode.Format
+			}
+		}
+
+		b, err := hex.DecodeString(v.Hex)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := decode(b, reflect.TypeOf(c.Value), m)
+		if err != nil {
+			t.Errorf("%v %v: %v", v.Name, m, err)
+			continue
+		}
+		again, err := encoding.Marshal(out.Interface(), m.Options()...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hex.EncodeToString(again); got != v.Hex {
+			t.Errorf("%v %v: decoded value encodes to\n got: %v\nwant: %v", v.Name, m, got, v.Hex)
+		}
+	}
+}
+
+// decode decodes b into a value of type t with the codec Marshal encodes t with.
+// Unmarshal decodes through a pointer, encoded with a nil flag, unless t is a slice.
+func decode(b []byte, t reflect.Type, m Mode) (reflect.Value, error) {
+	rv := reflect.New(t)
+	if m.Format == encoding.SelfDescribing {
+		return rv.Elem(), encoding.Unmarshal(b, rv.Interface(), m.Options()...)
+	}
+
+	rv = rv.Elem()
+	c, err := encoding.GetCodecWithRV(rv)
+	if err != nil {
+		return rv, err
+	}
+	d := encoding.NewDecoder(bytes.NewReader(b), m.Options()...)
+	if m.Format == encoding.TLV {
+		if _, err = d.ReadUint8(); err != nil {
+			return rv, err
+		}
+	}
+	return rv, c.Decode(d, rv)
+}
@@ -2,7 +2,9 @@ package encoding
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"sync"
 )
 
 // Generic Codec interface for encoding and decoding
@@ -11,52 +13,156 @@ type Codec interface {
 	Decode(*Decoder, reflect.Value) error
 }
 
+// Marshaler is implemented by types that encode themselves to the wire format
+// without reflection. Implementations are generated by cmd/czgen.
+type Marshaler interface {
+	MarshalCZ(e *Encoder) error
+}
+
+// Unmarshaler is implemented by types that decode themselves from the wire format
+// without reflection. Implementations are generated by cmd/czgen.
+type Unmarshaler interface {
+	UnmarshalCZ(d *Decoder) error
+}
+
+// ErrOverflow is returned when a decoded varint does not fit the integer type of a field
+var ErrOverflow = errors.New("Varint overflows integer type")
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// codecs caches compiled codecs by reflect.Type
+var codecs sync.Map
+
+// compileMux serializes compilation so recursive types resolve to a single codec
+var compileMux sync.Mutex
+
 // GetCodec returns the codec for the given value
 func GetCodec(v interface{}) (Codec, error) {
-	return getCodec(reflect.ValueOf(v))
+	return getCodec(reflect.TypeOf(v))
 }
 
 // GetCodecWithRV returns the codec for the given reflect.Value
 func GetCodecWithRV(v reflect.Value) (Codec, error) {
-	return getCodec(v)
+	if !v.IsValid() {
+		return nil, errors.New("Unsupported type " + v.String())
+	}
+	return getCodec(v.Type())
+}
+
+// getCodec returns the codec of type t. Codecs are compiled once per type
+func getCodec(t reflect.Type) (Codec, error) {
+	if t == nil {
+		return nil, errors.New("Unsupported type nil")
+	}
+	if c, ok := codecs.Load(t); ok {
+		return c.(Codec), nil
+	}
+
+	compileMux.Lock()
+	defer compileMux.Unlock()
+	compiling := make(map[reflect.Type]*recursiveCodec)
+	c, err := compile(t, compiling)
+	if err != nil {
+		return nil, err
+	}
+
+	// Codecs are cached once every type they refer to has compiled
+	for t, r := range compiling {
+		codecs.Store(t, r.Codec)
+	}
+	return c, nil
+}
+
+// recursiveCodec refers to the codec of a type that is being compiled.
+// The codec is set once compilation of the type completes.
+type recursiveCodec struct {
+	Codec
 }
 
-// getCodec returns the codec for the given reflect.Value
-func getCodec(t reflect.Value) (Codec, error) {
+// compile compiles the codec of type t. compiling holds types being compiled
+// to resolve recursive types and the codecs compiled so far, which are cached
+// by getCodec once the root type has compiled.
+func compile(t reflect.Type, compiling map[reflect.Type]*recursiveCodec) (Codec, error) {
+	if c, ok := codecs.Load(t); ok {
+		return c.(Codec), nil
+	}
+	if r, ok := compiling[t]; ok {
+		if r.Codec != nil {
+			return r.Codec, nil
+		}
+		return r, nil
+	}
+
+	r := new(recursiveCodec)
+	compiling[t] = r
+
+	c, err := newCodec(t, compiling)
+	if err != nil {
+		delete(compiling, t)
+		return nil, err
+	}
+	r.Codec = c
+	return c, nil
+}
+
+// newCodec creates the codec of type t
+func newCodec(t reflect.Type, compiling map[reflect.Type]*recursiveCodec) (Codec, error) {
+	// Codecs chosen by the type take priority over codecs of the kind
+	if c, ok := customCodec(t); ok {
+		return c, nil
+	}
+
 	switch t.Kind() {
 	case reflect.String:
 		return new(stringCodec), nil
 	case reflect.Bool:
 		return new(boolCodec), nil
-	case reflect.Int:
-		return new(intCodec), nil
+	case reflect.Int, reflect.Int64:
+		return new(int64Codec), nil
+	case reflect.Int8:
+		return new(int8Codec), nil
+	case reflect.Int16:
+		return new(int16Codec), nil
 	case reflect.Int32:
 		return new(int32Codec), nil
-	case reflect.Int64:
-		return new(int64Codec), nil
 	case reflect.Uint8:
 		return new(uint8Codec), nil
+	case reflect.Uint16:
+		return new(uint16Codec), nil
 	case reflect.Uint32:
 		return new(uint32Codec), nil
-	case reflect.Uint64:
+	case reflect.Uint, reflect.Uintptr, reflect.Uint64:
 		return new(uint64Codec), nil
 	case reflect.Float32:
 		return new(float32Codec), nil
 	case reflect.Float64:
 		return new(float64Codec), nil
+	case reflect.Complex64:
+		return new(complex64Codec), nil
+	case reflect.Complex128:
+		return new(complex128Codec), nil
+	case reflect.Interface:
+		return new(interfaceCodec), nil
+	case reflect.Array:
+		return newArrayCodec(t, compiling)
 	case reflect.Ptr:
-		return newPtrCodec(t)
+		return newPtrCodec(t, compiling)
 	case reflect.Struct:
-		return newStructCodec(t)
+		s, err := newStructCodec(t, compiling)
+		if err != nil {
+			return nil, err
+		}
+		if pt := reflect.PtrTo(t); pt.Implements(marshalerType) && pt.Implements(unmarshalerType) {
+			return &marshalerCodec{fallback: s}, nil
+		}
+		return s, nil
 	case reflect.Map:
-		return newMapCodec(t)
+		return newMapCodec(t, compiling)
 	case reflect.Slice:
-		switch t.Type().Elem().Kind() {
-		case reflect.Ptr:
-			return newSlicePtrCodec(t)
-		default:
-			return newSliceCodec(t)
-		}
+		return newSliceCodec(t, compiling)
 	}
 	return nil, errors.New("Unsupported type " + t.String())
 }
@@ -65,74 +171,102 @@ type boolCodec struct{}
 
 // Encode encodes a value into the encoder.
 func (c *boolCodec) Encode(e *Encoder, rv reflect.Value) error {
-	return e.writeBool(rv.Bool())
+	return e.WriteBool(rv.Bool())
 }
 
 // Decode decodes into a reflect value from the decoder.
-func (c *boolCodec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	if b, err := d.readBool(); err == nil {
-		rv.SetBool(b)
+func (c *boolCodec) Decode(d *Decoder, rv reflect.Value) error {
+	b, err := d.ReadBool()
+	if err != nil {
+		return err
 	}
-	return
+	rv.SetBool(b)
+	return nil
 }
 
 type stringCodec struct{}
 
 // Encode encodes a value into the encoder.
 func (c *stringCodec) Encode(e *Encoder, rv reflect.Value) error {
-	return e.writeString(rv.String())
+	return e.WriteString(rv.String())
 }
 
 // Decode decodes into a reflect value from the decoder.
-func (c *stringCodec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	if s, err := d.readString(); err == nil {
-		rv.SetString(s)
+func (c *stringCodec) Decode(d *Decoder, rv reflect.Value) error {
+	s, err := d.ReadString()
+	if err != nil {
+		return err
 	}
-	return
+	rv.SetString(s)
+	return nil
 }
 
-type intCodec struct{}
+type int8Codec struct{}
 
-func (c *intCodec) Encode(e *Encoder, rv reflect.Value) error {
-	return e.writeInt(int(rv.Int()))
+// Encode encodes a value into the encoder.
+func (c *int8Codec) Encode(e *Encoder, rv reflect.Value) error {
+	return e.WriteInt8(int8(rv.Int()))
 }
 
 // Decode decodes into a reflect value from the decoder.
-func (c *intCodec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	if b, err := d.readInt(); err == nil {
-		rv.SetInt(int64(b))
+func (c *int8Codec) Decode(d *Decoder, rv reflect.Value) error {
+	n, err := d.ReadInt8()
+	if err != nil {
+		return err
 	}
-	return
+	rv.SetInt(int64(n))
+	return nil
+}
+
+type int16Codec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *int16Codec) Encode(e *Encoder, rv reflect.Value) error {
+	return e.WriteInt16(int16(rv.Int()))
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *int16Codec) Decode(d *Decoder, rv reflect.Value) error {
+	n, err := d.ReadInt16()
+	if err != nil {
+		return err
+	}
+	rv.SetInt(int64(n))
+	return nil
 }
 
 type int32Codec struct{}
 
 // Encode encodes a value into the encoder.
 func (c *int32Codec) Encode(e *Encoder, rv reflect.Value) error {
-	return e.writeInt32(int32(rv.Int()))
+	return e.WriteInt32(int32(rv.Int()))
 }
 
 // Decode decodes into a reflect value from the decoder.
-func (c *int32Codec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	if b, err := d.readInt32(); err == nil {
-		rv.SetInt(int64(b))
+func (c *int32Codec) Decode(d *Decoder, rv reflect.Value) error {
+	b, err := d.ReadInt32()
+	if err != nil {
+		return err
 	}
-	return
+	rv.SetInt(int64(b))
+	return nil
 }
 
 type int64Codec struct{}
 
 // Encode encodes a value into the encoder.
 func (c *int64Codec) Encode(e *Encoder, rv reflect.Value) error {
-	return e.writeInt64(int64(rv.Int()))
+	return e.WriteInt64(int64(rv.Int()))
 }
 
 // Decode decodes into a reflect value from the decoder.
-func (c *int64Codec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	if b, err := d.readInt64(); err == nil {
-		rv.SetInt(b)
+func (c *int64Codec) Decode(d *Decoder, rv reflect.Value) error {
+	b, err := d.ReadInt64()
+	if err != nil {
+		return err
 	}
-	return
+	rv.SetInt(b)
+	return nil
 }
 
 // Used for bytes. i.e 1 byte = 8 bits
@@ -140,103 +274,202 @@ type uint8Codec struct{}
 
 // Encode encodes a value into the encoder.
 func (c *uint8Codec) Encode(e *Encoder, rv reflect.Value) error {
-	return e.writeUInt8(uint8(rv.Uint()))
+	return e.WriteUint8(uint8(rv.Uint()))
 }
 
 // Decode decodes into a reflect value from the decoder.
-func (c *uint8Codec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	if b, err := d.readUint8(); err == nil {
-		rv.SetUint(uint64(b))
+func (c *uint8Codec) Decode(d *Decoder, rv reflect.Value) error {
+	b, err := d.ReadUint8()
+	if err != nil {
+		return err
 	}
-	return
+	rv.SetUint(uint64(b))
+	return nil
+}
+
+type uint16Codec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *uint16Codec) Encode(e *Encoder, rv reflect.Value) error {
+	return e.WriteUint16(uint16(rv.Uint()))
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *uint16Codec) Decode(d *Decoder, rv reflect.Value) error {
+	n, err := d.ReadUint16()
+	if err != nil {
+		return err
+	}
+	rv.SetUint(uint64(n))
+	return nil
 }
 
 type uint32Codec struct{}
 
 // Encode encodes a value into the encoder.
 func (c *uint32Codec) Encode(e *Encoder, rv reflect.Value) error {
-	return e.writeUint32(uint32(rv.Uint()))
+	return e.WriteUint32(uint32(rv.Uint()))
 }
 
 // Decode decodes into a reflect value from the decoder.
-func (c *uint32Codec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	if b, err := d.readUint32(); err == nil {
-		rv.SetUint(uint64(b))
+func (c *uint32Codec) Decode(d *Decoder, rv reflect.Value) error {
+	b, err := d.ReadUint32()
+	if err != nil {
+		return err
 	}
-	return
+	rv.SetUint(uint64(b))
+	return nil
 }
 
 type uint64Codec struct{}
 
 // Encode encodes a value into the encoder.
 func (c *uint64Codec) Encode(e *Encoder, rv reflect.Value) error {
-	return e.writeUint64(uint64(rv.Uint()))
+	return e.WriteUint64(uint64(rv.Uint()))
 }
 
 // Decode decodes into a reflect value from the decoder.
-func (c *uint64Codec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	if b, err := d.readUint64(); err == nil {
-		rv.SetUint(uint64(b))
+func (c *uint64Codec) Decode(d *Decoder, rv reflect.Value) error {
+	b, err := d.ReadUint64()
+	if err != nil {
+		return err
 	}
-	return
+	rv.SetUint(uint64(b))
+	return nil
 }
 
 type float32Codec struct{}
 
 // Encode encodes a value into the encoder.
 func (c *float32Codec) Encode(e *Encoder, rv reflect.Value) error {
-	return e.writeFloat32(float32(rv.Float()))
+	return e.WriteFloat32(float32(rv.Float()))
 }
 
 // Decode decodes into a reflect value from the decoder.
-func (c *float32Codec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	if b, err := d.readFloat32(); err == nil {
-		rv.SetFloat(float64(b))
+func (c *float32Codec) Decode(d *Decoder, rv reflect.Value) error {
+	b, err := d.ReadFloat32()
+	if err != nil {
+		return err
 	}
-	return
+	rv.SetFloat(float64(b))
+	return nil
 }
 
 type float64Codec struct{}
 
 // Encode encodes a value into the encoder.
 func (c *float64Codec) Encode(e *Encoder, rv reflect.Value) error {
-	return e.writeFloat64(rv.Float())
+	return e.WriteFloat64(rv.Float())
 }
 
 // Decode decodes into a reflect value from the decoder.
-func (c *float64Codec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	if b, err := d.readFloat64(); err == nil {
-		rv.SetFloat(b)
+func (c *float64Codec) Decode(d *Decoder, rv reflect.Value) error {
+	b, err := d.ReadFloat64()
+	if err != nil {
+		return err
 	}
-	return
+	rv.SetFloat(b)
+	return nil
+}
+
+// complex64Codec encodes the real and imaginary parts as float32
+type complex64Codec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *complex64Codec) Encode(e *Encoder, rv reflect.Value) error {
+	v := rv.Complex()
+	if err := e.WriteFloat32(float32(real(v))); err != nil {
+		return err
+	}
+	return e.WriteFloat32(float32(imag(v)))
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *complex64Codec) Decode(d *Decoder, rv reflect.Value) error {
+	r, err := d.ReadFloat32()
+	if err != nil {
+		return err
+	}
+	i, err := d.ReadFloat32()
+	if err != nil {
+		return err
+	}
+	rv.SetComplex(complex(float64(r), float64(i)))
+	return nil
+}
+
+// complex128Codec encodes the real and imaginary parts as float64
+type complex128Codec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *complex128Codec) Encode(e *Encoder, rv reflect.Value) error {
+	v := rv.Complex()
+	if err := e.WriteFloat64(real(v)); err != nil {
+		return err
+	}
+	return e.WriteFloat64(imag(v))
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *complex128Codec) Decode(d *Decoder, rv reflect.Value) error {
+	r, err := d.ReadFloat64()
+	if err != nil {
+		return err
+	}
+	i, err := d.ReadFloat64()
+	if err != nil {
+		return err
+	}
+	rv.SetComplex(complex(r, i))
+	return nil
 }
 
 // ============================================================================
-// Ptr Codec
+// Marshaler Codec
 // ============================================================================
-type ptrCodec struct {
-	codec Codec
+
+// marshalerCodec encodes structs implementing Marshaler and Unmarshaler.
+// Marshalers produce the Positional format, other formats use the struct codec.
+type marshalerCodec struct {
+	fallback Codec
 }
 
-func newPtrCodec(t reflect.Value) (Codec, error) {
-	switch t.Kind() {
-	case reflect.Ptr:
-		break
-	default:
-		return nil, errors.New("Expected pointer type")
+// Encode encodes a value into the encoder.
+func (c *marshalerCodec) Encode(e *Encoder, rv reflect.Value) error {
+	if e.format != Positional {
+		return c.fallback.Encode(e, rv)
+	}
+	if !rv.CanAddr() {
+		// Copy to call pointer receivers
+		ptr := reflect.New(rv.Type())
+		ptr.Elem().Set(rv)
+		rv = ptr.Elem()
 	}
+	return rv.Addr().Interface().(Marshaler).MarshalCZ(e)
+}
 
-	// Handle nils to prevent cases like infinite recursion
-	// During decode, we retrieve the codecs as needed
-	if t.IsNil() {
-		return new(ptrCodec), nil
+// Decode decodes into a reflect value from the decoder.
+func (c *marshalerCodec) Decode(d *Decoder, rv reflect.Value) error {
+	if d.format != Positional {
+		return c.fallback.Decode(d, rv)
 	}
+	return rv.Addr().Interface().(Unmarshaler).UnmarshalCZ(d)
+}
+
+// ============================================================================
+// Ptr Codec
+// ============================================================================
 
-	codec, err := getCodec(t.Elem())
+// ptrCodec prefixes the value of a pointer with a nil flag
+type ptrCodec struct {
+	codec Codec
+}
+
+func newPtrCodec(t reflect.Type, compiling map[reflect.Type]*recursiveCodec) (Codec, error) {
+	codec, err := compile(t.Elem(), compiling)
 	if err != nil {
 		return nil, err
 	}
-
 	return &ptrCodec{
 		codec: codec,
 	}, nil
@@ -246,65 +479,79 @@ func newPtrCodec(t reflect.Value) (Codec, error) {
 func (p *ptrCodec) Encode(e *Encoder, rv reflect.Value) (err error) {
 	// Mark as nil if the pointer is nil.
 	if rv.IsNil() {
-		e.writeBool(true)
-		return
+		return e.WriteBool(true)
 	}
 
-	if p.codec == nil {
-		return errors.New("Codec not supplied to ptrCodec")
-	}
 	// Mark as not nil.
-	e.writeBool(false)
-	err = p.codec.Encode(e, reflect.Indirect(rv))
-	return
+	if err = e.WriteBool(false); err != nil {
+		return err
+	}
+	return p.codec.Encode(e, rv.Elem())
 }
 
 // Decode decodes into a reflect value from the decoder.
+// A nil pointer sets the value to nil.
 func (p *ptrCodec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	isNil, err := d.readBool()
+	isNil, err := d.ReadBool()
 	if err != nil {
 		return err
 	}
 
+	// The root pointer passed to Unmarshal is left as is
 	if isNil {
+		if rv.CanSet() {
+			rv.Set(reflect.Zero(rv.Type()))
+		}
 		return
 	}
 
-	if rv.IsNil() {
-		rv.Set(reflect.New(rv.Type().Elem()))
+	if err = d.Enter(); err != nil {
+		return err
 	}
+	defer d.Leave()
 
-	// Get the codec at decode stage because we don't want to
-	// go into a recursive loop for nested nil pointers.
-	if p.codec == nil {
-		codec, err := getCodec(rv.Elem())
-		if err != nil {
-			return err
-		}
-		p.codec = codec
+	if rv.IsNil() {
+		rv.Set(reflect.New(rv.Type().Elem()))
 	}
-
 	return p.codec.Decode(d, rv.Elem())
 }
 
 // ============================================================================
 // Map Codec
 // ============================================================================
+
+// mapCodec prefixes key value pairs with the length of the map
 type mapCodec struct {
 	key Codec
 	val Codec
 }
 
+func newMapCodec(t reflect.Type, compiling map[reflect.Type]*recursiveCodec) (Codec, error) {
+	key, err := compile(t.Key(), compiling)
+	if err != nil {
+		return nil, err
+	}
+	val, err := compile(t.Elem(), compiling)
+	if err != nil {
+		return nil, err
+	}
+	return &mapCodec{
+		key: key,
+		val: val,
+	}, nil
+}
+
 // Encode encodes a value into the encoder.
 func (m *mapCodec) Encode(e *Encoder, rv reflect.Value) (err error) {
-	e.writeUint64(uint64(rv.Len()))
-	for _, key := range rv.MapKeys() {
-		value := rv.MapIndex(key)
-
-		if err = m.key.Encode(e, key); err != nil {
+	if err = e.WriteUint64(uint64(rv.Len())); err != nil {
+		return err
+	}
+	iter := rv.MapRange()
+	for iter.Next() {
+		if err = m.key.Encode(e, iter.Key()); err != nil {
 			return err
 		}
-		if err = m.val.Encode(e, value); err != nil {
+		if err = m.val.Encode(e, iter.Value()); err != nil {
 			return err
 		}
 	}
@@ -313,115 +560,138 @@ func (m *mapCodec) Encode(e *Encoder, rv reflect.Value) (err error) {
 
 // Decode decodes into a reflect value from the decoder.
 func (m *mapCodec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	var l uint64
-	var kc, vc Codec
-	l, err = d.readUint64()
+	var l int
+	l, err = d.ReadLength()
 	if err != nil {
 		return
 	}
 
+	if err = d.Enter(); err != nil {
+		return err
+	}
+	defer d.Leave()
+
 	t := rv.Type()
 	rv.Set(reflect.MakeMap(t))
-	for i := 0; i < int(l); i++ {
-
-		kv := reflect.Indirect(reflect.New(rv.Type().Key()))
-		kc = m.key
-		if kc == nil {
-			if kc, err = getCodec(kv); err != nil {
-				return
-			}
-		}
-		if err = kc.Decode(d, kv); err != nil {
+	for i := 0; i < l; i++ {
+		kv := reflect.New(t.Key()).Elem()
+		if err = m.key.Decode(d, kv); err != nil {
 			return
 		}
-		vv := reflect.Indirect(reflect.New(rv.Type().Elem()))
-		vc = m.val
-		if vc == nil {
-			if vc, err = getCodec(vv); err != nil {
-				return
-			}
-		}
-		if err = vc.Decode(d, vv); err != nil {
+		vv := reflect.New(t.Elem()).Elem()
+		if err = m.val.Decode(d, vv); err != nil {
 			return
 		}
-
 		rv.SetMapIndex(kv, vv)
 	}
 	return nil
 }
 
-func newMapCodec(t reflect.Value) (Codec, error) {
-	if t.Len() == 0 {
-		return &mapCodec{}, nil
-	}
-
-	k := reflect.Indirect(reflect.New(t.Type().Key()))
-	key, err := getCodec(k)
-	if err != nil {
-		return nil, err
-	}
-
-	v := reflect.Indirect(reflect.New(t.Type().Elem()))
-	val, err := getCodec(v)
-	if err != nil {
-		return nil, err
-	}
-
-	return &mapCodec{
-		key: key,
-		val: val,
-	}, nil
-}
-
 // ============================================================================
 // Struct Codec
 // ============================================================================
 type (
 	structCodec struct {
-		fields []*fieldCodec
+		fields  []*fieldCodec
+		numbers map[int]int // field numbers to the index of their fieldCodec
 	}
 	fieldCodec struct {
-		index int   // The index of the field used in reflect
-		codec Codec // The codec to use for this field
+		index     int   // The index of the field used in reflect
+		codec     Codec // The codec to use for this field
+		omitEmpty bool  // Prefix the field with a presence flag and omit zero values
+		number    int   // The field number
+		wire      byte  // The TLV wire type
+		raw       bool  // The TLV payload is the raw bytes of a string or byte slice
+		elem      Codec // The codec of the value of pointer fields for TLV payloads
 	}
 )
 
-func newStructCodec(t reflect.Value) (Codec, error) {
-	s := new(structCodec)
-	err := s.genCodec(t)
-	if err != nil {
-		return nil, err
+func newStructCodec(t reflect.Type, compiling map[reflect.Type]*recursiveCodec) (Codec, error) {
+	s := &structCodec{
+		fields:  []*fieldCodec{},
+		numbers: make(map[int]int),
 	}
-	return s, nil
-}
-
-func (s *structCodec) genCodec(t reflect.Value) error {
-	l := t.NumField()
-	s.fields = []*fieldCodec{}
 
-	// Iterate the fields within a struct
-	for i := 0; i < l; i++ {
+	// Parse the tags of the fields within a struct
+	tags := make([]Tag, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
-		// Retrieve respective codec
-		codec, err := getCodec(field)
+		// Unexported fields cannot be decoded and are skipped
+		if field.PkgPath != "" {
+			tags[i].Skip = true
+			continue
+		}
+		tag, err := ParseTag(field.Tag.Get("cz"))
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("%v.%v: %v", t, field.Name, err)
 		}
+		tags[i] = tag
+	}
+	order, err := NumberFields(tags)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", t, err)
+	}
+
+	// Fields are encoded in order of their field number
+	for _, i := range order {
+		field := t.Field(i)
+		tag := tags[i]
 
-		// Append since unexported fields are skipped
+		// Retrieve respective codec
+		var codec Codec
+		switch {
+		case tag.Varint && !isInteger(field.Type.Kind()):
+			return nil, fmt.Errorf("%v.%v: varint requires an integer type", t, field.Name)
+		case tag.Varint && isSigned(field.Type.Kind()):
+			codec = new(varintCodec)
+		case tag.Varint:
+			codec = new(uvarintCodec)
+		case tag.Fixed && isInteger(field.Type.Kind()):
+			codec = new(fixedCodec)
+		default:
+			codec, err = compile(field.Type, compiling)
+			if err != nil {
+				return nil, err
+			}
+		}
+		elem := codec
+		if field.Type.Kind() == reflect.Ptr {
+			if elem, err = compile(field.Type.Elem(), compiling); err != nil {
+				return nil, err
+			}
+		}
+		wire, raw := fieldWireType(field.Type, tag.Varint)
+		s.numbers[tag.Number] = len(s.fields)
 		s.fields = append(s.fields, &fieldCodec{
-			index: i,
-			codec: codec,
+			index:     i,
+			codec:     codec,
+			omitEmpty: tag.OmitEmpty,
+			number:    tag.Number,
+			wire:      wire,
+			raw:       raw,
+			elem:      elem,
 		})
 	}
-
-	return nil
+	return s, nil
 }
 
 // Encode encodes a value into the encoder.
 func (s *structCodec) Encode(e *Encoder, rv reflect.Value) (err error) {
+	if e.format == TLV {
+		return s.encodeTLV(e, rv)
+	}
 	for _, i := range s.fields {
-		if err = i.codec.Encode(e, rv.Field(i.index)); err != nil {
+		v := rv.Field(i.index)
+		if i.omitEmpty {
+			present := !isEmpty(v)
+			if err = e.WriteBool(present); err != nil {
+				return err
+			}
+			if !present {
+				continue
+			}
+		}
+		if err = i.codec.Encode(e, v); err != nil {
 			return err
 		}
 	}
@@ -430,40 +700,102 @@ func (s *structCodec) Encode(e *Encoder, rv reflect.Value) (err error) {
 
 // Decode decodes into a reflect value from the decoder.
 func (s *structCodec) Decode(d *Decoder, rv reflect.Value) (err error) {
+	if err = d.Enter(); err != nil {
+		return err
+	}
+	defer d.Leave()
+
+	if d.format == TLV {
+		return s.decodeTLV(d, rv)
+	}
 	for _, i := range s.fields {
 		v := rv.Field(i.index)
-
-		switch {
-		case v.Kind() == reflect.Ptr:
-			err = i.codec.Decode(d, v)
-		case v.CanSet():
-			err = i.codec.Decode(d, reflect.Indirect(v))
+		if i.omitEmpty {
+			present, err := d.ReadBool()
+			if err != nil {
+				return err
+			}
+			if !present {
+				v.Set(reflect.Zero(v.Type()))
+				continue
+			}
 		}
-		if err != nil {
+		if err = i.codec.Decode(d, v); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// ============================================================================
+// Varint Codec
+// ============================================================================
+
+// varintCodec encodes signed integers of any width as zigzag varints
+type varintCodec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *varintCodec) Encode(e *Encoder, rv reflect.Value) error {
+	return e.WriteVarint(rv.Int())
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *varintCodec) Decode(d *Decoder, rv reflect.Value) error {
+	n, err := d.ReadVarint()
+	if err != nil {
+		return err
+	}
+	if rv.OverflowInt(n) {
+		return ErrOverflow
+	}
+	rv.SetInt(n)
+	return nil
+}
+
+// uvarintCodec encodes unsigned integers of any width as varints
+type uvarintCodec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *uvarintCodec) Encode(e *Encoder, rv reflect.Value) error {
+	return e.WriteUvarint(rv.Uint())
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *uvarintCodec) Decode(d *Decoder, rv reflect.Value) error {
+	n, err := d.ReadUvarint()
+	if err != nil {
+		return err
+	}
+	if rv.OverflowUint(n) {
+		return ErrOverflow
+	}
+	rv.SetUint(n)
+	return nil
+}
+
 // ============================================================================
 // Slice Codec
 // ============================================================================
+
+// sliceCodec prefixes elements with the length of the slice.
+// Pointer elements are prefixed with a nil flag by their ptrCodec.
 type sliceCodec struct {
 	codec Codec
 }
 
 // newSliceCodec returns a new slice codec.
-func newSliceCodec(t reflect.Value) (Codec, error) {
-	if t.Len() == 0 {
-		return &sliceCodec{}, nil
+func newSliceCodec(t reflect.Type, compiling map[reflect.Type]*recursiveCodec) (Codec, error) {
+	// Byte slices are copied as a whole
+	if t.Elem().Kind() == reflect.Uint8 {
+		if _, ok := customCodec(t.Elem()); !ok {
+			return new(bytesCodec), nil
+		}
 	}
-	v := reflect.Indirect(reflect.New(t.Type().Elem()))
-	codec, err := getCodec(v)
+
+	codec, err := compile(t.Elem(), compiling)
 	if err != nil {
 		return nil, err
 	}
-
 	return &sliceCodec{
 		codec: codec,
 	}, nil
@@ -472,10 +804,11 @@ func newSliceCodec(t reflect.Value) (Codec, error) {
 // Encode encodes a value into the encoder.
 func (s *sliceCodec) Encode(e *Encoder, rv reflect.Value) (err error) {
 	l := rv.Len()
-	e.writeUint64(uint64(l))
+	if err = e.WriteUint64(uint64(l)); err != nil {
+		return err
+	}
 	for i := 0; i < l; i++ {
-		v := reflect.Indirect(rv.Index(i).Addr())
-		if err = s.codec.Encode(e, v); err != nil {
+		if err = s.codec.Encode(e, rv.Index(i)); err != nil {
 			return
 		}
 	}
@@ -483,103 +816,198 @@ func (s *sliceCodec) Encode(e *Encoder, rv reflect.Value) (err error) {
 }
 
 // Decode decodes into a reflect value from the decoder.
+// An empty slice sets the value to nil.
 func (s *sliceCodec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	var l uint64
-	if l, err = d.readUint64(); err == nil && l > 0 {
-		rv.Set(reflect.MakeSlice(rv.Type(), int(l), int(l)))
-		for i := 0; i < int(l); i++ {
-			v := reflect.Indirect(rv.Index(i))
-
-			// Get Codec at runtime
-			if s.codec == nil {
-				codec, err := getCodec(v)
-				if err != nil {
-					return err
-				}
-				s.codec = codec
-			}
+	var l int
+	if l, err = d.ReadLength(); err != nil {
+		return
+	}
+	if l == 0 {
+		rv.Set(reflect.Zero(rv.Type()))
+		return
+	}
 
-			if err = s.codec.Decode(d, v); err != nil {
-				return
-			}
+	if err = d.Enter(); err != nil {
+		return err
+	}
+	defer d.Leave()
+
+	// Elements beyond the capacity are appended as they decode
+	n := d.Capacity(l)
+	v := reflect.MakeSlice(rv.Type(), n, n)
+	for i := 0; i < l; i++ {
+		if i == v.Len() {
+			v = reflect.Append(v, reflect.Zero(v.Type().Elem()))
+		}
+		if err = s.codec.Decode(d, v.Index(i)); err != nil {
+			return
 		}
 	}
+	rv.Set(v)
 	return
 }
 
+// bytesCodec encodes byte slices in the format of sliceCodec without
+// encoding each byte through reflection
+type bytesCodec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *bytesCodec) Encode(e *Encoder, rv reflect.Value) error {
+	if err := e.WriteUint64(uint64(rv.Len())); err != nil {
+		return err
+	}
+	return e.WriteBytes(rv.Bytes())
+}
+
+// Decode decodes into a reflect value from the decoder.
+// An empty slice sets the value to nil.
+func (c *bytesCodec) Decode(d *Decoder, rv reflect.Value) error {
+	l, err := d.ReadLength()
+	if err != nil {
+		return err
+	}
+	if l == 0 {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+	b, err := d.ReadBytes(l)
+	if err != nil {
+		return err
+	}
+	rv.SetBytes(b)
+	return nil
+}
+
 // ============================================================================
-// Slice Ptr Codec
+// Fixed Codec
 // ============================================================================
-type slicePtrCodec struct {
+
+// fixedCodec encodes integers with fixed width in compact mode as well
+type fixedCodec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *fixedCodec) Encode(e *Encoder, rv reflect.Value) error {
+	var n uint64
+	if isSigned(rv.Kind()) {
+		n = uint64(rv.Int())
+	} else {
+		n = rv.Uint()
+	}
+	switch rv.Type().Size() {
+	case 1:
+		return e.WriteUint8(uint8(n))
+	case 2:
+		return e.WriteFixed16(uint16(n))
+	case 4:
+		return e.WriteFixed32(uint32(n))
+	default:
+		return e.WriteFixed64(n)
+	}
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *fixedCodec) Decode(d *Decoder, rv reflect.Value) error {
+	var n uint64
+	var signed int64
+	switch rv.Type().Size() {
+	case 1:
+		b, err := d.ReadUint8()
+		if err != nil {
+			return err
+		}
+		n, signed = uint64(b), int64(int8(b))
+	case 2:
+		b, err := d.ReadFixed16()
+		if err != nil {
+			return err
+		}
+		n, signed = uint64(b), int64(int16(b))
+	case 4:
+		b, err := d.ReadFixed32()
+		if err != nil {
+			return err
+		}
+		n, signed = uint64(b), int64(int32(b))
+	default:
+		b, err := d.ReadFixed64()
+		if err != nil {
+			return err
+		}
+		n, signed = b, int64(b)
+	}
+	if isSigned(rv.Kind()) {
+		rv.SetInt(signed)
+	} else {
+		rv.SetUint(n)
+	}
+	return nil
+}
+
+// ============================================================================
+// Array Codec
+// ============================================================================
+
+// arrayCodec encodes the elements of a fixed size array without a length
+type arrayCodec struct {
 	codec Codec
-	type_ reflect.Type
 }
 
-func newSlicePtrCodec(t reflect.Value) (Codec, error) {
-	// Do not provide codec for empty slice
-	if t.Len() == 0 {
-		return &slicePtrCodec{
-			type_: t.Type().Elem().Elem(),
-		}, nil
+func newArrayCodec(t reflect.Type, compiling map[reflect.Type]*recursiveCodec) (Codec, error) {
+	// Byte arrays are copied as a whole
+	if t.Elem() == reflect.TypeOf(byte(0)) {
+		return new(byteArrayCodec), nil
 	}
 
-	codec, err := getCodec(t.Index(0).Elem())
+	codec, err := compile(t.Elem(), compiling)
 	if err != nil {
 		return nil, err
 	}
-
-	return &slicePtrCodec{
+	return &arrayCodec{
 		codec: codec,
-		type_: t.Type().Elem().Elem(),
 	}, nil
 }
 
 // Encode encodes a value into the encoder.
-func (c *slicePtrCodec) Encode(e *Encoder, rv reflect.Value) (err error) {
-	l := rv.Len()
-	e.writeUint64(uint64(l))
-
-	for i := 0; i < l; i++ {
-		v := rv.Index(i)
-		e.writeBool(v.IsNil())
-		if !v.IsNil() {
-			if err = c.codec.Encode(e, reflect.Indirect(v)); err != nil {
-				return err
-			}
+func (a *arrayCodec) Encode(e *Encoder, rv reflect.Value) error {
+	for i := 0; i < rv.Len(); i++ {
+		if err := a.codec.Encode(e, rv.Index(i)); err != nil {
+			return err
 		}
 	}
-	return
+	return nil
 }
 
 // Decode decodes into a reflect value from the decoder.
-func (c *slicePtrCodec) Decode(d *Decoder, rv reflect.Value) (err error) {
-	var l uint64
-	var isNil bool
-	if l, err = d.readUint64(); err == nil && l > 0 {
-		rv.Set(reflect.MakeSlice(rv.Type(), int(l), int(l)))
-		for i := 0; i < int(l); i++ {
-
-			if isNil, err = d.readBool(); !isNil {
-				if err != nil {
-					return err
-				}
-
-				ptr := rv.Index(i)
-				ptr.Set(reflect.New(c.type_))
-
-				if c.codec == nil {
-					codec, err := getCodec(ptr.Elem())
-					if err != nil {
-						return err
-					}
-					c.codec = codec
-				}
-
-				if err = c.codec.Decode(d, reflect.Indirect(ptr)); err != nil {
-					return
-				}
-			}
+func (a *arrayCodec) Decode(d *Decoder, rv reflect.Value) error {
+	if err := d.Enter(); err != nil {
+		return err
+	}
+	defer d.Leave()
+
+	for i := 0; i < rv.Len(); i++ {
+		if err := a.codec.Decode(d, rv.Index(i)); err != nil {
+			return err
 		}
 	}
-	return
+	return nil
+}
+
+// byteArrayCodec encodes byte arrays as their bytes
+type byteArrayCodec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *byteArrayCodec) Encode(e *Encoder, rv reflect.Value) error {
+	b := make([]byte, rv.Len())
+	reflect.Copy(reflect.ValueOf(b), rv)
+	return e.WriteBytes(b)
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *byteArrayCodec) Decode(d *Decoder, rv reflect.Value) error {
+	b, err := d.next(rv.Len())
+	if err != nil {
+		return err
+	}
+	reflect.Copy(rv, reflect.ValueOf(b))
+	return nil
 }
@@ -0,0 +1,128 @@
+package encoding
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type node struct {
+	Value    int32
+	Next     *node
+	Children []node
+	Index    map[string]*node
+}
+
+type brokenNode struct {
+	Value int32
+	Next  *brokenChild
+}
+
+type brokenChild struct {
+	Parent *brokenNode
+	Done   chan bool
+}
+
+// TestRecursiveTypes verifies recursive types round trip and types failing to
+// compile are not cached through the types they refer to
+func TestRecursiveTypes(t *testing.T) {
+	want := &node{
+		Value: 1,
+		Next:  &node{Value: 2, Next: &node{Value: 3}},
+		Children: []node{
+			{Value: 4, Children: []node{{Value: 5}}},
+		},
+		Index: map[string]*node{"six": {Value: 6}},
+	}
+	for _, opts := range [][]Option{nil, {WithFormat(TLV)}, {WithCompact(true)}} {
+		b, err := Marshal(want, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := new(node)
+		if err = Unmarshal(b, got, opts...); err != nil {
+			t.Fatal(err)
+		}
+		// Empty and nil slices and maps share an encoding
+		again, err := Marshal(got, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(again, b) || got.Next.Next.Value != 3 || got.Index["six"].Value != 6 {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := Marshal(&brokenNode{Value: 1}); err == nil {
+			t.Fatal("Marshal of an unsupported nested field succeeded")
+		}
+		if _, err := Marshal(&brokenChild{Parent: &brokenNode{}}); err == nil {
+			t.Fatal("Marshal of a type referring to a failed type succeeded")
+		}
+		if _, err := Marshal(&brokenNode{Next: &brokenChild{}}); err == nil {
+			t.Fatal("Marshal of an unsupported nested field succeeded")
+		}
+	}
+}
+
+type reused struct {
+	Next  *node
+	Seats []int32
+	Data  []byte
+	Value interface{}
+}
+
+// TestDecodeNil verifies nil and empty values replace the values of a reused destination
+func TestDecodeNil(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithCompact(true)}} {
+		b, err := Marshal(&reused{Seats: []int32{}}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &reused{
+			Next:  &node{Value: 1},
+			Seats: []int32{1, 2},
+			Data:  []byte{3},
+			Value: int32(4),
+		}
+		if err = Unmarshal(b, got, opts...); err != nil {
+			t.Fatal(err)
+		}
+		if got.Next != nil || got.Seats != nil || got.Data != nil || got.Value != nil {
+			t.Fatalf("got %+v, want stale values replaced by nil", got)
+		}
+	}
+
+	// The root pointer of a nil value is not settable
+	if err := Unmarshal([]byte{1}, &reused{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type payload struct {
+	Data []byte
+}
+
+// TestBytesLimits verifies byte slices count towards the elements of a value
+func TestBytesLimits(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithCompact(true)}, {WithFormat(TLV)}} {
+		b, err := Marshal(&payload{Data: []byte("Singapore")}, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Unmarshal(b, new(payload), append(opts, WithLimits(Limits{MaxElements: 8}))...)
+		if !errors.Is(err, ErrMaxElements) {
+			t.Fatalf("got %v, want %v", err, ErrMaxElements)
+		}
+		if err = Unmarshal(b, new(payload), append(opts, WithLimits(Limits{MaxElements: 9}))...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Payload of 2^62 bytes
+	forged := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0x40}
+	if err := Unmarshal(forged, new(payload)); !errors.Is(err, ErrMaxElements) {
+		t.Fatalf("forged byte length: got %v, want %v", err, ErrMaxElements)
+	}
+}
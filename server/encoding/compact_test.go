@@ -0,0 +1,94 @@
+package encoding
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+)
+
+type compactFlight struct {
+	ID     int32
+	Seats  uint16
+	Fare   float32
+	Ref    uint32 `cz:",fixed"`
+	Source string
+	Delay  int64
+}
+
+// TestCompactVarints verifies integers are zigzag varints in compact mode
+func TestCompactVarints(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want []byte
+	}{
+		{int32(0), []byte{0x00}},
+		{int32(-1), []byte{0x01}},
+		{int32(1), []byte{0x02}},
+		{int16(-64), []byte{0x7f}},
+		{int16(64), []byte{0x80, 0x01}},
+		{uint16(300), []byte{0xac, 0x02}},
+		{int64(math.MaxInt64), []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{int64(math.MinInt64), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{uint64(math.MaxUint64), []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}},
+		{int8(-1), []byte{0xff}},
+		{"ab", []byte{0x02, 'a', 'b'}},
+	}
+	for _, test := range tests {
+		b, err := Marshal(test.v, WithCompact(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, test.want) {
+			t.Fatalf("%T(%v): got %x, want %x", test.v, test.v, b, test.want)
+		}
+	}
+
+	// Floats and fixed fields keep their width
+	want := &compactFlight{ID: -7, Seats: 120, Fare: 99.5, Ref: 1, Source: "SIN", Delay: -90}
+	b, err := Marshal(want, WithCompact(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	positional, err := Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 1+1+1+4+4+1+3+2 || len(b) >= len(positional) {
+		t.Fatalf("got %v bytes, %v bytes positional", len(b), len(positional))
+	}
+	got := new(compactFlight)
+	if err = Unmarshal(b, got, WithCompact(true)); err != nil || *got != *want {
+		t.Fatalf("got %+v, %v, want %+v", got, err, want)
+	}
+}
+
+// TestCompactOverflow verifies varints overflowing their type or 64 bits are rejected
+func TestCompactOverflow(t *testing.T) {
+	wide := int64(math.MaxInt32) + 1
+	b, err := Marshal(&wide, WithCompact(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var narrow int32
+	if err = Unmarshal(b, &narrow, WithCompact(true)); err != ErrOverflow {
+		t.Fatalf("got %v, want %v", err, ErrOverflow)
+	}
+	var seats uint16
+	if err = Unmarshal(b, &seats, WithCompact(true)); err != ErrOverflow {
+		t.Fatalf("got %v, want %v", err, ErrOverflow)
+	}
+
+	// 11 bytes and 10 bytes of more than 64 bits
+	for _, b := range [][]byte{
+		append([]byte{0x00}, bytes.Repeat([]byte{0x80}, 10)...),
+		append(append([]byte{0x00}, bytes.Repeat([]byte{0xff}, 9)...), 0x02),
+	} {
+		if err = Unmarshal(b, &wide, WithCompact(true)); err == nil {
+			t.Fatalf("%x: Unmarshal of an overflowing varint succeeded", b)
+		}
+	}
+	if err = Unmarshal([]byte{0x00, 0x80}, &wide, WithCompact(true)); err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -1,18 +1,40 @@
 package encoding
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"io"
 	"math"
 	"reflect"
 )
 
+// Decoder decodes values from a byte stream
 type Decoder struct {
 	reader *BufferReader
+	format Format
+
+	// Read integers as varints and lengths as uvarints
+	compact bool
+
+	// Resources decoding a value may use and their usage by the current value
+	limits Limits
+	usage  *usage
+
+	// Source of values decoded by Decode. Nil for Unmarshal
+	in *bufio.Reader
+	// Number of bytes read from in
+	consumed int64
+	// Number of bytes read from in before the current value
+	start int64
+	// Scratch buffer of fixed size values read from in
+	scratch [8]byte
 }
 
 // Unmarshal decodes the byte slice stream into the value v.
-func Unmarshal(data []byte, v interface{}) error {
-	d := newDecoder(data)
+func Unmarshal(data []byte, v interface{}, opts ...Option) error {
+	d := newDecoder(data, newOptions(opts))
 	err := d.unmarshal(v)
 	if err != nil {
 		return err
@@ -20,10 +42,49 @@ func Unmarshal(data []byte, v interface{}) error {
 	return nil
 }
 
-func newDecoder(data []byte) *Decoder {
+func newDecoder(data []byte, o options) *Decoder {
+	reader := NewBufferReader(data)
+	reader.SetLimit(o.limits.MaxBytes)
+	return &Decoder{
+		reader:  reader,
+		format:  o.format,
+		compact: o.compact,
+		limits:  o.limits,
+		usage:   new(usage),
+	}
+}
+
+// NewDecoder returns a decoder reading a sequence of values from r.
+// The decoder buffers r and may read past the last value decoded.
+func NewDecoder(r io.Reader, opts ...Option) *Decoder {
+	in, ok := r.(*bufio.Reader)
+	if !ok {
+		in = bufio.NewReader(r)
+	}
+	o := newOptions(opts)
 	return &Decoder{
-		reader: NewBufferReader(data),
+		in:      in,
+		format:  o.format,
+		compact: o.compact,
+		limits:  o.limits,
+		usage:   new(usage),
+	}
+}
+
+// Decode reads the next value from the reader of the decoder into v.
+// io.EOF is returned once the reader ends between values.
+func (d *Decoder) Decode(v interface{}) error {
+	if d.in == nil {
+		return errors.New("Decode: Decoder has no reader")
+	}
+
+	d.start = d.consumed
+	*d.usage = usage{}
+	err := d.unmarshal(v)
+	if err == io.EOF && d.consumed != d.start {
+		return io.ErrUnexpectedEOF
 	}
+	return err
 }
 
 // unmarshal decodes the byte slice stream into the value v.
@@ -32,6 +93,10 @@ func (d *Decoder) unmarshal(v interface{}) error {
 	var c Codec
 
 	rv := reflect.ValueOf(v)
+	if d.format == SelfDescribing {
+		return d.unmarshalDescribed(rv)
+	}
+
 	// slice cannot be passed directly and need to be passed by reference
 	// as such, the GetCodec will evaluate it as a pointer where the
 	// marshal would evaluate it as a slice I.E. not set the ptr flag.
@@ -48,98 +113,332 @@ func (d *Decoder) unmarshal(v interface{}) error {
 		return err
 	}
 
+	if d.format == TLV {
+		version, err := d.ReadUint8()
+		if err != nil {
+			return err
+		}
+		if version == 0 || version > TLVVersion {
+			return ErrTLVVersion
+		}
+	}
+
 	// rv := reflect.Indirect(reflect.ValueOf(v))
 	return c.Decode(d, rv)
 }
 
-// readBool deserializes boolean values
-func (d *Decoder) readBool() (b bool, err error) {
-	if err := binary.Read(d.reader, binary.LittleEndian, &b); err != nil {
+// sub returns a decoder of the payload b read by d. The decoder shares the
+// usage of d, and the bytes of b have been charged to d.
+func (d *Decoder) sub(b []byte) *Decoder {
+	return &Decoder{
+		reader:  NewBufferReader(b),
+		format:  d.format,
+		compact: d.compact,
+		limits:  d.limits,
+		usage:   d.usage,
+	}
+}
+
+// next returns the next n bytes of the stream.
+// io.EOF is returned if the stream has ended and io.ErrUnexpectedEOF if it ends within n bytes.
+func (d *Decoder) next(n int) ([]byte, error) {
+	if n == 0 {
+		return []byte{}, nil
+	}
+	// Lengths beyond an int
+	if n < 0 {
+		return nil, exceeded(ErrMaxBytes, d.limits.MaxBytes)
+	}
+	if d.in != nil {
+		return d.read(n)
+	}
+	remaining := d.reader.Len()
+	if remaining == 0 {
+		return nil, io.EOF
+	}
+	if remaining < n {
+		d.reader.Slice(uint(remaining))
+		return nil, io.ErrUnexpectedEOF
+	}
+	return d.reader.Slice(uint(n))
+}
+
+// read returns the next n bytes of the reader.
+// Bytes of fixed size values are only valid until the next read.
+func (d *Decoder) read(n int) ([]byte, error) {
+	if max := d.limits.MaxBytes; max > 0 && int64(n) > int64(max)-(d.consumed-d.start) {
+		return nil, exceeded(ErrMaxBytes, max)
+	}
+
+	var b []byte
+	switch {
+	case n <= len(d.scratch):
+		b = d.scratch[:n]
+	case n <= readChunk:
+		b = make([]byte, n)
+	default:
+		return d.readLarge(n)
+	}
+	read, err := io.ReadFull(d.in, b)
+	d.consumed += int64(read)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// readChunk is the largest read allocated up front
+const readChunk = 64 << 10
+
+// readLarge returns the next n bytes of the reader. The bytes are buffered as
+// they arrive, hence a forged length can't allocate more than the reader holds.
+func (d *Decoder) readLarge(n int) ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.Grow(readChunk)
+	read, err := io.CopyN(&buffer, d.in, int64(n))
+	d.consumed += read
+	if err == io.EOF && read > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// ReadLength deserializes the length of a slice or map.
+// The length is charged against the max elements of the value.
+func (d *Decoder) ReadLength() (int, error) {
+	l, err := d.ReadUint64()
+	if err != nil {
+		return 0, err
+	}
+	return d.charge(l)
+}
+
+// Capacity returns the capacity to allocate up front for l elements read with
+// ReadLength. It is bounded by the unread input, hence a forged length can't
+// allocate more than the input holds. Slices of more elements grow as they decode.
+func (d *Decoder) Capacity(l int) int {
+	n := readChunk
+	if d.in == nil {
+		n = d.reader.Len()
+	}
+	if l < n {
+		return l
+	}
+	return n
+}
+
+// ReadBool deserializes boolean values
+func (d *Decoder) ReadBool() (bool, error) {
+	n, err := d.ReadUint8()
+	if err != nil {
 		return false, err
 	}
-	return
+	return n != 0, nil
 }
 
-// readString deserializes data to string.
-func (d *Decoder) readString() (string, error) {
-	var length uint32
-	if err := binary.Read(d.reader, binary.LittleEndian, &length); err != nil {
+// ReadString deserializes data to string.
+func (d *Decoder) ReadString() (string, error) {
+	var length uint64
+	var err error
+	if d.compact {
+		length, err = d.ReadUvarint()
+	} else {
+		var n uint32
+		n, err = d.ReadFixed32()
+		length = uint64(n)
+	}
+	if err != nil {
 		return "", err
 	}
 
-	strBytes := make([]byte, length)
-	if err := binary.Read(d.reader, binary.LittleEndian, &strBytes); err != nil {
+	strBytes, err := d.next(int(length))
+	if err != nil {
 		return "", err
 	}
 	return string(strBytes), nil
 }
 
-// readInt8 deserializes data to int8.
-func (d *Decoder) readInt() (n int, err error) {
-	if err = binary.Read(d.reader, binary.LittleEndian, &n); err != nil {
-		return 0, err
+// ReadBytes deserializes the next n bytes to a byte slice.
+func (d *Decoder) ReadBytes(n int) ([]byte, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
 	}
-	return
+	return append([]byte(nil), b...), nil
 }
 
-// writeInt32 writes a 8 bit integer
-func (d *Decoder) readUint8() (n uint8, err error) {
-	if err = binary.Read(d.reader, binary.LittleEndian, &n); err != nil {
-		return 0, err
+// ReadInt deserializes a 64 bit integer to int.
+func (d *Decoder) ReadInt() (int, error) {
+	n, err := d.ReadInt64()
+	return int(n), err
+}
+
+// ReadUint deserializes a 64 bit integer to uint.
+func (d *Decoder) ReadUint() (uint, error) {
+	n, err := d.ReadUint64()
+	return uint(n), err
+}
+
+// ReadInt8 deserializes data to int8.
+func (d *Decoder) ReadInt8() (int8, error) {
+	n, err := d.ReadUint8()
+	return int8(n), err
+}
+
+// ReadInt16 deserializes data to int16.
+func (d *Decoder) ReadInt16() (int16, error) {
+	if d.compact {
+		n, err := d.readVarint(16)
+		return int16(n), err
 	}
-	return
+	n, err := d.ReadFixed16()
+	return int16(n), err
 }
 
-// readInt32 deserializes data to int32.
-func (d *Decoder) readUint32() (n uint32, err error) {
-	if err = binary.Read(d.reader, binary.LittleEndian, &n); err != nil {
+// ReadUint8 deserializes data to uint8.
+func (d *Decoder) ReadUint8() (uint8, error) {
+	b, err := d.next(1)
+	if err != nil {
 		return 0, err
 	}
-	return
+	return b[0], nil
+}
+
+// ReadUint16 deserializes data to uint16.
+func (d *Decoder) ReadUint16() (uint16, error) {
+	if d.compact {
+		n, err := d.readUvarint(16)
+		return uint16(n), err
+	}
+	return d.ReadFixed16()
+}
+
+// ReadUint32 deserializes data to uint32.
+func (d *Decoder) ReadUint32() (uint32, error) {
+	if d.compact {
+		n, err := d.readUvarint(32)
+		return uint32(n), err
+	}
+	return d.ReadFixed32()
+}
+
+// ReadUint64 deserializes data to uint64.
+func (d *Decoder) ReadUint64() (uint64, error) {
+	if d.compact {
+		return d.ReadUvarint()
+	}
+	return d.ReadFixed64()
+}
+
+// ReadInt32 deserializes data to int32.
+func (d *Decoder) ReadInt32() (int32, error) {
+	if d.compact {
+		n, err := d.readVarint(32)
+		return int32(n), err
+	}
+	n, err := d.ReadFixed32()
+	return int32(n), err
+}
+
+// ReadInt64 deserializes data to int64.
+func (d *Decoder) ReadInt64() (int64, error) {
+	if d.compact {
+		return d.ReadVarint()
+	}
+	n, err := d.ReadFixed64()
+	return int64(n), err
 }
 
-// readInt64 deserializes data to int64.
-func (d *Decoder) readUint64() (n uint64, err error) {
-	if err = binary.Read(d.reader, binary.LittleEndian, &n); err != nil {
+// ReadFixed16 deserializes a fixed width 16 bit integer regardless of mode.
+func (d *Decoder) ReadFixed16() (uint16, error) {
+	b, err := d.next(2)
+	if err != nil {
 		return 0, err
 	}
-	return
+	return binary.LittleEndian.Uint16(b), nil
 }
 
-// readInt32 deserializes data to int32.
-func (d *Decoder) readInt32() (n int32, err error) {
-	if err = binary.Read(d.reader, binary.LittleEndian, &n); err != nil {
+// ReadFixed32 deserializes a fixed width 32 bit integer regardless of mode.
+func (d *Decoder) ReadFixed32() (uint32, error) {
+	b, err := d.next(4)
+	if err != nil {
 		return 0, err
 	}
-	return
+	return binary.LittleEndian.Uint32(b), nil
 }
 
-// readInt64 deserializes data to int64.
-func (d *Decoder) readInt64() (n int64, err error) {
-	if err = binary.Read(d.reader, binary.LittleEndian, &n); err != nil {
+// ReadFixed64 deserializes a fixed width 64 bit integer regardless of mode.
+func (d *Decoder) ReadFixed64() (uint64, error) {
+	b, err := d.next(8)
+	if err != nil {
 		return 0, err
 	}
-	return
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// readUvarint deserializes a uvarint that must fit in bits.
+func (d *Decoder) readUvarint(bits int) (uint64, error) {
+	n, err := d.ReadUvarint()
+	if err == nil && n>>bits != 0 {
+		return 0, ErrOverflow
+	}
+	return n, err
+}
+
+// readVarint deserializes a zigzag varint that must fit in bits.
+func (d *Decoder) readVarint(bits int) (int64, error) {
+	n, err := d.ReadVarint()
+	if err == nil && (n < -1<<(bits-1) || n >= 1<<(bits-1)) {
+		return 0, ErrOverflow
+	}
+	return n, err
+}
+
+// ReadUvarint deserializes a uvarint to uint64.
+func (d *Decoder) ReadUvarint() (uint64, error) {
+	var n uint64
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		b, err := d.next(1)
+		if err != nil {
+			if i > 0 && err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if b[0] < 0x80 {
+			if i == binary.MaxVarintLen64-1 && b[0] > 1 {
+				break
+			}
+			return n | uint64(b[0])<<(7*i), nil
+		}
+		n |= uint64(b[0]&0x7f) << (7 * i)
+	}
+	return 0, errors.New("varint overflows a 64-bit integer")
+}
+
+// ReadVarint deserializes a zigzag encoded varint to int64.
+func (d *Decoder) ReadVarint() (int64, error) {
+	n, err := d.ReadUvarint()
+	return int64(n>>1) ^ -int64(n&1), err
 }
 
-// readFloat32 deserializes data to float32.
-func (d *Decoder) readFloat32() (n float32, err error) {
-	var bits uint32
-	err = binary.Read(d.reader, binary.LittleEndian, &bits)
+// ReadFloat32 deserializes data to float32.
+func (d *Decoder) ReadFloat32() (float32, error) {
+	bits, err := d.ReadFixed32()
 	if err != nil {
 		return 0, err
 	}
-	n = math.Float32frombits(bits)
-	return
+	return math.Float32frombits(bits), nil
 }
 
-// readFloat64 for a float64.
-func (d *Decoder) readFloat64() (n float64, err error) {
-	var bits uint64
-	err = binary.Read(d.reader, binary.LittleEndian, &bits)
+// ReadFloat64 deserializes data to float64.
+func (d *Decoder) ReadFloat64() (float64, error) {
+	bits, err := d.ReadFixed64()
 	if err != nil {
 		return 0, err
 	}
-	n = math.Float64frombits(bits)
-	return
+	return math.Float64frombits(bits), nil
 }
@@ -0,0 +1,758 @@
+package encoding
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// DescribedVersion is the version of the SelfDescribing format written as the
+// first byte of a self-describing stream. Decoders reject streams of newer versions.
+const DescribedVersion byte = 1
+
+// Type tags preceding values of the SelfDescribing format
+const (
+	TagNil     byte = 0  // Nil pointers, interfaces, slices and maps
+	TagBool    byte = 1  // A byte of 0 or 1
+	TagInt     byte = 2  // Zigzag varint
+	TagUint    byte = 3  // Uvarint
+	TagFloat32 byte = 4  // 4 bytes little endian
+	TagFloat64 byte = 5  // 8 bytes little endian
+	TagComplex byte = 6  // Real and imaginary parts of 8 bytes little endian each
+	TagString  byte = 7  // Uvarint length followed by the bytes
+	TagBytes   byte = 8  // Uvarint length followed by the bytes
+	TagList    byte = 9  // Uvarint length followed by the elements of slices and arrays
+	TagMap     byte = 10 // Uvarint length followed by keys and their values
+	TagStruct  byte = 11 // Uvarint length followed by the number, name and value of fields
+	TagExt     byte = 12 // Name of the type followed by the bytes of its codec
+)
+
+var ErrDescribedVersion = errors.New("Unsupported self-describing version")
+
+// Ext is a value of a type with its own codec, e.g. one registered with
+// RegisterCodec. Data is the Positional encoding of the value by its codec.
+// UnmarshalAny decodes values of types registered with RegisterType instead.
+type Ext struct {
+	Type string
+	Data []byte
+}
+
+// UnmarshalAny decodes a value of the SelfDescribing format without knowing its type.
+// Values decode to nil, bool, int64, uint64, float32, float64, complex128, string,
+// []byte, []interface{} for slices and arrays, map[string]interface{} for maps and
+// structs by field name, and Ext or the registered type of types with their own codec.
+// Keys of maps other than strings are formatted with fmt.Sprint.
+func UnmarshalAny(data []byte, opts ...Option) (interface{}, error) {
+	o := newOptions(opts)
+	o.format = SelfDescribing
+	return newDecoder(data, o).decodeAny()
+}
+
+// DecodeAny reads the next value of the SelfDescribing format from the reader
+// of the decoder without knowing its type. See UnmarshalAny.
+func (d *Decoder) DecodeAny() (interface{}, error) {
+	if d.in == nil {
+		return nil, errors.New("DecodeAny: Decoder has no reader")
+	}
+	if d.format != SelfDescribing {
+		return nil, errors.New("DecodeAny: Decoder is not SelfDescribing")
+	}
+
+	d.start = d.consumed
+	*d.usage = usage{}
+	v, err := d.decodeAny()
+	if err == io.EOF && d.consumed != d.start {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+// decodeAny decodes a versioned self-describing value to its plain form
+func (d *Decoder) decodeAny() (interface{}, error) {
+	if err := d.readDescribedVersion(); err != nil {
+		return nil, err
+	}
+	v, err := d.readDescribed()
+	if err != nil {
+		return nil, err
+	}
+	return d.plain(v)
+}
+
+// readDescribedVersion reads the version byte of a self-describing value
+func (d *Decoder) readDescribedVersion() error {
+	version, err := d.ReadUint8()
+	if err != nil {
+		return err
+	}
+	if version == 0 || version > DescribedVersion {
+		return ErrDescribedVersion
+	}
+	return nil
+}
+
+// ============================================================================
+// Encoding
+// ============================================================================
+
+// typeName returns the registered name of t, or its name in Go
+func typeName(t reflect.Type) string {
+	typesMux.RLock()
+	name, ok := names[t]
+	typesMux.RUnlock()
+	if ok {
+		return name
+	}
+	return t.String()
+}
+
+// describedFields returns the struct codec of t which numbers and orders its fields
+func describedFields(t reflect.Type) (*structCodec, error) {
+	c, err := getCodec(t)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := c.(*marshalerCodec); ok {
+		c = m.fallback
+	}
+	s, ok := c.(*structCodec)
+	if !ok {
+		return nil, errors.New("Unsupported type " + t.String())
+	}
+	return s, nil
+}
+
+// writeDescribed writes rv preceded by its type tag
+func (e *Encoder) writeDescribed(rv reflect.Value) error {
+	if !rv.IsValid() {
+		return e.WriteUint8(TagNil)
+	}
+
+	t := rv.Type()
+	if c, ok := customCodec(t); ok {
+		return e.writeExt(c, rv)
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return e.WriteUint8(TagNil)
+		}
+		return e.writeDescribed(rv.Elem())
+	case reflect.Bool:
+		if err := e.WriteUint8(TagBool); err != nil {
+			return err
+		}
+		return e.WriteBool(rv.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if err := e.WriteUint8(TagInt); err != nil {
+			return err
+		}
+		return e.WriteVarint(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if err := e.WriteUint8(TagUint); err != nil {
+			return err
+		}
+		return e.WriteUvarint(rv.Uint())
+	case reflect.Float32:
+		if err := e.WriteUint8(TagFloat32); err != nil {
+			return err
+		}
+		return e.WriteFloat32(float32(rv.Float()))
+	case reflect.Float64:
+		if err := e.WriteUint8(TagFloat64); err != nil {
+			return err
+		}
+		return e.WriteFloat64(rv.Float())
+	case reflect.Complex64, reflect.Complex128:
+		if err := e.WriteUint8(TagComplex); err != nil {
+			return err
+		}
+		if err := e.WriteFloat64(real(rv.Complex())); err != nil {
+			return err
+		}
+		return e.WriteFloat64(imag(rv.Complex()))
+	case reflect.String:
+		return e.writeDescribedBytes(TagString, []byte(rv.String()))
+	case reflect.Slice:
+		if rv.IsNil() {
+			return e.WriteUint8(TagNil)
+		}
+		if isBytes(t) {
+			return e.writeDescribedBytes(TagBytes, rv.Bytes())
+		}
+		return e.writeList(rv)
+	case reflect.Array:
+		if isBytes(t) {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return e.writeDescribedBytes(TagBytes, b)
+		}
+		return e.writeList(rv)
+	case reflect.Map:
+		if rv.IsNil() {
+			return e.WriteUint8(TagNil)
+		}
+		return e.writeMap(rv)
+	case reflect.Struct:
+		return e.writeStruct(rv)
+	}
+	return errors.New("Unsupported type " + t.String())
+}
+
+// isBytes returns true if t is a slice or array of bytes without their own codec
+func isBytes(t reflect.Type) bool {
+	if t.Elem().Kind() != reflect.Uint8 {
+		return false
+	}
+	_, ok := customCodec(t.Elem())
+	return !ok
+}
+
+// writeDescribedBytes writes b preceded by tag and its length
+func (e *Encoder) writeDescribedBytes(tag byte, b []byte) error {
+	if err := e.WriteUint8(tag); err != nil {
+		return err
+	}
+	if err := e.WriteUvarint(uint64(len(b))); err != nil {
+		return err
+	}
+	return e.WriteBytes(b)
+}
+
+// writeList writes the elements of a slice or array
+func (e *Encoder) writeList(rv reflect.Value) error {
+	if err := e.WriteUint8(TagList); err != nil {
+		return err
+	}
+	if err := e.WriteUvarint(uint64(rv.Len())); err != nil {
+		return err
+	}
+	for i := 0; i < rv.Len(); i++ {
+		if err := e.writeDescribed(rv.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMap writes the keys and values of a map
+func (e *Encoder) writeMap(rv reflect.Value) error {
+	if err := e.WriteUint8(TagMap); err != nil {
+		return err
+	}
+	if err := e.WriteUvarint(uint64(rv.Len())); err != nil {
+		return err
+	}
+	iter := rv.MapRange()
+	for iter.Next() {
+		if err := e.writeDescribed(iter.Key()); err != nil {
+			return err
+		}
+		if err := e.writeDescribed(iter.Value()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStruct writes the number, name and value of fields in order of their
+// field number. Empty fields tagged omitempty are omitted.
+func (e *Encoder) writeStruct(rv reflect.Value) error {
+	s, err := describedFields(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	fields := make([]*fieldCodec, 0, len(s.fields))
+	for _, f := range s.fields {
+		if !f.omitEmpty || !isEmpty(rv.Field(f.index)) {
+			fields = append(fields, f)
+		}
+	}
+
+	if err = e.WriteUint8(TagStruct); err != nil {
+		return err
+	}
+	if err = e.WriteUvarint(uint64(len(fields))); err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if err = e.WriteUvarint(uint64(f.number)); err != nil {
+			return err
+		}
+		name := rv.Type().Field(f.index).Name
+		if err = e.writeDescribedBytes(TagString, []byte(name)); err != nil {
+			return err
+		}
+		if err = e.writeDescribed(rv.Field(f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeExt writes the name of the type of rv followed by its Positional encoding by c
+func (e *Encoder) writeExt(c Codec, rv reflect.Value) error {
+	var buffer bytes.Buffer
+	sub := &Encoder{out: &buffer, format: Positional}
+	if err := c.Encode(sub, rv); err != nil {
+		return err
+	}
+	if err := e.WriteUint8(TagExt); err != nil {
+		return err
+	}
+	name := typeName(rv.Type())
+	if err := e.WriteUvarint(uint64(len(name))); err != nil {
+		return err
+	}
+	if err := e.WriteBytes([]byte(name)); err != nil {
+		return err
+	}
+	if err := e.WriteUvarint(uint64(buffer.Len())); err != nil {
+		return err
+	}
+	return e.WriteBytes(buffer.Bytes())
+}
+
+// ============================================================================
+// Decoding
+// ============================================================================
+
+// Decoded values of lists are []interface{}. Maps and structs keep their
+// keys and field numbers until they are assigned or made plain.
+type (
+	describedMap []describedEntry
+
+	describedEntry struct {
+		key   interface{}
+		value interface{}
+	}
+
+	describedStruct []describedField
+
+	describedField struct {
+		number int
+		name   string
+		value  interface{}
+	}
+)
+
+// readDescribed reads a value preceded by its type tag
+func (d *Decoder) readDescribed() (interface{}, error) {
+	tag, err := d.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tag {
+	case TagNil:
+		return nil, nil
+	case TagBool:
+		return d.ReadBool()
+	case TagInt:
+		return d.ReadVarint()
+	case TagUint:
+		return d.ReadUvarint()
+	case TagFloat32:
+		return d.ReadFloat32()
+	case TagFloat64:
+		return d.ReadFloat64()
+	case TagComplex:
+		r, err := d.ReadFloat64()
+		if err != nil {
+			return nil, err
+		}
+		i, err := d.ReadFloat64()
+		return complex(r, i), err
+	case TagString:
+		b, err := d.readDescribedBytes()
+		return string(b), err
+	case TagBytes:
+		b, err := d.readDescribedBytes()
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{}, b...), nil
+	case TagExt:
+		name, err := d.readDescribedBytes()
+		if err != nil {
+			return nil, err
+		}
+		data, err := d.readDescribedBytes()
+		if err != nil {
+			return nil, err
+		}
+		return &Ext{Type: string(name), Data: append([]byte{}, data...)}, nil
+	case TagList, TagMap, TagStruct:
+		return d.readComposite(tag)
+	}
+	return nil, fmt.Errorf("Unknown type tag %v", tag)
+}
+
+// readDescribedBytes reads bytes preceded by their length.
+// The bytes are only valid until the next read.
+func (d *Decoder) readDescribedBytes() ([]byte, error) {
+	l, err := d.ReadUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(maxInt) {
+		return nil, exceeded(ErrMaxBytes, d.limits.MaxBytes)
+	}
+	return d.next(int(l))
+}
+
+// readComposite reads the elements of a list, map or struct
+func (d *Decoder) readComposite(tag byte) (interface{}, error) {
+	n, err := d.ReadUvarint()
+	if err != nil {
+		return nil, err
+	}
+	l, err := d.charge(n)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = d.Enter(); err != nil {
+		return nil, err
+	}
+	defer d.Leave()
+
+	switch tag {
+	case TagList:
+		list := make([]interface{}, 0, d.Capacity(l))
+		for i := 0; i < l; i++ {
+			v, err := d.readDescribed()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case TagMap:
+		m := make(describedMap, 0, d.Capacity(l))
+		for i := 0; i < l; i++ {
+			k, err := d.readDescribed()
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.readDescribed()
+			if err != nil {
+				return nil, err
+			}
+			m = append(m, describedEntry{k, v})
+		}
+		return m, nil
+	default:
+		s := make(describedStruct, 0, d.Capacity(l))
+		for i := 0; i < l; i++ {
+			number, err := d.ReadUvarint()
+			if err != nil {
+				return nil, err
+			}
+			name, err := d.readDescribed()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := name.(string); !ok || number > math.MaxInt32 {
+				return nil, fmt.Errorf("Invalid field %v", number)
+			}
+			v, err := d.readDescribed()
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, describedField{int(number), name.(string), v})
+		}
+		return s, nil
+	}
+}
+
+// plain returns the plain form of a decoded value
+func (d *Decoder) plain(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case []interface{}:
+		for i := range v {
+			p, err := d.plain(v[i])
+			if err != nil {
+				return nil, err
+			}
+			v[i] = p
+		}
+		return v, nil
+	case describedMap:
+		m := make(map[string]interface{}, len(v))
+		for _, entry := range v {
+			k, err := d.plain(entry.key)
+			if err != nil {
+				return nil, err
+			}
+			val, err := d.plain(entry.value)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			m[key] = val
+		}
+		return m, nil
+	case describedStruct:
+		m := make(map[string]interface{}, len(v))
+		for _, f := range v {
+			val, err := d.plain(f.value)
+			if err != nil {
+				return nil, err
+			}
+			m[f.name] = val
+		}
+		return m, nil
+	case *Ext:
+		typesMux.RLock()
+		t, ok := types[v.Type]
+		typesMux.RUnlock()
+		if !ok {
+			return *v, nil
+		}
+		rv := reflect.New(t).Elem()
+		if err := d.assignExt(v, rv); err != nil {
+			return nil, err
+		}
+		return rv.Interface(), nil
+	}
+	return v, nil
+}
+
+// assign assigns a decoded value v to rv. Integers must fit rv, interfaces hold
+// the plain form of v and struct fields are matched by their field number.
+// Fields missing from v are set to their zero value.
+func (d *Decoder) assign(v interface{}, rv reflect.Value) error {
+	t := rv.Type()
+	if ext, ok := v.(*Ext); ok {
+		if _, ok := customCodec(t); ok {
+			return d.assignExt(ext, rv)
+		}
+	}
+
+	if v == nil {
+		rv.Set(reflect.Zero(t))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			rv.Set(reflect.New(t.Elem()))
+		}
+		return d.assign(v, rv.Elem())
+	case reflect.Interface:
+		p, err := d.plain(v)
+		if err != nil {
+			return err
+		}
+		pv := reflect.ValueOf(p)
+		if !pv.Type().AssignableTo(t) {
+			return fmt.Errorf("Type %v is not assignable to %v", pv.Type(), t)
+		}
+		rv.Set(pv)
+		return nil
+	}
+
+	switch v := v.(type) {
+	case bool:
+		if t.Kind() == reflect.Bool {
+			rv.SetBool(v)
+			return nil
+		}
+	case int64:
+		switch {
+		case isSigned(t.Kind()):
+			if rv.OverflowInt(v) {
+				return ErrOverflow
+			}
+			rv.SetInt(v)
+			return nil
+		case isInteger(t.Kind()):
+			if v < 0 || rv.OverflowUint(uint64(v)) {
+				return ErrOverflow
+			}
+			rv.SetUint(uint64(v))
+			return nil
+		}
+	case uint64:
+		switch {
+		case isSigned(t.Kind()):
+			if v > math.MaxInt64 || rv.OverflowInt(int64(v)) {
+				return ErrOverflow
+			}
+			rv.SetInt(int64(v))
+			return nil
+		case isInteger(t.Kind()):
+			if rv.OverflowUint(v) {
+				return ErrOverflow
+			}
+			rv.SetUint(v)
+			return nil
+		}
+	case float32:
+		if t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64 {
+			rv.SetFloat(float64(v))
+			return nil
+		}
+	case float64:
+		if t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64 {
+			rv.SetFloat(v)
+			return nil
+		}
+	case complex128:
+		if t.Kind() == reflect.Complex64 || t.Kind() == reflect.Complex128 {
+			rv.SetComplex(v)
+			return nil
+		}
+	case string:
+		if t.Kind() == reflect.String {
+			rv.SetString(v)
+			return nil
+		}
+	case []byte:
+		if t.Kind() == reflect.Slice && isBytes(t) {
+			rv.SetBytes(v)
+			return nil
+		}
+		if t.Kind() == reflect.Array && isBytes(t) && len(v) == rv.Len() {
+			reflect.Copy(rv, reflect.ValueOf(v))
+			return nil
+		}
+	case []interface{}:
+		return d.assignList(v, rv)
+	case describedMap:
+		if t.Kind() == reflect.Map {
+			return d.assignMap(v, rv)
+		}
+	case describedStruct:
+		if t.Kind() == reflect.Struct {
+			return d.assignStruct(v, rv)
+		}
+	}
+	return fmt.Errorf("Decoded %v is not assignable to %v", describedKind(v), t)
+}
+
+// describedKind returns the kind of a decoded value for errors
+func describedKind(v interface{}) string {
+	switch v.(type) {
+	case describedMap:
+		return "map"
+	case describedStruct:
+		return "struct"
+	case []interface{}:
+		return "list"
+	case *Ext:
+		return "ext " + v.(*Ext).Type
+	}
+	return reflect.TypeOf(v).String()
+}
+
+func (d *Decoder) assignList(v []interface{}, rv reflect.Value) error {
+	t := rv.Type()
+	switch t.Kind() {
+	case reflect.Slice:
+		s := reflect.MakeSlice(t, len(v), len(v))
+		for i := range v {
+			if err := d.assign(v[i], s.Index(i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(s)
+		return nil
+	case reflect.Array:
+		if len(v) != rv.Len() {
+			return fmt.Errorf("Decoded list of %v elements is not assignable to %v", len(v), t)
+		}
+		for i := range v {
+			if err := d.assign(v[i], rv.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("Decoded list is not assignable to %v", t)
+}
+
+func (d *Decoder) assignMap(v describedMap, rv reflect.Value) error {
+	t := rv.Type()
+	m := reflect.MakeMapWithSize(t, len(v))
+	for _, entry := range v {
+		k := reflect.New(t.Key()).Elem()
+		if err := d.assign(entry.key, k); err != nil {
+			return err
+		}
+		val := reflect.New(t.Elem()).Elem()
+		if err := d.assign(entry.value, val); err != nil {
+			return err
+		}
+		m.SetMapIndex(k, val)
+	}
+	rv.Set(m)
+	return nil
+}
+
+// assignStruct assigns fields by their field number. Unknown fields are skipped.
+func (d *Decoder) assignStruct(v describedStruct, rv reflect.Value) error {
+	s, err := describedFields(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	seen := make([]bool, len(s.fields))
+	for _, f := range v {
+		i, ok := s.numbers[f.number]
+		if !ok {
+			continue
+		}
+		if err = d.assign(f.value, rv.Field(s.fields[i].index)); err != nil {
+			return fmt.Errorf("%v.%v: %w", rv.Type(), f.name, err)
+		}
+		seen[i] = true
+	}
+	for i, f := range s.fields {
+		if !seen[i] {
+			field := rv.Field(f.index)
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+	return nil
+}
+
+// assignExt decodes the data of ext into rv with the codec of its type
+func (d *Decoder) assignExt(ext *Ext, rv reflect.Value) error {
+	if name := typeName(rv.Type()); ext.Type != name {
+		return fmt.Errorf("Type %v is not assignable to %v", ext.Type, name)
+	}
+	c, err := getCodec(rv.Type())
+	if err != nil {
+		return err
+	}
+	sub := d.sub(ext.Data)
+	sub.format = Positional
+	sub.compact = false
+	return c.Decode(sub, rv)
+}
+
+// unmarshalDescribed decodes a versioned self-describing value into the value rv points to
+func (d *Decoder) unmarshalDescribed(rv reflect.Value) error {
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("Unmarshal: Decoding requires a non-nil pointer")
+	}
+	if err := d.readDescribedVersion(); err != nil {
+		return err
+	}
+	v, err := d.readDescribed()
+	if err != nil {
+		return err
+	}
+	return d.assign(v, rv.Elem())
+}
@@ -1,6 +1,7 @@
 package encoding
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
@@ -9,13 +10,24 @@ import (
 	"reflect"
 )
 
+// Encoder encodes values to a byte stream
 type Encoder struct {
-	out io.Writer
+	out    io.Writer
+	format Format
+
+	// Write integers as varints and lengths as uvarints
+	compact bool
+
+	// Destination of values encoded by Encode. Nil for Marshal
+	w io.Writer
+
+	// Scratch buffer of fixed size values
+	scratch [8]byte
 }
 
 // Marshal encodes the value v into a byte slice stream.
-func Marshal(v interface{}) ([]byte, error) {
-	e := newEncoder()
+func Marshal(v interface{}, opts ...Option) ([]byte, error) {
+	e := newEncoder(newOptions(opts))
 	err := e.marshal(v)
 
 	if err != nil {
@@ -30,120 +42,214 @@ func Marshal(v interface{}) ([]byte, error) {
 }
 
 // newEncoder creates a new encoder.
-func newEncoder() *Encoder {
+func newEncoder(o options) *Encoder {
 	var buffer bytes.Buffer
 	buffer.Grow(64)
 
 	return &Encoder{
-		out: &buffer,
+		out:     &buffer,
+		format:  o.format,
+		compact: o.compact,
+	}
+}
+
+// encoderBufferSize is the size of the buffer of an Encoder writing to an io.Writer
+const encoderBufferSize = 4096
+
+// NewEncoder returns an encoder writing a sequence of values to w.
+// Values are written to w through a buffer of 4KB as they are encoded, hence a
+// value fitting the buffer is written with a single Write and sent as one message
+// of a protocol stream, while larger values are written with several.
+func NewEncoder(w io.Writer, opts ...Option) *Encoder {
+	o := newOptions(opts)
+	return &Encoder{
+		out:     bufio.NewWriterSize(w, encoderBufferSize),
+		format:  o.format,
+		compact: o.compact,
+		w:       w,
+	}
+}
+
+// Encode writes the encoding of v to the writer of the encoder.
+// If v fails to encode, the part of it still buffered is discarded. Bytes of v
+// already written to the writer are not.
+func (e *Encoder) Encode(v interface{}) error {
+	if e.w == nil {
+		return errors.New("Encode: Encoder has no writer")
+	}
+
+	buffer := e.out.(*bufio.Writer)
+	if err := e.marshal(v); err != nil {
+		buffer.Reset(e.w)
+		return err
 	}
+	return buffer.Flush()
 }
 
-// marshal encodes the value v into the buffer.
+// marshal encodes the value v into the output of the encoder.
 func (e *Encoder) marshal(v interface{}) error {
+	if e.format == SelfDescribing {
+		if err := e.WriteUint8(DescribedVersion); err != nil {
+			return err
+		}
+		return e.writeDescribed(reflect.ValueOf(v))
+	}
+
 	c, err := GetCodec(v)
 	if err != nil {
 		return err
 	}
 
+	if e.format == TLV {
+		if err = e.WriteUint8(TLVVersion); err != nil {
+			return err
+		}
+	}
+
 	// rv := reflect.Indirect(reflect.ValueOf(v))
 	return c.Encode(e, reflect.ValueOf(v))
 }
 
-// write writes the contents of p into the buffer.
+// write writes the contents of p into the output of the encoder.
 func (e *Encoder) write(p []byte) error {
 	_, err := e.out.Write(p)
 	return err
 }
 
-// writeBool writes a single boolean value into the buffer
-func (e *Encoder) writeBool(v bool) error {
-	return binary.Write(e.out, binary.LittleEndian, v)
+// WriteBool writes a single boolean value into the buffer
+func (e *Encoder) WriteBool(v bool) error {
+	if v {
+		return e.WriteUint8(1)
+	}
+	return e.WriteUint8(0)
 }
 
-// writeString writes a string prefixed with the int size.
-func (e *Encoder) writeString(v string) error {
+// WriteString writes a string prefixed with the int size.
+// The size is a uvarint in compact mode.
+func (e *Encoder) WriteString(v string) error {
 	// Write the size of the string
-	err := e.writeInt32(int32(len(v)))
+	var err error
+	if e.compact {
+		err = e.WriteUvarint(uint64(len(v)))
+	} else {
+		err = e.WriteFixed32(uint32(len(v)))
+	}
 	if err != nil {
 		return err
 	}
-	return binary.Write(e.out, binary.LittleEndian, []byte(v))
+	_, err = io.WriteString(e.out, v)
+	return err
 }
 
-// writeInt writes a 32 bit integer
-func (e *Encoder) writeInt(n int) error {
-	err := binary.Write(e.out, binary.LittleEndian, n)
-	if err != nil {
-		return err
+// WriteInt writes an int as a 64 bit integer
+func (e *Encoder) WriteInt(n int) error {
+	return e.WriteInt64(int64(n))
+}
+
+// WriteUint writes a uint as a 64 bit integer
+func (e *Encoder) WriteUint(n uint) error {
+	return e.WriteUint64(uint64(n))
+}
+
+// WriteInt8 writes a 8 bit integer
+func (e *Encoder) WriteInt8(n int8) error {
+	return e.WriteUint8(uint8(n))
+}
+
+// WriteInt16 writes a 16 bit integer. Zigzag varint in compact mode
+func (e *Encoder) WriteInt16(n int16) error {
+	if e.compact {
+		return e.WriteVarint(int64(n))
 	}
-	return nil
+	return e.WriteFixed16(uint16(n))
 }
 
-// writeInt32 writes a 32 bit integer
-func (e *Encoder) writeInt32(n int32) error {
-	err := binary.Write(e.out, binary.LittleEndian, n)
-	if err != nil {
-		return err
+// WriteInt32 writes a 32 bit integer. Zigzag varint in compact mode
+func (e *Encoder) WriteInt32(n int32) error {
+	if e.compact {
+		return e.WriteVarint(int64(n))
 	}
-	return nil
+	return e.WriteFixed32(uint32(n))
 }
 
-// writeInt64 writes a 64 bit integer
-func (e *Encoder) writeInt64(n int64) error {
-	err := binary.Write(e.out, binary.LittleEndian, n)
-	if err != nil {
-		return err
+// WriteInt64 writes a 64 bit integer. Zigzag varint in compact mode
+func (e *Encoder) WriteInt64(n int64) error {
+	if e.compact {
+		return e.WriteVarint(n)
 	}
-	return nil
+	return e.WriteFixed64(uint64(n))
 }
 
-// writeInt32 writes a 8 bit integer
-func (e *Encoder) writeUInt8(n uint8) error {
-	err := binary.Write(e.out, binary.LittleEndian, n)
-	if err != nil {
-		return err
+// WriteUint8 writes a 8 bit integer
+func (e *Encoder) WriteUint8(n uint8) error {
+	e.scratch[0] = n
+	return e.write(e.scratch[:1])
+}
+
+// WriteUint16 writes a 16 bit integer. Uvarint in compact mode
+func (e *Encoder) WriteUint16(n uint16) error {
+	if e.compact {
+		return e.WriteUvarint(uint64(n))
 	}
-	return nil
+	return e.WriteFixed16(n)
 }
 
-// writeUint32 writes a 32 bit integer
-func (e *Encoder) writeUint32(n uint32) error {
-	err := binary.Write(e.out, binary.LittleEndian, n)
-	if err != nil {
-		return err
+// WriteUint32 writes a 32 bit integer. Uvarint in compact mode
+func (e *Encoder) WriteUint32(n uint32) error {
+	if e.compact {
+		return e.WriteUvarint(uint64(n))
 	}
-	return nil
+	return e.WriteFixed32(n)
 }
 
-// writeUint64 writes a 64 bit integer
-func (e *Encoder) writeUint64(n uint64) error {
-	err := binary.Write(e.out, binary.LittleEndian, n)
-	if err != nil {
-		return err
+// WriteUint64 writes a 64 bit integer. Uvarint in compact mode
+func (e *Encoder) WriteUint64(n uint64) error {
+	if e.compact {
+		return e.WriteUvarint(n)
 	}
-	return nil
+	return e.WriteFixed64(n)
+}
+
+// WriteFixed16 writes a 16 bit integer with fixed width regardless of mode
+func (e *Encoder) WriteFixed16(n uint16) error {
+	binary.LittleEndian.PutUint16(e.scratch[:2], n)
+	return e.write(e.scratch[:2])
+}
+
+// WriteFixed32 writes a 32 bit integer with fixed width regardless of mode
+func (e *Encoder) WriteFixed32(n uint32) error {
+	binary.LittleEndian.PutUint32(e.scratch[:4], n)
+	return e.write(e.scratch[:4])
+}
+
+// WriteFixed64 writes a 64 bit integer with fixed width regardless of mode
+func (e *Encoder) WriteFixed64(n uint64) error {
+	binary.LittleEndian.PutUint64(e.scratch[:8], n)
+	return e.write(e.scratch[:8])
+}
+
+// WriteUvarint writes an unsigned integer in 1 to 10 bytes, 7 bits per byte
+func (e *Encoder) WriteUvarint(n uint64) error {
+	var b [binary.MaxVarintLen64]byte
+	return e.write(b[:binary.PutUvarint(b[:], n)])
+}
+
+// WriteVarint writes a zigzag encoded signed integer as a uvarint
+func (e *Encoder) WriteVarint(n int64) error {
+	return e.WriteUvarint(uint64(n<<1) ^ uint64(n>>63))
 }
 
-// writeFloat32 serializes float32. IEEE 754 standard. Assumes float is a finite number
-func (e *Encoder) writeFloat32(f float32) error {
-	bits := math.Float32bits(f)
-	// We only need 4 bytes for 32
-	buf := make([]byte, 4)
-	binary.LittleEndian.PutUint32(buf, bits)
-	return binary.Write(e.out, binary.LittleEndian, buf)
+// WriteFloat32 serializes float32. IEEE 754 standard. Assumes float is a finite number
+func (e *Encoder) WriteFloat32(f float32) error {
+	return e.WriteFixed32(math.Float32bits(f))
 }
 
-// writeFloat64 serializes float64 or double. IEEE 754 standard. Assumes float is a finite number
-func (e *Encoder) writeFloat64(f float64) error {
-	bits := math.Float64bits(f)
-	// We only need 4 bytes for 32
-	buf := make([]byte, 4)
-	binary.LittleEndian.PutUint64(buf, bits)
-	return binary.Write(e.out, binary.LittleEndian, buf)
+// WriteFloat64 serializes float64 or double. IEEE 754 standard. Assumes float is a finite number
+func (e *Encoder) WriteFloat64(f float64) error {
+	return e.WriteFixed64(math.Float64bits(f))
 }
 
-// writeBytes writes a byte slice
-func (e *Encoder) writeBytes(b []byte) error {
-	return binary.Write(e.out, binary.LittleEndian, b)
+// WriteBytes writes a byte slice without a length prefix
+func (e *Encoder) WriteBytes(b []byte) error {
+	return e.write(b)
 }
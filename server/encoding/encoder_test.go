@@ -0,0 +1,145 @@
+package encoding
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+type streamValue struct {
+	ID    int32
+	Name  string
+	Seats []int16
+}
+
+// TestStream verifies values written to one stream are read back in order
+// with io.EOF between values and truncated values reported
+func TestStream(t *testing.T) {
+	for _, opts := range [][]Option{
+		nil,
+		{WithCompact(true)},
+		{WithFormat(TLV)},
+		{WithFormat(TLV), WithCompact(true)},
+	} {
+		values := []*streamValue{
+			{ID: 1, Name: "Phoenix", Seats: []int16{1, 2}},
+			{ID: -2, Name: ""},
+			{ID: 3, Name: "San Antonio", Seats: []int16{-7}},
+		}
+
+		var buf bytes.Buffer
+		e := NewEncoder(&buf, opts...)
+		sizes := []int{}
+		for _, v := range values {
+			before := buf.Len()
+			if err := e.Encode(v); err != nil {
+				t.Fatal(err)
+			}
+			sizes = append(sizes, buf.Len()-before)
+		}
+		if err := e.Encode([]string{"Steak", "Wine"}); err != nil {
+			t.Fatal(err)
+		}
+		stream := buf.Bytes()
+
+		d := NewDecoder(bytes.NewReader(stream), opts...)
+		for _, want := range values {
+			got := new(streamValue)
+			if err := d.Decode(got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %+v, want %+v", got, want)
+			}
+		}
+		var meals []string
+		if err := d.Decode(&meals); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(meals, []string{"Steak", "Wine"}) {
+			t.Fatalf("got %v", meals)
+		}
+		if err := d.Decode(new(streamValue)); err != io.EOF {
+			t.Fatalf("got %v, want io.EOF between values", err)
+		}
+
+		// The stream ends within the second value
+		d = NewDecoder(bytes.NewReader(stream[:sizes[0]+sizes[1]-1]), opts...)
+		if err := d.Decode(new(streamValue)); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Decode(new(streamValue)); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("got %v, want io.ErrUnexpectedEOF of a truncated value", err)
+		}
+	}
+}
+
+// writes records the size of every Write
+type writes struct {
+	bytes.Buffer
+	sizes []int
+}
+
+func (w *writes) Write(p []byte) (int, error) {
+	w.sizes = append(w.sizes, len(p))
+	return w.Buffer.Write(p)
+}
+
+// TestStreamWrites verifies values are written as they are encoded, small
+// values with a single Write, and values failing to encode are discarded
+func TestStreamWrites(t *testing.T) {
+	w := new(writes)
+	e := NewEncoder(w)
+	if err := e.Encode(&streamValue{ID: 1, Name: "Phoenix"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.sizes) != 1 {
+		t.Fatalf("got %v writes of a small value", len(w.sizes))
+	}
+
+	large := &streamValue{ID: 2, Seats: make([]int16, 3*encoderBufferSize)}
+	if err := e.Encode(large); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range w.sizes[1:] {
+		if n > encoderBufferSize {
+			t.Fatalf("got a write of %v bytes, want at most %v", n, encoderBufferSize)
+		}
+	}
+	if len(w.sizes) < 3 {
+		t.Fatalf("got %v writes of a large value", len(w.sizes)-1)
+	}
+
+	// The buffered part of a value failing to encode is not written
+	written := w.Len()
+	if err := e.Encode([]interface{}{int32(3), struct{}{}}); err == nil {
+		t.Fatal("Encode of an unregistered type succeeded")
+	}
+	if err := e.Encode(&streamValue{ID: 4}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Len()-written != len(mustMarshal(t, &streamValue{ID: 4})) {
+		t.Fatalf("got %v bytes written", w.Len()-written)
+	}
+
+	d := NewDecoder(bytes.NewReader(w.Bytes()))
+	for _, want := range []*streamValue{{ID: 1, Name: "Phoenix"}, large, {ID: 4}} {
+		got := new(streamValue)
+		if err := d.Decode(got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != want.ID || got.Name != want.Name || len(got.Seats) != len(want.Seats) {
+			t.Fatalf("got %v, want %v", got.ID, want.ID)
+		}
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
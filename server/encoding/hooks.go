@@ -0,0 +1,202 @@
+package encoding
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CodecProvider is implemented by types providing the codec encoding them.
+// CZCodec is called on the zero value of the type once, when its codec is compiled.
+type CodecProvider interface {
+	CZCodec() Codec
+}
+
+// binaryMarshaler mirrors encoding.BinaryMarshaler of the standard library
+type binaryMarshaler interface {
+	MarshalBinary() ([]byte, error)
+}
+
+// binaryUnmarshaler mirrors encoding.BinaryUnmarshaler of the standard library
+type binaryUnmarshaler interface {
+	UnmarshalBinary(data []byte) error
+}
+
+var (
+	providerType          = reflect.TypeOf((*CodecProvider)(nil)).Elem()
+	binaryMarshalerType   = reflect.TypeOf((*binaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*binaryUnmarshaler)(nil)).Elem()
+)
+
+// registered holds codecs registered by type
+var registered sync.Map
+
+func init() {
+	RegisterCodec(time.Time{}, new(timeCodec))
+	RegisterCodec(time.Duration(0), new(durationCodec))
+	RegisterCodec(uuid.UUID{}, new(uuidCodec))
+
+	// Self-describing values of built-in codecs decode to their type
+	RegisterType(time.Time{})
+	RegisterType(time.Duration(0))
+	RegisterType(uuid.UUID{})
+}
+
+// RegisterCodec registers c as the codec of the type of v.
+// Registered codecs take priority over every other codec of the type.
+func RegisterCodec(v interface{}, c Codec) {
+	compileMux.Lock()
+	defer compileMux.Unlock()
+
+	registered.Store(reflect.TypeOf(v), c)
+	// Drop compiled codecs which may refer to the previous codec of the type
+	codecs.Range(func(k, _ interface{}) bool {
+		codecs.Delete(k)
+		return true
+	})
+}
+
+// customCodec returns the codec of t chosen by t rather than by its kind. In order of priority:
+//  1. The codec registered with RegisterCodec
+//  2. The codec returned by CodecProvider
+//  3. encoding.BinaryMarshaler and encoding.BinaryUnmarshaler, unless t implements Marshaler
+func customCodec(t reflect.Type) (Codec, bool) {
+	if c, ok := registered.Load(t); ok {
+		return c.(Codec), true
+	}
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return nil, false
+	}
+
+	pt := reflect.PtrTo(t)
+	if pt.Implements(providerType) {
+		return reflect.New(t).Interface().(CodecProvider).CZCodec(), true
+	}
+	// Marshalers generated by czgen implement BinaryMarshaler by calling Marshal
+	if pt.Implements(marshalerType) && pt.Implements(unmarshalerType) {
+		return nil, false
+	}
+	if pt.Implements(binaryMarshalerType) && pt.Implements(binaryUnmarshalerType) {
+		return new(binaryCodec), true
+	}
+	return nil, false
+}
+
+// ============================================================================
+// Binary Codec
+// ============================================================================
+
+// binaryCodec encodes types implementing encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler as bytes prefixed with their length
+type binaryCodec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *binaryCodec) Encode(e *Encoder, rv reflect.Value) error {
+	if !rv.Type().Implements(binaryMarshalerType) {
+		if !rv.CanAddr() {
+			// Copy to call pointer receivers
+			ptr := reflect.New(rv.Type())
+			ptr.Elem().Set(rv)
+			rv = ptr.Elem()
+		}
+		rv = rv.Addr()
+	}
+	b, err := rv.Interface().(binaryMarshaler).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if err = e.WriteUint64(uint64(len(b))); err != nil {
+		return err
+	}
+	return e.WriteBytes(b)
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *binaryCodec) Decode(d *Decoder, rv reflect.Value) error {
+	l, err := d.ReadLength()
+	if err != nil {
+		return err
+	}
+	b, err := d.ReadBytes(l)
+	if err != nil {
+		return err
+	}
+	return rv.Addr().Interface().(binaryUnmarshaler).UnmarshalBinary(b)
+}
+
+// ============================================================================
+// Built-in Codecs
+// ============================================================================
+
+// timeCodec encodes time.Time as seconds and nanoseconds since the Unix epoch.
+// Times decode in UTC.
+type timeCodec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *timeCodec) Encode(e *Encoder, rv reflect.Value) error {
+	t := rv.Interface().(time.Time)
+	if err := e.WriteInt64(t.Unix()); err != nil {
+		return err
+	}
+	return e.WriteInt32(int32(t.Nanosecond()))
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *timeCodec) Decode(d *Decoder, rv reflect.Value) error {
+	sec, err := d.ReadInt64()
+	if err != nil {
+		return err
+	}
+	nsec, err := d.ReadInt32()
+	if err != nil {
+		return err
+	}
+	t := time.Unix(sec, int64(nsec)).UTC()
+	// Preserve the zero time
+	if t.IsZero() {
+		t = time.Time{}
+	}
+	rv.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// durationCodec encodes time.Duration as nanoseconds
+type durationCodec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *durationCodec) Encode(e *Encoder, rv reflect.Value) error {
+	return e.WriteInt64(rv.Int())
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *durationCodec) Decode(d *Decoder, rv reflect.Value) error {
+	n, err := d.ReadInt64()
+	if err != nil {
+		return err
+	}
+	rv.SetInt(n)
+	return nil
+}
+
+// uuidCodec encodes uuid.UUID as its 16 bytes
+type uuidCodec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *uuidCodec) Encode(e *Encoder, rv reflect.Value) error {
+	u := rv.Interface().(uuid.UUID)
+	return e.WriteBytes(u[:])
+}
+
+// Decode decodes into a reflect value from the decoder.
+func (c *uuidCodec) Decode(d *Decoder, rv reflect.Value) error {
+	b, err := d.next(len(uuid.UUID{}))
+	if err != nil {
+		return err
+	}
+	var u uuid.UUID
+	copy(u[:], b)
+	rv.Set(reflect.ValueOf(u))
+	return nil
+}
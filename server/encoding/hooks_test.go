@@ -0,0 +1,153 @@
+package encoding
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type stamped struct {
+	At      time.Time
+	Zero    time.Time
+	Timeout time.Duration
+	ID      uuid.UUID
+}
+
+// celsius provides a codec encoding it as a single byte
+type celsius int
+
+func (celsius) CZCodec() Codec { return new(byteCodec) }
+
+type byteCodec struct{}
+
+func (byteCodec) Encode(e *Encoder, rv reflect.Value) error { return e.WriteUint8(uint8(rv.Int())) }
+
+func (byteCodec) Decode(d *Decoder, rv reflect.Value) error {
+	n, err := d.ReadUint8()
+	rv.SetInt(int64(n))
+	return err
+}
+
+// point implements encoding.BinaryMarshaler and encoding.BinaryUnmarshaler
+type point struct{ x, y byte }
+
+func (p point) MarshalBinary() ([]byte, error) { return []byte{p.x, p.y}, nil }
+
+func (p *point) UnmarshalBinary(b []byte) error {
+	if len(b) != 2 {
+		return errors.New("Invalid point")
+	}
+	p.x, p.y = b[0], b[1]
+	return nil
+}
+
+// TestBuiltinCodecs verifies times, durations and uuids round trip with their codecs
+func TestBuiltinCodecs(t *testing.T) {
+	want := &stamped{
+		At:      time.Date(2023, 3, 1, 12, 30, 0, 500, time.FixedZone("SGT", 8*60*60)),
+		Timeout: 1500 * time.Millisecond,
+		ID:      uuid.New(),
+	}
+	for _, opts := range [][]Option{nil, {WithFormat(TLV)}, {WithCompact(true)}} {
+		b, err := Marshal(want, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := new(stamped)
+		if err = Unmarshal(b, got, opts...); err != nil {
+			t.Fatal(err)
+		}
+		// Times decode in UTC and the zero time is preserved
+		if !got.At.Equal(want.At) || got.At.Location() != time.UTC || !got.Zero.IsZero() {
+			t.Fatalf("got %v and %v, want %v", got.At, got.Zero, want.At)
+		}
+		if got.Timeout != want.Timeout || got.ID != want.ID {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	}
+
+	// uuids are encoded as their 16 bytes
+	b, err := Marshal(want.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, want.ID[:]) {
+		t.Fatalf("got %x, want %x", b, want.ID[:])
+	}
+}
+
+// TestCodecHooks verifies types providing codecs and binary marshalers are
+// encoded by them and registered codecs take priority
+func TestCodecHooks(t *testing.T) {
+	c := celsius(21)
+	b, err := Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{21}) {
+		t.Fatalf("got %x, want 15", b)
+	}
+	if b, err = Marshal(&c); err != nil {
+		t.Fatal(err)
+	}
+	c = 0
+	if err = Unmarshal(b, &c); err != nil || c != 21 {
+		t.Fatalf("got %v, %v", c, err)
+	}
+
+	p := point{x: 1, y: 2}
+	if b, err = Marshal(&p); err != nil {
+		t.Fatal(err)
+	}
+	got := point{}
+	if err = Unmarshal(b, &got); err != nil || got != p {
+		t.Fatalf("got %+v, %v, want %+v", got, err, p)
+	}
+	if err = Unmarshal(b[:len(b)-1], &got); err == nil {
+		t.Fatal("Unmarshal of a truncated point succeeded")
+	}
+
+	// A registered codec replaces the binary marshaler of a type
+	RegisterCodec(cell{}, new(cellCodec))
+	if b, err = Marshal(cell{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, []byte{2, 1}) {
+		t.Fatalf("got %x, want 0201", b)
+	}
+	if b, err = Marshal(&cell{X: 1, Y: 2}); err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(cell)
+	if err = Unmarshal(b, decoded); err != nil || *decoded != (cell{X: 1, Y: 2}) {
+		t.Fatalf("got %+v, %v", decoded, err)
+	}
+}
+
+// cell implements encoding.BinaryMarshaler and has a registered codec
+type cell struct{ X, Y byte }
+
+func (c cell) MarshalBinary() ([]byte, error) { return []byte{c.X, c.Y}, nil }
+
+func (c *cell) UnmarshalBinary(b []byte) error { return errors.New("Unexpected binary unmarshal") }
+
+// cellCodec encodes the coordinates of a cell in reverse
+type cellCodec struct{}
+
+func (cellCodec) Encode(e *Encoder, rv reflect.Value) error {
+	c := rv.Interface().(cell)
+	return e.WriteBytes([]byte{c.Y, c.X})
+}
+
+func (cellCodec) Decode(d *Decoder, rv reflect.Value) error {
+	b, err := d.next(2)
+	if err != nil {
+		return err
+	}
+	rv.Set(reflect.ValueOf(cell{X: b[1], Y: b[0]}))
+	return nil
+}
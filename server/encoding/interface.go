@@ -0,0 +1,127 @@
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Interface values are encoded with the registered name of their concrete type.
+// Both peers must register the same types by the same names.
+var (
+	typesMux sync.RWMutex
+	types    = make(map[string]reflect.Type)
+	names    = make(map[reflect.Type]string)
+)
+
+func init() {
+	for _, v := range []interface{}{
+		false, "", []byte(nil),
+		int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0), uintptr(0),
+		float32(0), float64(0), complex64(0), complex128(0),
+	} {
+		RegisterType(v)
+	}
+}
+
+// RegisterType registers the concrete type of v by its name e.g. "rpc.Flight"
+// so it may be encoded as the value of an interface.
+func RegisterType(v interface{}) {
+	RegisterTypeName(reflect.TypeOf(v).String(), v)
+}
+
+// RegisterTypeName registers the concrete type of v by name
+// so it may be encoded as the value of an interface.
+func RegisterTypeName(name string, v interface{}) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		panic("encoding: RegisterTypeName of nil")
+	}
+
+	typesMux.Lock()
+	defer typesMux.Unlock()
+	if prev, ok := types[name]; ok && prev != t {
+		panic(fmt.Sprintf("encoding: RegisterTypeName of %v as %q registered by %v", t, name, prev))
+	}
+	types[name] = t
+	names[t] = name
+}
+
+// ============================================================================
+// Interface Codec
+// ============================================================================
+
+// interfaceCodec prefixes the value of an interface with a nil flag
+// and the registered name of its concrete type
+type interfaceCodec struct{}
+
+// Encode encodes a value into the encoder.
+func (c *interfaceCodec) Encode(e *Encoder, rv reflect.Value) error {
+	if rv.IsNil() {
+		return e.WriteBool(true)
+	}
+
+	v := rv.Elem()
+	typesMux.RLock()
+	name, ok := names[v.Type()]
+	typesMux.RUnlock()
+	if !ok {
+		return fmt.Errorf("Unregistered type %v", v.Type())
+	}
+
+	codec, err := getCodec(v.Type())
+	if err != nil {
+		return err
+	}
+	if err = e.WriteBool(false); err != nil {
+		return err
+	}
+	if err = e.WriteString(name); err != nil {
+		return err
+	}
+	return codec.Encode(e, v)
+}
+
+// Decode decodes into a reflect value from the decoder.
+// A nil interface sets the value to nil.
+func (c *interfaceCodec) Decode(d *Decoder, rv reflect.Value) error {
+	isNil, err := d.ReadBool()
+	if err != nil {
+		return err
+	}
+	if isNil {
+		rv.Set(reflect.Zero(rv.Type()))
+		return nil
+	}
+
+	if err = d.Enter(); err != nil {
+		return err
+	}
+	defer d.Leave()
+
+	name, err := d.ReadString()
+	if err != nil {
+		return err
+	}
+	typesMux.RLock()
+	t, ok := types[name]
+	typesMux.RUnlock()
+	if !ok {
+		return fmt.Errorf("Unregistered type %q", name)
+	}
+	if !t.AssignableTo(rv.Type()) {
+		return fmt.Errorf("Type %v is not assignable to %v", t, rv.Type())
+	}
+
+	codec, err := getCodec(t)
+	if err != nil {
+		return err
+	}
+	v := reflect.New(t).Elem()
+	if err = codec.Decode(d, v); err != nil {
+		return err
+	}
+	rv.Set(v)
+	return nil
+}
@@ -0,0 +1,94 @@
+package encoding
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type shape interface {
+	Area() int
+}
+
+type square struct{ Side int32 }
+
+func (s square) Area() int { return int(s.Side * s.Side) }
+
+type label string
+
+type anyHolder struct {
+	V interface{}
+}
+
+type shapeHolder struct {
+	V shape
+}
+
+func init() {
+	RegisterTypeName("encoding.square", square{})
+	RegisterType(label(""))
+}
+
+// TestInterfaceTypes verifies values of interfaces decode to their registered
+// concrete types and unregistered or unassignable types are rejected
+func TestInterfaceTypes(t *testing.T) {
+	values := []interface{}{
+		nil, true, "seat", []byte{1, 2}, int(-1), int8(-2), int16(-3), int32(-4), int64(-5),
+		uint(1), uint8(2), uint16(3), uint32(4), uint64(5), uintptr(6),
+		float32(1.5), float64(2.5), complex64(1 + 2i), complex128(3 + 4i),
+		square{Side: 3}, label("window"),
+	}
+	for _, opts := range [][]Option{nil, {WithFormat(TLV)}, {WithCompact(true)}} {
+		for _, v := range values {
+			b, err := Marshal(&anyHolder{V: v}, opts...)
+			if err != nil {
+				t.Fatalf("%T: %v", v, err)
+			}
+			got := &anyHolder{V: "stale"}
+			if err = Unmarshal(b, got, opts...); err != nil {
+				t.Fatalf("%T: %v", v, err)
+			}
+			if !reflect.DeepEqual(got.V, v) {
+				t.Fatalf("got %#v, want %#v", got.V, v)
+			}
+		}
+	}
+
+	b, err := Marshal(&shapeHolder{V: square{Side: 4}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(shapeHolder)
+	if err = Unmarshal(b, got); err != nil || got.V.Area() != 16 {
+		t.Fatalf("got %#v, %v", got.V, err)
+	}
+
+	type unregistered struct{}
+	if _, err = Marshal(&anyHolder{V: unregistered{}}); err == nil {
+		t.Fatal("Marshal of an unregistered type succeeded")
+	}
+	if b, err = Marshal(&anyHolder{V: label("window")}); err != nil {
+		t.Fatal(err)
+	}
+	if err = Unmarshal(b, new(shapeHolder)); err == nil {
+		t.Fatal("Unmarshal of a type not implementing the interface succeeded")
+	}
+	// Names unknown to the decoder are rejected
+	b = bytes.Replace(b, []byte("encoding.label"), []byte("encoding.lapel"), 1)
+	if err = Unmarshal(b, new(anyHolder)); err == nil {
+		t.Fatal("Unmarshal of an unregistered name succeeded")
+	}
+}
+
+// TestRegisterTypeName verifies a name may not be registered by different types
+func TestRegisterTypeName(t *testing.T) {
+	// Registering a type again by its name is allowed
+	RegisterTypeName("encoding.square", square{})
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("RegisterTypeName of a registered name succeeded")
+		}
+	}()
+	RegisterTypeName("encoding.square", label(""))
+}
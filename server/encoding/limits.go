@@ -0,0 +1,89 @@
+package encoding
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Limits bounds the resources decoding a value may use, hence a malformed or
+// malicious input can't exhaust memory or the stack with forged lengths.
+// A limit of zero is unlimited.
+type Limits struct {
+	// Bytes read per value
+	MaxBytes int
+	// Elements of slices and maps per value, summed over nested values
+	MaxElements int
+	// Nesting of pointers, structs, slices, arrays, maps and interfaces
+	MaxDepth int
+}
+
+// DefaultLimits are the limits of decoders created without WithLimits
+var DefaultLimits = Limits{
+	MaxBytes:    64 << 20,
+	MaxElements: 1 << 20,
+	MaxDepth:    100,
+}
+
+var (
+	ErrMaxBytes    = errors.New("Decoding exceeds max bytes")
+	ErrMaxElements = errors.New("Decoding exceeds max elements")
+	ErrMaxDepth    = errors.New("Decoding exceeds max depth")
+)
+
+// LimitError is returned when decoding a value exceeds one of its Limits.
+// errors.Is matches it with ErrMaxBytes, ErrMaxElements or ErrMaxDepth.
+type LimitError struct {
+	Err error // The exceeded limit
+	Max int   // The value of the limit
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v of %d", e.Err, e.Max)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+const maxInt = int(^uint(0) >> 1)
+
+// exceeded returns the LimitError of err. Unlimited limits are reported with the
+// maximum int, exceeded by lengths that don't fit an int.
+func exceeded(err error, max int) error {
+	if max <= 0 {
+		max = maxInt
+	}
+	return &LimitError{Err: err, Max: max}
+}
+
+// usage of the limits by the value being decoded.
+// Shared with the decoders of TLV payloads of the value.
+type usage struct {
+	elements int
+	depth    int
+}
+
+// Enter enters a nested value. An error is returned if the value is nested
+// deeper than the max depth. Every successful Enter must be paired with a Leave.
+func (d *Decoder) Enter() error {
+	if max := d.limits.MaxDepth; max > 0 && d.usage.depth >= max {
+		return exceeded(ErrMaxDepth, max)
+	}
+	d.usage.depth++
+	return nil
+}
+
+// Leave leaves a nested value entered with Enter.
+func (d *Decoder) Leave() {
+	d.usage.depth--
+}
+
+// charge charges l elements against the max elements and returns l as an int
+func (d *Decoder) charge(l uint64) (int, error) {
+	max := d.limits.MaxElements
+	if l > uint64(maxInt-d.usage.elements) || (max > 0 && d.usage.elements+int(l) > max) {
+		return 0, exceeded(ErrMaxElements, max)
+	}
+	d.usage.elements += int(l)
+	return int(l), nil
+}
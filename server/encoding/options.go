@@ -0,0 +1,71 @@
+package encoding
+
+// Format is the wire format of encoded values
+type Format byte
+
+const (
+	// Positional encodes struct fields in order of their field number without keys. The default
+	Positional Format = iota
+
+	// TLV prefixes struct fields with their field number and wire type.
+	// Unknown fields are skipped and missing fields decode to their zero value,
+	// hence peers with different versions of a struct interoperate.
+	TLV
+
+	// SelfDescribing precedes values with their type tag and fields with their
+	// number and name, hence values decode without knowing their type.
+	// Integers are varints regardless of compact mode.
+	SelfDescribing
+)
+
+func (f Format) String() string {
+	switch f {
+	case Positional:
+		return "Positional"
+	case TLV:
+		return "TLV"
+	case SelfDescribing:
+		return "SelfDescribing"
+	default:
+		return "Unknown"
+	}
+}
+
+type options struct {
+	format  Format
+	compact bool
+	limits  Limits
+}
+
+type Option func(*options)
+
+// WithFormat sets the wire format. Defaults to Positional
+func WithFormat(format Format) Option {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// WithCompact writes integers wider than 8 bits as varints, zigzag encoded if signed,
+// and lengths as uvarints. Floats and fields tagged fixed keep their fixed width.
+func WithCompact(compact bool) Option {
+	return func(o *options) {
+		o.compact = compact
+	}
+}
+
+// WithLimits sets the limits of decoding a value. Defaults to DefaultLimits
+func WithLimits(limits Limits) Option {
+	return func(o *options) {
+		o.limits = limits
+	}
+}
+
+// newOptions applies opts over the default options
+func newOptions(opts []Option) options {
+	o := options{format: Positional, limits: DefaultLimits}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -8,11 +8,18 @@ import (
 type BufferReader struct {
 	buffer []byte
 	offset int64 // current reading index
+	limit  int64 // bytes that may be read. Zero is unlimited
 }
 
 // NewBufferReader creates a new BufferReader.
 func NewBufferReader(b []byte) *BufferReader {
-	return &BufferReader{b, 0}
+	return &BufferReader{b, 0, 0}
+}
+
+// SetLimit limits the bytes that may be read from the start of the buffer to n.
+// Reads past the limit return a LimitError of ErrMaxBytes. Zero is unlimited.
+func (r *BufferReader) SetLimit(n int) {
+	r.limit = int64(n)
 }
 
 // Len returns the number of bytes of the unread portion of the buffer.
@@ -31,7 +38,14 @@ func (r *BufferReader) Read(b []byte) (n int, err error) {
 	if r.offset >= int64(len(r.buffer)) {
 		return 0, io.EOF
 	}
-	n = copy(b, r.buffer[r.offset:])
+	end := int64(len(r.buffer))
+	if r.limit > 0 && end > r.limit {
+		if r.offset >= r.limit {
+			return 0, exceeded(ErrMaxBytes, int(r.limit))
+		}
+		end = r.limit
+	}
+	n = copy(b, r.buffer[r.offset:end])
 	r.offset += int64(n)
 	return
 }
@@ -40,9 +54,13 @@ func (r *BufferReader) Read(b []byte) (n int, err error) {
 // Mutating the offset
 func (r *BufferReader) Slice(n uint) ([]byte, error) {
 	// Exceeds the buffer length
-	if r.offset+int64(n) > int64(len(r.buffer)) {
+	if uint64(n) > uint64(r.Len()) {
 		return nil, io.EOF
 	}
+	// Exceeds the limit
+	if r.limit > 0 && r.offset+int64(n) > r.limit {
+		return nil, exceeded(ErrMaxBytes, int(r.limit))
+	}
 
 	cur := r.offset
 	r.offset += int64(n)
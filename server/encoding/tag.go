@@ -0,0 +1,125 @@
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Tag is the parsed `cz` struct tag of a field, e.g.
+//
+//	ID    int32  `cz:"1,varint"`
+//	Note  string `cz:"4,omitempty"`
+//	cache []byte `cz:"-"`
+//
+// The number orders fields on the wire. Fields without a number take the number
+// following the previous field, hence untagged structs encode in declaration order.
+type Tag struct {
+	// Skip the field. Set by "-"
+	Skip bool
+
+	// Field number. Zero if not set
+	Number int
+
+	// Omit the field if it holds the zero value. Omitted fields decode to the zero value
+	OmitEmpty bool
+
+	// Encode integers as varints. Signed integers are zigzag encoded
+	Varint bool
+
+	// Encode integers with fixed width. The default
+	Fixed bool
+}
+
+// ParseTag parses the value of a `cz` struct tag
+func ParseTag(tag string) (Tag, error) {
+	t := Tag{}
+	if tag == "-" {
+		t.Skip = true
+		return t, nil
+	}
+	if tag == "" {
+		return t, nil
+	}
+
+	opts := strings.Split(tag, ",")
+	if opts[0] != "" {
+		n, err := strconv.Atoi(opts[0])
+		if err != nil || n <= 0 {
+			return t, fmt.Errorf("invalid field number %q", opts[0])
+		}
+		t.Number = n
+	}
+	for _, opt := range opts[1:] {
+		switch opt {
+		case "omitempty":
+			t.OmitEmpty = true
+		case "varint":
+			t.Varint = true
+		case "fixed":
+			t.Fixed = true
+		default:
+			return t, fmt.Errorf("unknown option %q", opt)
+		}
+	}
+	if t.Varint && t.Fixed {
+		return t, fmt.Errorf("varint and fixed are exclusive")
+	}
+	return t, nil
+}
+
+// NumberFields assigns field numbers to tags of fields in declaration order.
+// It returns the indexes of tags sorted by field number. Skipped fields are left out.
+func NumberFields(tags []Tag) ([]int, error) {
+	seen := map[int]bool{}
+	order := []int{}
+	next := 1
+	for i := range tags {
+		if tags[i].Skip {
+			continue
+		}
+		if tags[i].Number == 0 {
+			tags[i].Number = next
+		}
+		if seen[tags[i].Number] {
+			return nil, fmt.Errorf("duplicate field number %v", tags[i].Number)
+		}
+		seen[tags[i].Number] = true
+		next = tags[i].Number + 1
+		order = append(order, i)
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return tags[order[a]].Number < tags[order[b]].Number
+	})
+	return order, nil
+}
+
+// isInteger returns true if k is an integer kind
+func isInteger(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// isSigned returns true if k is a signed integer kind
+func isSigned(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
+
+// isEmpty returns true if rv holds the zero value or an empty slice, map or string
+func isEmpty(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.String:
+		return rv.Len() == 0
+	}
+	return rv.IsZero()
+}
@@ -0,0 +1,105 @@
+package encoding
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type tagged struct {
+	Seats  int32  `cz:"2,varint"`
+	ID     uint16 `cz:"1"`
+	Note   string `cz:"3,omitempty"`
+	Cache  int32  `cz:"-"`
+	Hops   uint32 `cz:",varint"`
+	hidden int32
+}
+
+// TestParseTag verifies options of tags are parsed and invalid tags are rejected
+func TestParseTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want Tag
+		err  bool
+	}{
+		{tag: "", want: Tag{}},
+		{tag: "-", want: Tag{Skip: true}},
+		{tag: "1", want: Tag{Number: 1}},
+		{tag: ",omitempty", want: Tag{OmitEmpty: true}},
+		{tag: "4,varint,omitempty", want: Tag{Number: 4, Varint: true, OmitEmpty: true}},
+		{tag: "2,fixed", want: Tag{Number: 2, Fixed: true}},
+		{tag: "0", err: true},
+		{tag: "-1", err: true},
+		{tag: "one", err: true},
+		{tag: "1,packed", err: true},
+		{tag: "1,varint,fixed", err: true},
+	}
+	for _, test := range tests {
+		got, err := ParseTag(test.tag)
+		if test.err {
+			if err == nil {
+				t.Fatalf("%q: got %+v, want an error", test.tag, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Fatalf("%q: got %+v, %v, want %+v", test.tag, got, err, test.want)
+		}
+	}
+}
+
+// TestNumberFields verifies untagged fields follow the previous field number,
+// fields are ordered by number and duplicate numbers are rejected
+func TestNumberFields(t *testing.T) {
+	tags := []Tag{{Number: 3}, {}, {Skip: true}, {Number: 1}, {}}
+	order, err := NumberFields(tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 4, 0, 1}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("got order %v, want %v", order, want)
+	}
+	if tags[1].Number != 4 || tags[4].Number != 2 || tags[2].Number != 0 {
+		t.Fatalf("got %+v", tags)
+	}
+
+	if _, err = NumberFields([]Tag{{Number: 2}, {Number: 1}, {}}); err == nil {
+		t.Fatal("duplicate field number accepted")
+	}
+}
+
+// TestTaggedStruct verifies fields are encoded in field number order with their options
+func TestTaggedStruct(t *testing.T) {
+	v := &tagged{Seats: -2, ID: 1, Cache: 7, Hops: 300, hidden: 8}
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Non nil pointer, ID, Seats zigzag varint, absent Note, Hops varint
+	if want := []byte{0x00, 0x01, 0x00, 0x03, 0x00, 0xac, 0x02}; !bytes.Equal(b, want) {
+		t.Fatalf("got %x, want %x", b, want)
+	}
+
+	v.Note = "window"
+	for _, opts := range [][]Option{nil, {WithFormat(TLV)}, {WithCompact(true)}} {
+		b, err := Marshal(v, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := new(tagged)
+		if err = Unmarshal(b, got, opts...); err != nil {
+			t.Fatal(err)
+		}
+		want := tagged{Seats: -2, ID: 1, Note: "window", Hops: 300}
+		if *got != want {
+			t.Fatalf("got %+v, want %+v", *got, want)
+		}
+	}
+
+	type invalid struct {
+		Name string `cz:"1,varint"`
+	}
+	if _, err = Marshal(&invalid{}); err == nil {
+		t.Fatal("varint string accepted")
+	}
+}
@@ -0,0 +1,305 @@
+package encoding
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// TLVVersion is the version of the TLV format written as the first byte of a TLV stream.
+// Decoders reject streams of newer versions.
+const TLVVersion byte = 1
+
+// Wire types of TLV fields. The key of a field is the uvarint of number<<3 | wire type.
+// A key of 0 ends a struct.
+const (
+	WireVarint  byte = 0 // uvarint. Signed integers are zigzag encoded
+	WireFixed64 byte = 1 // 8 bytes little endian
+	WireBytes   byte = 2 // uvarint length followed by the payload
+	WireFixed32 byte = 5 // 4 bytes little endian
+)
+
+var ErrTLVVersion = errors.New("Unsupported TLV version")
+
+// wireType returns the wire type of a field of type t.
+// Pointer fields take the wire type of their element.
+func wireType(t reflect.Type, varint bool) byte {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.Int8, reflect.Int16, reflect.Uint8, reflect.Uint16:
+		return WireVarint
+	case reflect.Int32, reflect.Uint32:
+		if varint {
+			return WireVarint
+		}
+		return WireFixed32
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uintptr, reflect.Uint64:
+		if varint {
+			return WireVarint
+		}
+		return WireFixed64
+	case reflect.Float32:
+		return WireFixed32
+	case reflect.Float64:
+		return WireFixed64
+	}
+	return WireBytes
+}
+
+// fieldWireType returns the wire type of a field of type t and whether its
+// value is written as raw bytes. Types with custom codecs are WireBytes
+// payloads encoded by their codec unless tagged varint.
+func fieldWireType(t reflect.Type, varint bool) (byte, bool) {
+	elem := t
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if _, ok := customCodec(elem); ok && !varint {
+		return WireBytes, false
+	}
+	wire := wireType(t, varint)
+	return wire, wire == WireBytes && isRaw(elem)
+}
+
+// isRaw returns true if values of t are written as raw bytes in a WireBytes payload
+func isRaw(t reflect.Type) bool {
+	return t.Kind() == reflect.String || (t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8)
+}
+
+// encodeTLV encodes the fields of rv prefixed with their keys followed by an end key
+func (s *structCodec) encodeTLV(e *Encoder, rv reflect.Value) (err error) {
+	for _, f := range s.fields {
+		v := rv.Field(f.index)
+		if f.omitEmpty && isEmpty(v) {
+			continue
+		}
+		// Missing fields decode to nil
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				continue
+			}
+			v = v.Elem()
+		}
+		if err = e.WriteUvarint(uint64(f.number)<<3 | uint64(f.wire)); err != nil {
+			return err
+		}
+		if err = f.encodeTLV(e, v); err != nil {
+			return err
+		}
+	}
+	return e.WriteUvarint(0)
+}
+
+// decodeTLV decodes fields into rv until an end key. Unknown fields are skipped
+// and fields missing from the stream are set to their zero value.
+func (s *structCodec) decodeTLV(d *Decoder, rv reflect.Value) (err error) {
+	seen := make([]bool, len(s.fields))
+	for {
+		key, err := d.ReadUvarint()
+		if err != nil {
+			return err
+		}
+		if key == 0 {
+			break
+		}
+
+		number, wire := int(key>>3), byte(key&7)
+		i, ok := s.numbers[number]
+		if !ok {
+			if err = d.skip(wire); err != nil {
+				return err
+			}
+			continue
+		}
+
+		f := s.fields[i]
+		if wire != f.wire {
+			return fmt.Errorf("Field %v has wire type %v, expected %v", number, wire, f.wire)
+		}
+		v := rv.Field(f.index)
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		if err = f.decodeTLV(d, v); err != nil {
+			return err
+		}
+		seen[i] = true
+	}
+
+	for i, f := range s.fields {
+		if !seen[i] {
+			v := rv.Field(f.index)
+			v.Set(reflect.Zero(v.Type()))
+		}
+	}
+	return nil
+}
+
+// encodeTLV encodes the value of a field by its wire type
+func (f *fieldCodec) encodeTLV(e *Encoder, rv reflect.Value) error {
+	switch f.wire {
+	case WireVarint:
+		switch {
+		case rv.Kind() == reflect.Bool:
+			return e.WriteBool(rv.Bool())
+		case isSigned(rv.Kind()):
+			return e.WriteVarint(rv.Int())
+		default:
+			return e.WriteUvarint(rv.Uint())
+		}
+	case WireFixed32:
+		switch rv.Kind() {
+		case reflect.Float32:
+			return e.WriteFloat32(float32(rv.Float()))
+		case reflect.Int32:
+			return e.WriteFixed32(uint32(rv.Int()))
+		default:
+			return e.WriteFixed32(uint32(rv.Uint()))
+		}
+	case WireFixed64:
+		switch rv.Kind() {
+		case reflect.Float64:
+			return e.WriteFloat64(rv.Float())
+		case reflect.Int, reflect.Int64:
+			return e.WriteFixed64(uint64(rv.Int()))
+		default:
+			return e.WriteFixed64(rv.Uint())
+		}
+	}
+
+	// Raw bytes
+	if f.raw {
+		if err := e.WriteUvarint(uint64(rv.Len())); err != nil {
+			return err
+		}
+		if rv.Kind() == reflect.String {
+			return e.WriteBytes([]byte(rv.String()))
+		}
+		return e.WriteBytes(rv.Bytes())
+	}
+
+	// Payload encoded by the codec of the value
+	var buffer bytes.Buffer
+	sub := &Encoder{out: &buffer, format: e.format, compact: e.compact}
+	if err := f.elem.Encode(sub, rv); err != nil {
+		return err
+	}
+	if err := e.WriteUvarint(uint64(buffer.Len())); err != nil {
+		return err
+	}
+	return e.WriteBytes(buffer.Bytes())
+}
+
+// decodeTLV decodes the value of a field by its wire type
+func (f *fieldCodec) decodeTLV(d *Decoder, rv reflect.Value) error {
+	switch f.wire {
+	case WireVarint:
+		switch {
+		case rv.Kind() == reflect.Bool:
+			b, err := d.ReadUvarint()
+			rv.SetBool(b != 0)
+			return err
+		case isSigned(rv.Kind()):
+			n, err := d.ReadVarint()
+			if err != nil {
+				return err
+			}
+			if rv.OverflowInt(n) {
+				return ErrOverflow
+			}
+			rv.SetInt(n)
+			return nil
+		default:
+			n, err := d.ReadUvarint()
+			if err != nil {
+				return err
+			}
+			if rv.OverflowUint(n) {
+				return ErrOverflow
+			}
+			rv.SetUint(n)
+			return nil
+		}
+	case WireFixed32:
+		n, err := d.ReadFixed32()
+		if err != nil {
+			return err
+		}
+		switch rv.Kind() {
+		case reflect.Float32:
+			rv.SetFloat(float64(math.Float32frombits(n)))
+		case reflect.Int32:
+			rv.SetInt(int64(int32(n)))
+		default:
+			rv.SetUint(uint64(n))
+		}
+		return nil
+	case WireFixed64:
+		n, err := d.ReadFixed64()
+		if err != nil {
+			return err
+		}
+		switch rv.Kind() {
+		case reflect.Float64:
+			rv.SetFloat(math.Float64frombits(n))
+		case reflect.Int, reflect.Int64:
+			rv.SetInt(int64(n))
+		default:
+			rv.SetUint(n)
+		}
+		return nil
+	}
+
+	l, err := d.ReadUvarint()
+	if err != nil {
+		return err
+	}
+	b, err := d.next(int(l))
+	if err != nil {
+		return err
+	}
+
+	// Raw bytes. Byte slices count towards the elements of the value
+	if f.raw {
+		if rv.Kind() == reflect.String {
+			rv.SetString(string(b))
+			return nil
+		}
+		if _, err = d.charge(l); err != nil {
+			return err
+		}
+		rv.SetBytes(append([]byte(nil), b...))
+		return nil
+	}
+
+	// Payload decoded by the codec of the value
+	return f.elem.Decode(d.sub(b), rv)
+}
+
+// skip skips the value of an unknown field of wire type
+func (d *Decoder) skip(wire byte) error {
+	var err error
+	switch wire {
+	case WireVarint:
+		_, err = d.ReadUvarint()
+	case WireFixed32:
+		_, err = d.next(4)
+	case WireFixed64:
+		_, err = d.next(8)
+	case WireBytes:
+		var l uint64
+		if l, err = d.ReadUvarint(); err == nil {
+			_, err = d.next(int(l))
+		}
+	default:
+		err = fmt.Errorf("Unknown wire type %v", wire)
+	}
+	return err
+}
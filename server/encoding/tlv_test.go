@@ -0,0 +1,84 @@
+package encoding
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+type flightV1 struct {
+	ID     int32  `cz:"1"`
+	Source string `cz:"2"`
+	Seats  int32  `cz:"4,varint"`
+}
+
+// flightV2 adds fields to flightV1, declares Seats first while keeping its number
+// and leaves Cache off the wire
+type flightV2 struct {
+	Seats  int32     `cz:"4,varint"`
+	ID     int32     `cz:"1"`
+	Source string    `cz:"2"`
+	Fare   float64   `cz:"3"`
+	Meals  []string  `cz:"5"`
+	Prev   *flightV1 `cz:"6"`
+	Cache  string    `cz:"-"`
+}
+
+// TestTLVVersions verifies structs of different versions decode each other's
+// TLV streams by field number
+func TestTLVVersions(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		opts := []Option{WithFormat(TLV), WithCompact(compact)}
+		v2 := &flightV2{
+			Seats:  120,
+			ID:     7,
+			Source: "Singapore",
+			Fare:   420.5,
+			Meals:  []string{"Steak"},
+			Prev:   &flightV1{ID: 6, Source: "Tokyo", Seats: 3},
+			Cache:  "stale",
+		}
+
+		// Unknown fields of newer versions are skipped
+		b, err := Marshal(v2, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v1 := new(flightV1)
+		if err = Unmarshal(b, v1, opts...); err != nil {
+			t.Fatal(err)
+		}
+		if want := (flightV1{ID: 7, Source: "Singapore", Seats: 120}); *v1 != want {
+			t.Fatalf("got %+v, want %+v", *v1, want)
+		}
+
+		// Fields missing from older versions decode to their zero value
+		b, err = Marshal(v1, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &flightV2{Fare: 1, Meals: []string{"Wine"}, Prev: &flightV1{ID: 1}, Cache: "kept"}
+		if err = Unmarshal(b, got, opts...); err != nil {
+			t.Fatal(err)
+		}
+		want := &flightV2{Seats: 120, ID: 7, Source: "Singapore", Cache: "kept"}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+
+		// Skipped fields are never written
+		v2.Cache = ""
+		without, err := Marshal(v2, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v2.Cache = "stale"
+		with, err := Marshal(v2, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(with, without) {
+			t.Fatalf("got %x, want %x without the skipped field", with, without)
+		}
+	}
+}
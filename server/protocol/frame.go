@@ -11,8 +11,39 @@ const (
 	DNE             // end of partition
 	NOP             // no operation
 	FIN             // stream close, EOF
+	ACK             // reply received, SeqId carries the request sequence
+	RST             // request canceled, SeqId carries the request sequence
 )
 
+// Options of a stream carried by the data byte of its SYN frame.
+// A SYN frame without data opens a stream with no options.
+const (
+	// SynCompact encodes data of the stream in the compact encoding mode
+	SynCompact byte = 1 << iota
+)
+
+// FlagName returns the name of a frame flag
+func FlagName(flag byte) string {
+	switch flag {
+	case SYN:
+		return "SYN"
+	case PSH:
+		return "PSH"
+	case DNE:
+		return "DNE"
+	case NOP:
+		return "NOP"
+	case FIN:
+		return "FIN"
+	case ACK:
+		return "ACK"
+	case RST:
+		return "RST"
+	default:
+		return "UNKNOWN"
+	}
+}
+
 // Frame used to encapsulate data in UDP.
 type Frame struct {
 	Flag  byte
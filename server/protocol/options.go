@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/isaiahwong/cz4013/rpc"
+	"github.com/isaiahwong/cz4013/store"
 	"github.com/sirupsen/logrus"
 )
 
@@ -11,10 +12,19 @@ type options struct {
 	logger          *logrus.Logger
 	port            string
 	semantic        Semantics
+	methodSemantics map[string]Semantics
 	deadline        time.Duration
 	flightRepo      *rpc.FlightRepo
 	reservationRepo *rpc.ReservationRepo
 	lossRate        int
+	replyTTL        time.Duration
+	replyMaxEntries int
+	replyMaxBytes   int
+	replyLog        string
+	db              *store.DB
+	journal         string
+	unary           []rpc.UnaryInterceptor
+	stream          []rpc.StreamInterceptor
 }
 
 // Option sets options for Server.
@@ -27,12 +37,22 @@ func WithPort(port string) Option {
 	}
 }
 
+// WithSemantic returns an Option which sets the semantics of non idempotent methods.
+// Semantics weaker than at-most-once are rejected by New, see WithMethodSemantic.
 func WithSemantic(semantic Semantics) Option {
 	return func(o *options) {
 		o.semantic = semantic
 	}
 }
 
+// WithMethodSemantic returns an Option which overrides the semantics of a method.
+// Non idempotent methods only run at-least-once if overridden.
+func WithMethodSemantic(method string, semantic Semantics) Option {
+	return func(o *options) {
+		o.methodSemantics[method] = semantic
+	}
+}
+
 func WithDeadline(deadline time.Duration) Option {
 	return func(o *options) {
 		o.deadline = deadline
@@ -66,3 +86,55 @@ func WithLossRate(rate int) Option {
 		o.lossRate = rate
 	}
 }
+
+// WithReplyCacheTTL returns an Option which sets how long replies are kept for at-most-once semantics
+func WithReplyCacheTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		if ttl > 0 {
+			o.replyTTL = ttl
+		}
+	}
+}
+
+// WithReplyCacheLimits returns an Option which bounds the number of cached replies and their memory in bytes.
+// A limit of 0 disables the limit.
+func WithReplyCacheLimits(maxEntries int, maxBytes int) Option {
+	return func(o *options) {
+		o.replyMaxEntries = maxEntries
+		o.replyMaxBytes = maxBytes
+	}
+}
+
+// WithReplyLog returns an Option which persists replies for at-most-once semantics
+// to an append-only log at path so they survive server restarts
+func WithReplyLog(path string) Option {
+	return func(o *options) {
+		o.replyLog = path
+	}
+}
+
+// WithJournal returns an Option which journals mutations of db to path.
+// Required for exactly-once semantics where mutations are committed with replies
+func WithJournal(db *store.DB, path string) Option {
+	return func(o *options) {
+		o.db = db
+		o.journal = path
+	}
+}
+
+// WithUnaryInterceptors returns an Option which appends interceptors of decoded requests
+// e.g. rpc.Authorize, rpc.RateLimit or the interceptor of rpc.Metrics.
+// They run outside rpc.Validation in order from the outermost.
+func WithUnaryInterceptors(interceptors ...rpc.UnaryInterceptor) Option {
+	return func(o *options) {
+		o.unary = append(o.unary, interceptors...)
+	}
+}
+
+// WithStreamInterceptors returns an Option which appends interceptors of requests read from streams.
+// They run inside rpc.Recovery and rpc.Logging in order from the outermost.
+func WithStreamInterceptors(interceptors ...rpc.StreamInterceptor) Option {
+	return func(o *options) {
+		o.stream = append(o.stream, interceptors...)
+	}
+}
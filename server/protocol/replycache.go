@@ -0,0 +1,338 @@
+package protocol
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/isaiahwong/cz4013/encoding"
+	"github.com/isaiahwong/cz4013/store"
+)
+
+// entryOverhead approximates the bookkeeping memory of a cache entry in bytes
+const entryOverhead = 128
+
+// minCompactSize is the size of the reply log in bytes before it is compacted
+const minCompactSize = 1 << 20
+
+// Operations of journaled reply records
+const (
+	recordPut uint8 = iota + 1
+	recordAck
+)
+
+// replyRecord is a change of the reply cache journaled to the reply log
+type replyRecord struct {
+	Op      uint8
+	Client  string
+	SID     string
+	Seq     uint32
+	Expires int64
+	Reply   []byte
+}
+
+// ReplyKey identifies a request of a client for at-most-once semantics.
+// Retries of a request share the SID and sequence of the original request.
+type ReplyKey struct {
+	Client string // remote address of the client
+	SID    string // stream id of the request
+	Seq    uint16 // request sequence of the client session
+}
+
+// ReplyCacheStats are the counters of a ReplyCache
+type ReplyCacheStats struct {
+	Entries          int
+	Bytes            int
+	Hits             uint64
+	Misses           uint64
+	Acked            uint64 // entries freed by client acknowledgements
+	ExpiredEvictions uint64 // entries evicted by ttl
+	LRUEvictions     uint64 // entries evicted by entry or memory limits
+}
+
+// replyEntry is a cached reply
+type replyEntry struct {
+	key     ReplyKey
+	reply   []byte
+	expires time.Time
+	size    int
+}
+
+// ReplyCache is a bounded duplicate request table of replies.
+// Entries expire after a ttl and are evicted in least recently used order
+// once the entry or memory limit is reached.
+type ReplyCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int
+
+	lru     *list.List
+	entries map[ReplyKey]*list.Element
+	stats   ReplyCacheStats
+
+	// Durable log of replies. nil if the cache is in memory only
+	log *store.Log
+}
+
+// NewReplyCache creates a reply cache. A limit of 0 disables the limit.
+func NewReplyCache(ttl time.Duration, maxEntries int, maxBytes int) *ReplyCache {
+	return &ReplyCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		lru:        list.New(),
+		entries:    make(map[ReplyKey]*list.Element),
+	}
+}
+
+// OpenReplyCache creates a reply cache persisted to an append-only log at path.
+// Replies in the log that have not expired or been acknowledged are reloaded.
+func OpenReplyCache(path string, ttl time.Duration, maxEntries int, maxBytes int) (*ReplyCache, error) {
+	log, err := store.OpenLog(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewReplyCache(ttl, maxEntries, maxBytes)
+	err = log.Replay(func(b []byte) error {
+		r := new(replyRecord)
+		if err := encoding.Unmarshal(b, r); err != nil {
+			return err
+		}
+		k := ReplyKey{Client: r.Client, SID: r.SID, Seq: uint16(r.Seq)}
+		switch r.Op {
+		case recordPut:
+			c.insert(k, r.Reply, time.Unix(0, r.Expires))
+		case recordAck:
+			if el, ok := c.entries[k]; ok {
+				c.remove(el)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+
+	c.log = log
+	// Drop expired and acknowledged replies from the log
+	if _, err = c.Sweep(); err == nil {
+		err = c.compact()
+	}
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get returns the cached reply of a request. Only requests of methods whose
+// replies are cached are looked up so misses are not skewed.
+func (c *ReplyCache) Get(k ReplyKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[k]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	e := el.Value.(*replyEntry)
+	if c.ttl > 0 && time.Now().After(e.expires) {
+		c.remove(el)
+		c.stats.ExpiredEvictions++
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	c.stats.Hits++
+	return e.reply, true
+}
+
+// Put caches the reply of a request and evicts entries exceeding the limits.
+// A persisted reply is fsync'd to the log before Put returns.
+func (c *ReplyCache) Put(k ReplyKey, reply []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if c.log != nil {
+		if err := c.journal(recordPut, k, reply, expires, true); err != nil {
+			return err
+		}
+	}
+	c.insert(k, reply, expires)
+	return nil
+}
+
+// restore caches a reply that is persisted elsewhere without journaling it
+func (c *ReplyCache) restore(k ReplyKey, reply []byte, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.insert(k, reply, expires)
+}
+
+// insert adds an entry to the cache and evicts entries exceeding the limits
+func (c *ReplyCache) insert(k ReplyKey, reply []byte, expires time.Time) {
+	if el, ok := c.entries[k]; ok {
+		c.remove(el)
+	}
+
+	e := &replyEntry{
+		key:     k,
+		reply:   reply,
+		expires: expires,
+		size:    len(reply) + len(k.Client) + len(k.SID) + entryOverhead,
+	}
+	c.entries[k] = c.lru.PushFront(e)
+	c.stats.Bytes += e.size
+
+	// Evict least recently used entries
+	for c.lru.Len() > 1 && c.exceeded() {
+		c.remove(c.lru.Back())
+		c.stats.LRUEvictions++
+	}
+}
+
+// Ack frees the cached reply of a request once the client has received it
+func (c *ReplyCache) Ack(k ReplyKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[k]
+	if !ok {
+		return false
+	}
+	c.remove(el)
+	c.stats.Acked++
+
+	// Acknowledgements are not synced as a lost record only delays the release
+	if c.log != nil {
+		c.journal(recordAck, k, nil, time.Time{}, false)
+	}
+	return true
+}
+
+// Sweep evicts expired entries and compacts the reply log once it has grown.
+// It returns the number of evicted entries and the error of the compaction.
+func (c *ReplyCache) Sweep() (int, error) {
+	if c.ttl <= 0 {
+		return 0, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	evicted := 0
+	for el := c.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if now.After(el.Value.(*replyEntry).expires) {
+			c.remove(el)
+			evicted++
+		}
+		el = prev
+	}
+	c.stats.ExpiredEvictions += uint64(evicted)
+
+	// Compact the log once it has grown well beyond the live replies
+	if c.log != nil && c.log.Size() > minCompactSize && c.log.Size() > 4*int64(c.stats.Bytes) {
+		if err := c.compact(); err != nil {
+			return evicted, err
+		}
+	}
+	return evicted, nil
+}
+
+// Close closes the reply log
+func (c *ReplyCache) Close() error {
+	if c.log == nil {
+		return nil
+	}
+	return c.log.Close()
+}
+
+// journal appends a change to the reply log
+func (c *ReplyCache) journal(op uint8, k ReplyKey, reply []byte, expires time.Time, sync bool) error {
+	b, err := encoding.Marshal(&replyRecord{
+		Op:      op,
+		Client:  k.Client,
+		SID:     k.SID,
+		Seq:     uint32(k.Seq),
+		Expires: expires.UnixNano(),
+		Reply:   reply,
+	})
+	if err != nil {
+		return err
+	}
+	return c.log.Append(b, sync)
+}
+
+// encodeReply encodes a reply as a record
+func encodeReply(k ReplyKey, reply []byte, expires time.Time) ([]byte, error) {
+	return encoding.Marshal(&replyRecord{
+		Op:      recordPut,
+		Client:  k.Client,
+		SID:     k.SID,
+		Seq:     uint32(k.Seq),
+		Expires: expires.UnixNano(),
+		Reply:   reply,
+	})
+}
+
+// decodeReply decodes a reply record
+func decodeReply(b []byte) (ReplyKey, []byte, time.Time, error) {
+	r := new(replyRecord)
+	if err := encoding.Unmarshal(b, r); err != nil {
+		return ReplyKey{}, nil, time.Time{}, err
+	}
+	k := ReplyKey{Client: r.Client, SID: r.SID, Seq: uint16(r.Seq)}
+	return k, r.Reply, time.Unix(0, r.Expires), nil
+}
+
+// compact rewrites the reply log with the cached replies only
+func (c *ReplyCache) compact() error {
+	records := [][]byte{}
+	for el := c.lru.Back(); el != nil; el = el.Prev() {
+		e := el.Value.(*replyEntry)
+		b, err := encoding.Marshal(&replyRecord{
+			Op:      recordPut,
+			Client:  e.key.Client,
+			SID:     e.key.SID,
+			Seq:     uint32(e.key.Seq),
+			Expires: e.expires.UnixNano(),
+			Reply:   e.reply,
+		})
+		if err != nil {
+			return err
+		}
+		records = append(records, b)
+	}
+	return c.log.Compact(records)
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *ReplyCache) Stats() ReplyCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	return stats
+}
+
+// exceeded returns true if the cache exceeds its limits
+func (c *ReplyCache) exceeded() bool {
+	return (c.maxEntries > 0 && c.lru.Len() > c.maxEntries) ||
+		(c.maxBytes > 0 && c.stats.Bytes > c.maxBytes)
+}
+
+// remove removes an entry from the cache
+func (c *ReplyCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*replyEntry)
+	delete(c.entries, e.key)
+	c.stats.Bytes -= e.size
+}
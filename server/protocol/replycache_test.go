@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testKey(i int) ReplyKey {
+	return ReplyKey{Client: "127.0.0.1:8080", SID: fmt.Sprint(i), Seq: uint16(i)}
+}
+
+// TestReplyCacheEviction verifies entries are evicted by ttl, entry and byte
+// limits in least recently used order, and freed by acknowledgements
+func TestReplyCacheEviction(t *testing.T) {
+	c := NewReplyCache(time.Minute, 2, 0)
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			// Entry 0 is used more recently than entry 1
+			if _, ok := c.Get(testKey(0)); !ok {
+				t.Fatal("entry 0 missing")
+			}
+		}
+		c.Put(testKey(i), []byte{byte(i)})
+	}
+	if _, ok := c.Get(testKey(1)); ok {
+		t.Fatal("least recently used entry not evicted")
+	}
+	if stats := c.Stats(); stats.Entries != 2 || stats.LRUEvictions != 1 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("got %+v", stats)
+	}
+
+	if !c.Ack(testKey(0)) || c.Ack(testKey(0)) {
+		t.Fatal("acknowledged entry not freed once")
+	}
+	if _, ok := c.Get(testKey(0)); ok {
+		t.Fatal("acknowledged entry returned")
+	}
+
+	// The byte limit holds a single entry
+	size := 2 + len(testKey(0).Client) + len(testKey(0).SID) + entryOverhead
+	c = NewReplyCache(time.Minute, 0, 2*size-1)
+	for i := 0; i < 3; i++ {
+		c.Put(testKey(i), []byte{0, 0})
+	}
+	if stats := c.Stats(); stats.Entries != 1 || stats.LRUEvictions != 2 || stats.Bytes != size {
+		t.Fatalf("got %+v", stats)
+	}
+
+	c = NewReplyCache(10*time.Millisecond, 0, 0)
+	c.Put(testKey(0), []byte{0})
+	c.Put(testKey(1), []byte{1})
+	time.Sleep(20 * time.Millisecond)
+	c.Put(testKey(2), []byte{2})
+	if n, err := c.Sweep(); n != 2 || err != nil {
+		t.Fatalf("got %v evictions, %v", n, err)
+	}
+	if _, ok := c.Get(testKey(2)); !ok {
+		t.Fatal("live entry evicted")
+	}
+}
+
+// TestReplyCacheLog verifies replies are replayed from the log without
+// acknowledged or expired replies, and the log is compacted
+func TestReplyCacheLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "replies")
+	c, err := OpenReplyCache(path, time.Minute, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = c.Put(testKey(i), []byte(fmt.Sprint("reply ", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Ack(testKey(1))
+	size := c.log.Size()
+	c.Close()
+
+	c, err = OpenReplyCache(path, time.Minute, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []bool{true, false, true} {
+		reply, ok := c.Get(testKey(i))
+		if ok != want || ok && string(reply) != fmt.Sprint("reply ", i) {
+			t.Fatalf("entry %v: got %q, %v", i, reply, ok)
+		}
+	}
+	// The acknowledgement and the acknowledged reply are compacted away
+	if c.log.Size() >= size {
+		t.Fatalf("got log of %v bytes, want less than %v", c.log.Size(), size)
+	}
+	c.Close()
+
+	// Expired replies are not reloaded
+	path = filepath.Join(t.TempDir(), "expired")
+	if c, err = OpenReplyCache(path, 10*time.Millisecond, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	c.Put(testKey(0), []byte{0})
+	c.Close()
+	time.Sleep(20 * time.Millisecond)
+	if c, err = OpenReplyCache(path, 10*time.Millisecond, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if stats := c.Stats(); stats.Entries != 0 || c.log.Size() != 0 {
+		t.Fatalf("got %+v, log of %v bytes", stats, c.log.Size())
+	}
+}
@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Retransmission timeout bounds and gains as defined in RFC 6298
+const (
+	MinRTO = 200 * time.Millisecond
+	MaxRTO = 60 * time.Second
+
+	rttAlpha = 0.125 // gain of SRTT
+	rttBeta  = 0.25  // gain of RTTVAR
+	rttK     = 4     // multiplier of RTTVAR
+)
+
+// RTTStats are round trip estimates of a peer used for diagnostics
+type RTTStats struct {
+	SRTT    time.Duration // smoothed round trip time
+	RTTVar  time.Duration // round trip time variation
+	RTO     time.Duration // retransmission timeout
+	Last    time.Duration // last round trip sample
+	Samples int           // number of samples taken
+}
+
+// jitter is the random source of Backoff
+var jitter = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// rttEstimator estimates the round trip time of a peer with Jacobson/Karels algorithm
+type rttEstimator struct {
+	mu    sync.Mutex
+	stats RTTStats
+}
+
+// observe updates the estimates with a new round trip sample
+func (e *rttEstimator) observe(r time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s := &e.stats
+	if s.Samples == 0 {
+		s.SRTT = r
+		s.RTTVar = r / 2
+	} else {
+		delta := s.SRTT - r
+		if delta < 0 {
+			delta = -delta
+		}
+		s.RTTVar = time.Duration((1-rttBeta)*float64(s.RTTVar) + rttBeta*float64(delta))
+		s.SRTT = time.Duration((1-rttAlpha)*float64(s.SRTT) + rttAlpha*float64(r))
+	}
+	s.Last = r
+	s.Samples++
+	s.RTO = clampRTO(s.SRTT + rttK*s.RTTVar)
+}
+
+// get returns a copy of the estimates
+func (e *rttEstimator) get() RTTStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.stats
+}
+
+// clampRTO bounds rto to [MinRTO, MaxRTO]
+func clampRTO(rto time.Duration) time.Duration {
+	if rto < MinRTO {
+		return MinRTO
+	}
+	if rto > MaxRTO {
+		return MaxRTO
+	}
+	return rto
+}
+
+// Backoff returns the timeout of a retry attempt. The timeout doubles on every
+// attempt and a random jitter of up to half the timeout is added so peers
+// do not retry in lockstep.
+func Backoff(rto time.Duration, attempt int) time.Duration {
+	backoff := rto
+	for i := 0; i < attempt && backoff < MaxRTO; i++ {
+		backoff *= 2
+	}
+	backoff = clampRTO(backoff)
+
+	half := int64(backoff / 2)
+	if half <= 0 {
+		return backoff
+	}
+	jitter.Lock()
+	defer jitter.Unlock()
+	return clampRTO(backoff + time.Duration(jitter.Int63n(half)))
+}
@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRTTEstimator verifies estimates follow RFC 6298 and the RTO is bounded
+func TestRTTEstimator(t *testing.T) {
+	e := new(rttEstimator)
+	e.observe(400 * time.Millisecond)
+	stats := e.get()
+	if stats.SRTT != 400*time.Millisecond || stats.RTTVar != 200*time.Millisecond || stats.RTO != 1200*time.Millisecond {
+		t.Fatalf("first sample: got %+v", stats)
+	}
+
+	// SRTT = 7/8 * 400 + 1/8 * 200, RTTVAR = 3/4 * 200 + 1/4 * 200
+	e.observe(200 * time.Millisecond)
+	stats = e.get()
+	if stats.SRTT != 375*time.Millisecond || stats.RTTVar != 200*time.Millisecond || stats.RTO != 1175*time.Millisecond {
+		t.Fatalf("second sample: got %+v", stats)
+	}
+	if stats.Last != 200*time.Millisecond || stats.Samples != 2 {
+		t.Fatalf("got %+v", stats)
+	}
+
+	e = new(rttEstimator)
+	e.observe(time.Millisecond)
+	if rto := e.get().RTO; rto != MinRTO {
+		t.Fatalf("got %v, want %v", rto, MinRTO)
+	}
+	e = new(rttEstimator)
+	e.observe(time.Minute)
+	if rto := e.get().RTO; rto != MaxRTO {
+		t.Fatalf("got %v, want %v", rto, MaxRTO)
+	}
+}
+
+// TestBackoff verifies timeouts double on every attempt with a jitter of up to
+// half the timeout and stay within MinRTO and MaxRTO
+func TestBackoff(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		for attempt, min := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+			if b := Backoff(time.Second, attempt); b < min || b >= min+min/2 {
+				t.Fatalf("attempt %v: got %v, want [%v, %v)", attempt, b, min, min+min/2)
+			}
+		}
+		if b := Backoff(time.Millisecond, 0); b < MinRTO || b >= MinRTO+MinRTO/2 {
+			t.Fatalf("got %v, want [%v, %v)", b, MinRTO, MinRTO+MinRTO/2)
+		}
+		if b := Backoff(time.Second, 100); b != MaxRTO {
+			t.Fatalf("got %v, want %v", b, MaxRTO)
+		}
+	}
+
+	// Jitter spreads timeouts so peers do not retry in lockstep
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 10; i++ {
+		seen[Backoff(time.Second, 1)] = true
+	}
+	if len(seen) < 2 {
+		t.Fatal("got timeouts without jitter")
+	}
+}
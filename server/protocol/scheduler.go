@@ -0,0 +1,177 @@
+package protocol
+
+import "sync"
+
+// Priority defines the scheduling class of outgoing frames of a stream
+type Priority byte
+
+const (
+	// PriorityBulk is the default class for data frames
+	PriorityBulk Priority = iota
+	// PriorityNotify is used for server notifications such as monitor updates
+	PriorityNotify
+	// PriorityControl is used for control frames i.e. SYN, DNE, FIN
+	PriorityControl
+
+	numPriorities = int(PriorityControl) + 1
+)
+
+// classWeights defines the number of frames a class may send in a round.
+// Classes with pending frames and remaining credits are served from the highest
+// class down, and credits are refilled once every class with pending frames has
+// used its credits. A round of saturated classes hence sends 16 control, 4 notify
+// and 1 bulk frame so lower classes are never starved.
+var classWeights = [numPriorities]int{
+	PriorityBulk:    1,
+	PriorityNotify:  4,
+	PriorityControl: 16,
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityBulk:
+		return "Bulk"
+	case PriorityNotify:
+		return "Notify"
+	case PriorityControl:
+		return "Control"
+	default:
+		return "Unknown"
+	}
+}
+
+// class is a priority class holding a queue of frames per stream.
+// Streams within a class are served round robin.
+type class struct {
+	credits int
+	queues  map[string][]writeRequest
+	order   []string // stream keys with pending frames
+}
+
+// scheduler schedules outgoing frames by priority class.
+// Classes are served with weighted round robin and streams within a class
+// are served round robin so a large write cannot block other streams.
+type scheduler struct {
+	mu      sync.Mutex
+	classes [numPriorities]*class
+	pending int
+
+	// Notifies the send loop that frames are pending
+	chReady chan struct{}
+}
+
+func newScheduler() *scheduler {
+	s := new(scheduler)
+	s.chReady = make(chan struct{}, 1)
+	for i := range s.classes {
+		s.classes[i] = &class{
+			credits: classWeights[i],
+			queues:  make(map[string][]writeRequest),
+		}
+	}
+	return s
+}
+
+// push queues requests of a stream into the class of priority
+func (s *scheduler) push(key string, priority Priority, reqs ...writeRequest) {
+	s.mu.Lock()
+	c := s.classes[priority]
+	if _, ok := c.queues[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.queues[key] = append(c.queues[key], reqs...)
+	s.pending += len(reqs)
+	s.mu.Unlock()
+
+	// notify send loop
+	select {
+	case s.chReady <- struct{}{}:
+	default:
+	}
+}
+
+// pop returns the next request to be sent
+func (s *scheduler) pop() (writeRequest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending == 0 {
+		return writeRequest{}, false
+	}
+
+	for {
+		// Serve the highest class with pending frames and remaining credits
+		for p := numPriorities - 1; p >= 0; p-- {
+			c := s.classes[p]
+			if len(c.order) == 0 || c.credits <= 0 {
+				continue
+			}
+			c.credits--
+			s.pending--
+			return c.next(), true
+		}
+
+		// Every class with pending frames has used its credits, start a new round
+		for p, c := range s.classes {
+			c.credits = classWeights[p]
+		}
+	}
+}
+
+// remove drops the requests of a stream that are still queued and returns
+// the number of requests dropped. Requests already popped are left to be sent.
+func (s *scheduler) remove(key string, reqs []writeRequest) int {
+	drop := make(map[chan writeResult]bool, len(reqs))
+	for _, req := range reqs {
+		drop[req.result] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for _, c := range s.classes {
+		q, ok := c.queues[key]
+		if !ok {
+			continue
+		}
+		kept := q[:0]
+		for _, req := range q {
+			if drop[req.result] {
+				removed++
+				continue
+			}
+			kept = append(kept, req)
+		}
+		if len(kept) > 0 {
+			c.queues[key] = kept
+			continue
+		}
+		delete(c.queues, key)
+		for i, k := range c.order {
+			if k == key {
+				c.order = append(c.order[:i], c.order[i+1:]...)
+				break
+			}
+		}
+	}
+	s.pending -= removed
+	return removed
+}
+
+// next pops the head of the next stream in round robin order
+func (c *class) next() writeRequest {
+	key := c.order[0]
+	c.order = c.order[1:]
+
+	q := c.queues[key]
+	req := q[0]
+	if len(q) == 1 {
+		delete(c.queues, key)
+	} else {
+		c.queues[key] = q[1:]
+		// Move stream to the back of the round
+		c.order = append(c.order, key)
+	}
+	return req
+}
@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+// testRequests returns n requests of a stream numbered from seq
+func testRequests(key string, seq int, n int) []writeRequest {
+	reqs := []writeRequest{}
+	for i := 0; i < n; i++ {
+		reqs = append(reqs, writeRequest{
+			frame:  NewFrame(PSH, []byte(key), 0, uint16(seq+i)),
+			result: make(chan writeResult, 1),
+		})
+	}
+	return reqs
+}
+
+// popAll pops every pending request as stream key and sequence pairs
+func popAll(s *scheduler) []string {
+	popped := []string{}
+	for {
+		req, ok := s.pop()
+		if !ok {
+			return popped
+		}
+		popped = append(popped, string(req.frame.Sid)+string(rune('0'+req.frame.SeqId)))
+	}
+}
+
+// TestSchedulerWeights verifies saturated classes are served 16 control,
+// 4 notify and 1 bulk frame a round
+func TestSchedulerWeights(t *testing.T) {
+	s := newScheduler()
+	for p := 0; p < numPriorities; p++ {
+		s.push(Priority(p).String(), Priority(p), testRequests(Priority(p).String(), 0, 50)...)
+	}
+	for round := 0; round < 2; round++ {
+		sent := map[string]int{}
+		for i := 0; i < 21; i++ {
+			req, ok := s.pop()
+			if !ok {
+				t.Fatal("scheduler drained")
+			}
+			sent[string(req.frame.Sid)]++
+		}
+		want := map[string]int{"Control": 16, "Notify": 4, "Bulk": 1}
+		if !reflect.DeepEqual(sent, want) {
+			t.Fatalf("round %v: got %v, want %v", round, sent, want)
+		}
+	}
+
+	// Lower classes take the credits of drained classes
+	s = newScheduler()
+	s.push("c", PriorityControl, testRequests("c", 0, 2)...)
+	s.push("b", PriorityBulk, testRequests("b", 0, 3)...)
+	if got, want := popAll(s), []string{"c0", "c1", "b0", "b1", "b2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// TestSchedulerRoundRobin verifies streams of a class are served in turns
+func TestSchedulerRoundRobin(t *testing.T) {
+	s := newScheduler()
+	s.push("a", PriorityBulk, testRequests("a", 0, 3)...)
+	s.push("b", PriorityBulk, testRequests("b", 0, 1)...)
+	s.push("c", PriorityBulk, testRequests("c", 0, 2)...)
+	want := []string{"a0", "b0", "c0", "a1", "c1", "a2"}
+	if got := popAll(s); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+// TestSchedulerRemove verifies queued requests of a stream are dropped
+// while popped requests and other streams are left
+func TestSchedulerRemove(t *testing.T) {
+	s := newScheduler()
+	a := testRequests("a", 0, 3)
+	s.push("a", PriorityBulk, a...)
+	s.push("b", PriorityBulk, testRequests("b", 0, 2)...)
+	s.push("a", PriorityControl, testRequests("a", 5, 1)...)
+
+	if req, _ := s.pop(); string(req.frame.Sid) != "a" || req.frame.SeqId != 5 {
+		t.Fatalf("got %v%v, want a5", string(req.frame.Sid), req.frame.SeqId)
+	}
+	if req, _ := s.pop(); req.result != a[0].result {
+		t.Fatal("got request other than a0")
+	}
+	if n := s.remove("a", a); n != 2 {
+		t.Fatalf("removed %v requests, want 2", n)
+	}
+	if got, want := popAll(s), []string{"b0", "b1"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if s.pending != 0 {
+		t.Fatalf("got %v pending requests", s.pending)
+	}
+	if n := s.remove("a", a); n != 0 {
+		t.Fatalf("removed %v requests of a drained stream", n)
+	}
+}
@@ -2,13 +2,16 @@ package protocol
 
 import (
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
 	"sync"
 	"time"
 
 	"github.com/isaiahwong/cz4013/common"
+	"github.com/isaiahwong/cz4013/encoding"
 	"github.com/isaiahwong/cz4013/rpc"
+	"github.com/isaiahwong/cz4013/store"
 	"github.com/sirupsen/logrus"
 )
 
@@ -16,8 +19,12 @@ type Semantics byte
 
 const (
 	Unknown Semantics = iota
+	// AtMostOnce caches replies so retried requests are not executed again
 	AtMostOnce
+	// AtLeastOnce executes every retried request
 	AtLeastOnce
+	// ExactlyOnce commits data mutations of a request together with its reply
+	ExactlyOnce
 )
 
 func IntToSemantics(s int) Semantics {
@@ -26,6 +33,8 @@ func IntToSemantics(s int) Semantics {
 		return AtMostOnce
 	case 1:
 		return AtLeastOnce
+	case 2:
+		return ExactlyOnce
 	default:
 		return Unknown
 	}
@@ -37,6 +46,8 @@ func (s Semantics) String() string {
 		return "AtMostOnce"
 	case AtLeastOnce:
 		return "AtLeastOnce"
+	case ExactlyOnce:
+		return "ExactlyOnce"
 	default:
 		return "Unknown"
 	}
@@ -48,11 +59,21 @@ type Server struct {
 	rpc    *rpc.RPC
 	addr   *net.UDPAddr
 
+	// Session of the server. Set once the server is serving
+	session *Session
+
 	dbLock     sync.Mutex
 	flightRepo *rpc.FlightRepo
 
-	historyLock sync.Mutex
-	history     map[string][]byte
+	// Data store journaling mutations for exactly-once semantics
+	db *store.DB
+
+	// Replies of requests for at-most-once semantics
+	replies *ReplyCache
+
+	// Requests being handled. Closed once their reply is cached
+	inflightMux sync.Mutex
+	inflight    map[ReplyKey]chan struct{}
 
 	lossRate int
 	rand     *rand.Rand
@@ -76,6 +97,9 @@ func (s *Server) Serve() (err error) {
 	}
 	// Create new session
 	sess := NewSession(conn, false)
+	sess.OnAck(s.ack)
+	sess.OnCancel(s.cancel)
+	s.session = sess
 
 	// Blocking
 	s.handleSession(sess)
@@ -87,9 +111,21 @@ func (s *Server) handleSession(sess *Session) {
 	sess.Start()
 	s.logger.Info(fmt.Sprintf("Started server on %v", s.addr))
 	s.logger.Info(fmt.Sprintf("Server semantic: %v", s.opts.semantic.String()))
+	semantics := s.MethodSemantics()
+	for _, method := range s.rpc.Methods() {
+		idempotency, _ := s.rpc.Idempotency(method)
+		s.logger.Info(fmt.Sprintf("Method %v (%v): %v", method, idempotency, semantics[method]))
+	}
 	s.logger.Info(fmt.Sprintf("Server loss rate: %v", s.opts.lossRate))
 
+	if s.replies != nil {
+		go s.sweepReplies(sess)
+	}
+
 	defer sess.Close()
+	if s.replies != nil {
+		defer s.replies.Close()
+	}
 	for {
 		stream, err := sess.Accept()
 		if err != nil {
@@ -101,6 +137,92 @@ func (s *Server) handleSession(sess *Session) {
 	}
 }
 
+// replyKey returns the at-most-once key of the request on stream
+func replyKey(stream *Stream) ReplyKey {
+	return ReplyKey{
+		Client: stream.addr.String(),
+		SID:    string(stream.SID()),
+		Seq:    stream.Seq(),
+	}
+}
+
+// ack releases the cached reply of a request acknowledged by a client
+func (s *Server) ack(addr *net.UDPAddr, sid []byte, seq uint16) {
+	if s.replies == nil {
+		return
+	}
+	s.replies.Ack(ReplyKey{Client: addr.String(), SID: string(sid), Seq: seq})
+}
+
+// requestID returns the id of a request of a client
+func requestID(addr *net.UDPAddr, sid []byte, seq uint16) string {
+	return fmt.Sprintf("%v/%x/%v", addr, sid, seq)
+}
+
+// cancel releases the work retained by a request canceled by a client.
+// Handlers still running observe the cancellation through the context of their stream.
+func (s *Server) cancel(addr *net.UDPAddr, sid []byte, seq uint16) {
+	if s.rpc.Cancel(addr.String(), requestID(addr, sid, seq)) {
+		s.logger.Info(fmt.Sprintf("Canceled request of %v", addr))
+	}
+}
+
+// sweepReplies periodically evicts expired replies until the session closes
+func (s *Server) sweepReplies(sess *Session) {
+	ticker := time.NewTicker(s.opts.replyTTL / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sess.chDie:
+			return
+		case <-ticker.C:
+			n, err := s.replies.Sweep()
+			if err != nil {
+				s.logger.WithError(err).Error("Unable to compact reply log")
+			}
+			if n > 0 {
+				stats := s.replies.Stats()
+				s.logger.WithFields(logrus.Fields{
+					"entries":  stats.Entries,
+					"bytes":    stats.Bytes,
+					"hits":     stats.Hits,
+					"misses":   stats.Misses,
+					"acked":    stats.Acked,
+					"expired":  stats.ExpiredEvictions,
+					"capacity": stats.LRUEvictions,
+				}).Info(fmt.Sprintf("Evicted %v expired replies", n))
+			}
+		}
+	}
+}
+
+// ReplyCacheStats returns the counters of the at-most-once reply cache
+func (s *Server) ReplyCacheStats() (ReplyCacheStats, bool) {
+	if s.replies == nil {
+		return ReplyCacheStats{}, false
+	}
+	return s.replies.Stats(), true
+}
+
+// push opens a server initiated stream towards a client endpoint and writes b
+func (s *Server) push(addr string, b []byte) error {
+	if s.session == nil {
+		return io.ErrClosedPipe
+	}
+
+	stream, err := s.session.OpenTo(addr)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	// Notifications preempt bulk responses
+	stream.SetPriority(PriorityNotify)
+	stream.SetWriteDeadline(time.Now().Add(s.opts.deadline))
+	_, err = stream.Write(b)
+	return err
+}
+
 // writable - a write middleware
 func (s *Server) writable(stream *Stream) func([]byte, bool) (int, error) {
 	writable := func(data []byte, lossy bool) (int, error) {
@@ -113,26 +235,7 @@ func (s *Server) writable(stream *Stream) func([]byte, bool) (int, error) {
 		return stream.Write(data)
 	}
 
-	atMostOnce := func(data []byte, lossy bool) (int, error) {
-		s.historyLock.Lock()
-		// Cache results
-		sid := stream.SID()
-		s.history[string(sid)] = data
-		s.historyLock.Unlock()
-
-		n, err := writable(data, lossy)
-		if err != nil {
-			return n, err
-		}
-		return n, nil
-	}
-
-	switch s.opts.semantic {
-	case AtMostOnce:
-		return atMostOnce
-	default:
-		return writable
-	}
+	return writable
 }
 
 // readable - a read middleware
@@ -152,89 +255,274 @@ func (s *Server) readable(stream *Stream) func(time.Duration) ([]byte, error) {
 	return readable
 }
 
+// encodingOf returns the encoding options negotiated by the SYN frame of stream
+func encodingOf(stream *Stream) []encoding.Option {
+	return []encoding.Option{encoding.WithCompact(stream.Compact())}
+}
+
+// semantic returns the invocation semantics of a method.
+// Read only and idempotent methods default to at-least-once as repeating them is safe.
+// Non idempotent methods default to the server semantic unless overridden.
+func (s *Server) semantic(method string) Semantics {
+	if semantic, ok := s.opts.methodSemantics[method]; ok {
+		return semantic
+	}
+	if idempotency, _ := s.rpc.Idempotency(method); idempotency != rpc.NonIdempotent {
+		return AtLeastOnce
+	}
+	return s.opts.semantic
+}
+
+// MethodSemantics returns the invocation semantics of every registered method
+func (s *Server) MethodSemantics() map[string]Semantics {
+	semantics := make(map[string]Semantics)
+	for _, method := range s.rpc.Methods() {
+		semantics[method] = s.semantic(method)
+	}
+	return semantics
+}
+
 func (s *Server) handleRequest(stream *Stream) {
 	defer stream.Close()
-	var err error
 
-	handleRequest := s.rpc.HandleRequest
+	if err := s.invoke(stream); err != nil {
+		s.logger.WithError(err).Error("Error handling request")
+		return
+	}
+}
+
+// invoke handles the request on stream with the semantics of its method
+func (s *Server) invoke(stream *Stream) error {
+	key := replyKey(stream)
+	read := s.readable(stream)
+	write := s.writable(stream)
 
-	// atMostOnce callback
-	atMostOnce := func() error {
-		s.historyLock.Lock()
-		sid := stream.SID()
-		// Check cache
-		cached, ok := s.history[string(sid)]
-		s.historyLock.Unlock()
+	opts := encodingOf(stream)
+	m, err := s.rpc.ReadMessage(read, write, opts...)
+	if err != nil || m == nil {
+		return err
+	}
 
-		if !ok {
-			return handleRequest(
-				stream.addr.String(),
-				s.readable(stream),
-				s.writable(stream),
-			)
+	semantic := s.semantic(m.RPC)
+	idempotency, _ := s.rpc.Idempotency(m.RPC)
+
+	// Return the reply of a retried request. Retries of a request being
+	// handled wait for its reply so the request is not executed again.
+	// Replies of at-least-once methods are never cached.
+	for semantic != AtLeastOnce {
+		if cached, ok := s.replies.Get(key); ok {
+			s.logger.Info(fmt.Sprintf("Returning cached result for %v", stream.addr))
+			_, err := write(cached, true)
+			return err
+		}
+		release, handling := s.track(key)
+		if handling == nil {
+			defer release()
+			break
 		}
+		select {
+		case <-handling:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
 
-		// Return cache
-		s.logger.Info(fmt.Sprintf("Returning cached result for %v", stream.addr))
-		_, aErr := s.writable(stream)(cached, true)
-		return aErr
+	id := requestID(stream.addr, stream.SID(), stream.Seq())
+	req := rpc.NewRequest(rpc.WithRequestID(stream.Context(), id), stream.addr.String(), m)
+	req.Deadline = time.Now().Add(s.opts.deadline)
+	req.Encoding = opts
+	req.Metadata["request-id"] = id
+	req.Metadata["semantic"] = semantic.String()
+
+	if semantic == AtLeastOnce && (s.db == nil || idempotency == rpc.ReadOnly) {
+		return s.rpc.Serve(req, rpc.NewResponseWriter(write, opts...))
 	}
 
-	// Choose semantics
-	switch s.opts.semantic {
+	// Mutations are journaled by every method once the journal is opened so it
+	// replays in order. Requests are serialized so mutations of a transaction
+	// belong to a single request.
+	var tx *store.Tx
+	if s.db != nil && (idempotency != rpc.ReadOnly || semantic == ExactlyOnce) {
+		if tx, err = s.db.Begin(); err != nil {
+			return err
+		}
+	}
+
+	// Capture reply so it is durable before it is sent
+	var reply []byte
+	var replyLossy bool
+	capture := func(data []byte, lossy bool) (int, error) {
+		reply = data
+		replyLossy = lossy
+		return len(data), nil
+	}
+	w := &failedResponse{ResponseWriter: rpc.NewResponseWriter(capture, opts...)}
+	hErr := s.rpc.Serve(req, w)
+
+	expires := time.Now().Add(s.opts.replyTTL)
+	if tx != nil {
+		if err = s.commit(tx, key, reply, expires, semantic, hErr != nil || w.failed); err != nil {
+			return err
+		}
+	}
+	if reply == nil {
+		return hErr
+	}
+
+	switch semantic {
 	case AtMostOnce:
-		err = atMostOnce()
-	default:
-		err = handleRequest(
-			stream.addr.IP.String(),
-			s.readable(stream),
-			s.writable(stream),
-		)
+		// Persisted replies are durable before they are sent
+		if err = s.replies.Put(key, reply); err != nil {
+			return err
+		}
+	case ExactlyOnce:
+		s.replies.restore(key, reply, expires)
 	}
+	_, err = write(reply, replyLossy)
+	return err
+}
 
-	if err != nil {
-		s.logger.WithError(err).Error("Error handling request")
-		return
+// track marks the request of key as being handled. It returns release if
+// the request is not handled yet, otherwise a channel closed once it is.
+func (s *Server) track(key ReplyKey) (release func(), handling <-chan struct{}) {
+	s.inflightMux.Lock()
+	defer s.inflightMux.Unlock()
+	if done, ok := s.inflight[key]; ok {
+		return nil, done
 	}
+	done := make(chan struct{})
+	s.inflight[key] = done
+	return func() {
+		s.inflightMux.Lock()
+		delete(s.inflight, key)
+		s.inflightMux.Unlock()
+		close(done)
+	}, nil
 }
 
-func newAtMostOnce(opts options) *Server {
-	s := new(Server)
-	s.opts = opts
-	s.logger = opts.logger
-	s.rpc = rpc.New(opts.flightRepo, opts.reservationRepo, opts.deadline)
-	s.history = make(map[string][]byte)
-	s.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
-	s.lossRate = opts.lossRate
-	return s
+// commit commits the mutations of a request with its reply. Mutations of
+// failed requests are rolled back instead.
+func (s *Server) commit(tx *store.Tx, key ReplyKey, reply []byte, expires time.Time, semantic Semantics, failed bool) error {
+	if failed {
+		if err := tx.Rollback(); err != nil {
+			s.logger.WithError(err).Error("Unable to roll back request, data store diverged from journal")
+			return err
+		}
+		return nil
+	}
+
+	var payload []byte
+	if semantic == ExactlyOnce && reply != nil {
+		var err error
+		if payload, err = encodeReply(key, reply, expires); err != nil {
+			if rErr := tx.Rollback(); rErr != nil {
+				s.logger.WithError(rErr).Error("Unable to roll back request, data store diverged from journal")
+			}
+			return err
+		}
+	}
+	if err := tx.Commit(payload); err != nil {
+		s.logger.WithError(err).Error("Unable to commit request, data store diverged from journal")
+		return err
+	}
+	return nil
 }
 
-func newAtLeastOnce(opts options) *Server {
-	s := new(Server)
-	s.opts = opts
-	s.logger = opts.logger
-	s.rpc = rpc.New(opts.flightRepo, opts.reservationRepo, opts.deadline)
-	s.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
-	s.lossRate = opts.lossRate
-	return s
+// failedResponse is a ResponseWriter recording whether the handler replied with an error
+type failedResponse struct {
+	rpc.ResponseWriter
+	failed bool
+}
+
+func (w *failedResponse) WriteMessage(m *rpc.Message, lossy bool) error {
+	if m.Error != nil {
+		w.failed = true
+	}
+	return w.ResponseWriter.WriteMessage(m, lossy)
+}
+
+// openJournal opens the journal of the data store and restores committed replies
+func (s *Server) openJournal() error {
+	if s.opts.db == nil || s.opts.journal == "" {
+		return store.ErrNoJournal
+	}
+
+	// Replay committed mutations and their replies
+	err := s.opts.db.OpenJournal(s.opts.journal, func(payload []byte) error {
+		if len(payload) == 0 {
+			return nil
+		}
+		k, reply, expires, err := decodeReply(payload)
+		if err != nil {
+			return err
+		}
+		if time.Now().Before(expires) {
+			s.replies.restore(k, reply, expires)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.db = s.opts.db
+	return nil
 }
 
 func New(opt ...Option) *Server {
 	// Default options
 	opts := options{
-		port:     ":8080",
-		logger:   common.NewLogger(),
-		semantic: AtLeastOnce,
+		port:            ":8080",
+		logger:          common.NewLogger(),
+		semantic:        AtMostOnce,
+		methodSemantics: make(map[string]Semantics),
+		replyTTL:        5 * time.Minute,
+		replyMaxEntries: 10000,
+		replyMaxBytes:   64 << 20,
 	}
 	// Apply options
 	for _, o := range opt {
 		o(&opts)
 	}
 
-	switch opts.semantic {
-	case AtMostOnce:
-		return newAtMostOnce(opts)
-	default:
-		return newAtLeastOnce(opts)
+	// The semantic of non idempotent methods may only be made stricter than at-most-once
+	if opts.semantic == AtLeastOnce {
+		opts.logger.Fatal("Non idempotent methods are not executed at-least-once by default, override methods with WithMethodSemantic to opt in")
+	}
+
+	s := new(Server)
+	s.opts = opts
+	s.logger = opts.logger
+	s.inflight = make(map[ReplyKey]chan struct{})
+	// Panics are recovered and requests logged before other interceptors
+	s.rpc = rpc.New(opts.flightRepo, opts.reservationRepo, opts.deadline, s.push,
+		rpc.WithStreamInterceptors(rpc.Recovery(s.logger), rpc.Logging(s.logger)),
+		rpc.WithStreamInterceptors(opts.stream...),
+		rpc.WithUnaryInterceptors(opts.unary...),
+	)
+	s.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	s.lossRate = opts.lossRate
+
+	// Replies of at-most-once and exactly-once methods
+	s.replies = NewReplyCache(opts.replyTTL, opts.replyMaxEntries, opts.replyMaxBytes)
+	if opts.replyLog != "" {
+		replies, err := OpenReplyCache(opts.replyLog, opts.replyTTL, opts.replyMaxEntries, opts.replyMaxBytes)
+		if err != nil {
+			s.logger.WithError(err).Fatal("Unable to open reply log")
+		}
+		s.replies = replies
+		s.logger.Info(fmt.Sprintf("Restored %v replies from %v", replies.Stats().Entries, opts.replyLog))
+	}
+
+	// Journal mutations if any method commits them with its reply
+	for _, semantic := range s.MethodSemantics() {
+		if semantic != ExactlyOnce {
+			continue
+		}
+		if err := s.openJournal(); err != nil {
+			s.logger.WithError(err).Fatal("Exactly-once semantics requires a data store journal")
+		}
+		s.logger.Info(fmt.Sprintf("Restored %v replies from %v", s.replies.Stats().Entries, opts.journal))
+		break
 	}
+	return s
 }
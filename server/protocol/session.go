@@ -28,6 +28,16 @@ type Session struct {
 	// Writes request monotonic increasing
 	requestID uint32
 
+	// Request sequence monotonic increasing. Carried by SYN frames
+	// and shared by retries of a request
+	requestSeq uint32
+
+	// Callback for acknowledged replies
+	ackHandler func(addr *net.UDPAddr, sid []byte, seq uint16)
+
+	// Callback for canceled requests
+	cancelHandler func(addr *net.UDPAddr, sid []byte, seq uint16)
+
 	// UDP connection that defines the transport layer
 	conn *net.UDPConn
 
@@ -44,8 +54,29 @@ type Session struct {
 	// Stores all active streams
 	streams map[string]*Stream
 
-	// Channel for outgoing writes
-	chWrites chan writeRequest
+	// Mutex for endpoints
+	endpointLock sync.Mutex
+
+	// Remote endpoints that have opened a stream with the session.
+	// Used to open server initiated streams towards a client
+	endpoints map[string]*net.UDPAddr
+
+	// Stream options of the last stream opened by each endpoint.
+	// Server initiated streams towards an endpoint are opened with them
+	endpointOptions map[string]byte
+
+	// Options of streams opened by the session e.g. SynCompact
+	options byte
+
+	// Round trip estimates of peers
+	rttLock sync.Mutex
+	rtt     map[string]*rttEstimator
+
+	// Scheduler for outgoing writes
+	sched *scheduler
+
+	// Intercepts outgoing frames. Used to simulate an unreliable network
+	writeHook atomic.Value
 
 	// Defines the maximum frame size for transport
 	maxFrameSize int
@@ -67,9 +98,14 @@ type Session struct {
 // writeRequest frame channel pair
 type writeRequest struct {
 	frame  Frame
+	addr   *net.UDPAddr
 	result chan writeResult
 }
 
+// WriteHook intercepts an outgoing frame. The hook sends the frame by calling
+// write and may drop, delay or repeat it. write may be called from any goroutine.
+type WriteHook func(f Frame, write func(Frame))
+
 // writeResult n written error pair
 type writeResult struct {
 	n   int
@@ -84,9 +120,12 @@ func NewSession(conn *net.UDPConn, client bool) *Session {
 	s.logger = logrus.New()
 	s.maxFrameSize = 1500
 	s.streams = make(map[string]*Stream)
+	s.endpoints = make(map[string]*net.UDPAddr)
+	s.endpointOptions = make(map[string]byte)
+	s.rtt = make(map[string]*rttEstimator)
 
 	s.chDie = make(chan struct{})
-	s.chWrites = make(chan writeRequest)
+	s.sched = newScheduler()
 	s.chStreamAccept = make(chan *Stream, 1500)
 	s.chSocketReadError = make(chan struct{})
 	s.chSocketWriteError = make(chan struct{})
@@ -141,40 +180,156 @@ func (s *Session) OpenWithExisting(addr *net.UDPAddr, old *Stream) (*Stream, err
 		return nil, io.ErrClosedPipe
 	}
 	stream := NewStream(s, old.sid, s.requestID, s.maxFrameSize, addr)
+	stream.seq = old.seq
+	stream.options = old.options
 	return s.open(stream)
 }
 
 // Open opens a new stream that generates a new SID
 func (s *Session) Open(addr *net.UDPAddr) (*Stream, error) {
+	return s.openWithOptions(addr, s.options)
+}
+
+// openWithOptions opens a new stream with SYN options
+func (s *Session) openWithOptions(addr *net.UDPAddr, options byte) (*Stream, error) {
 	if s.IsClosed() {
 		return nil, io.ErrClosedPipe
 	}
 
 	sid := uuid.New()
 	stream := NewStream(s, sid[:], s.requestID, s.maxFrameSize, addr)
+	stream.seq = uint16(atomic.AddUint32(&s.requestSeq, 1))
+	stream.options = options
 	return s.open(stream)
 }
 
-// open opens a new stream and adds it to the session
-func (s *Session) open(stream *Stream) (*Stream, error) {
-	if _, err := s.writeFrame(NewFrame(SYN, stream.sid, stream.rid, 0), time.After(OpenCloseTimeout)); err != nil {
-		return stream, err
+// SetCompact sets if streams opened by the session negotiate the compact encoding mode.
+// The peer must support stream options.
+func (s *Session) SetCompact(compact bool) {
+	if compact {
+		s.options |= SynCompact
+	} else {
+		s.options &^= SynCompact
 	}
+}
+
+// OnAck sets the callback invoked when a peer acknowledges a reply.
+// Must be called before the session is started.
+func (s *Session) OnAck(fn func(addr *net.UDPAddr, sid []byte, seq uint16)) {
+	s.ackHandler = fn
+}
+
+// OnCancel sets the callback invoked when a peer cancels a request.
+// The context of the stream of the request is canceled before the callback is invoked.
+// Must be called before the session is started.
+func (s *Session) OnCancel(fn func(addr *net.UDPAddr, sid []byte, seq uint16)) {
+	s.cancelHandler = fn
+}
 
+// OpenTo opens a server initiated stream towards a registered endpoint.
+// An endpoint is registered once it has opened a stream with the session.
+// The stream takes the options of the last stream opened by the endpoint.
+func (s *Session) OpenTo(endpoint string) (*Stream, error) {
+	if s.IsClosed() {
+		return nil, io.ErrClosedPipe
+	}
+
+	s.endpointLock.Lock()
+	addr, ok := s.endpoints[endpoint]
+	options := s.endpointOptions[endpoint]
+	s.endpointLock.Unlock()
+	if !ok {
+		return nil, ErrUnknownEndpoint
+	}
+	return s.openWithOptions(addr, options)
+}
+
+// Endpoint returns the address of a registered endpoint
+func (s *Session) Endpoint(endpoint string) (*net.UDPAddr, bool) {
+	s.endpointLock.Lock()
+	defer s.endpointLock.Unlock()
+	addr, ok := s.endpoints[endpoint]
+	return addr, ok
+}
+
+// registerEndpoint records the remote address of a peer and its stream options
+func (s *Session) registerEndpoint(addr *net.UDPAddr, options byte) {
+	if addr == nil {
+		return
+	}
+	s.endpointLock.Lock()
+	s.endpoints[addr.String()] = addr
+	s.endpointOptions[addr.String()] = options
+	s.endpointLock.Unlock()
+}
+
+// RTO returns the retransmission timeout of a peer.
+// initial is returned if no round trip has been sampled for the peer.
+func (s *Session) RTO(addr *net.UDPAddr, initial time.Duration) time.Duration {
+	stats, ok := s.RTTStats(addr)
+	if !ok {
+		return initial
+	}
+	return stats.RTO
+}
+
+// RTTStats returns the round trip estimates of a peer
+func (s *Session) RTTStats(addr *net.UDPAddr) (RTTStats, bool) {
+	s.rttLock.Lock()
+	e, ok := s.rtt[addr.String()]
+	s.rttLock.Unlock()
+	if !ok {
+		return RTTStats{}, false
+	}
+	return e.get(), true
+}
+
+// observeRTT records a round trip sample of a peer
+func (s *Session) observeRTT(addr *net.UDPAddr, r time.Duration) {
+	if addr == nil {
+		return
+	}
+	s.rttLock.Lock()
+	e, ok := s.rtt[addr.String()]
+	if !ok {
+		e = new(rttEstimator)
+		s.rtt[addr.String()] = e
+	}
+	s.rttLock.Unlock()
+	e.observe(r)
+}
+
+// open opens a new stream and adds it to the session
+func (s *Session) open(stream *Stream) (*Stream, error) {
+	// The stream is added before SYN is sent as a server session
+	// resolves the remote address of outgoing frames from its streams
 	s.streamLock.Lock()
-	defer s.streamLock.Unlock()
 	select {
 	case <-s.chDie:
+		s.streamLock.Unlock()
 		return stream, io.ErrClosedPipe
 	case <-s.chSocketReadError:
+		s.streamLock.Unlock()
 		return stream, s.socketReadError.Load().(error)
 	case <-s.chProtoError:
+		s.streamLock.Unlock()
 		return stream, s.protoError.Load().(error)
 	default:
-		s.streams[fmt.Sprintf("%v%v", stream.sid, stream.rid)] = stream
+		s.streams[stream.SIDRID()] = stream
 		atomic.AddUint32(&s.requestID, 1)
-		return stream, nil
 	}
+	s.streamLock.Unlock()
+
+	syn := NewFrame(SYN, stream.sid, stream.rid, stream.seq)
+	// Peers without stream options receive a SYN frame without data
+	if stream.options != 0 {
+		syn.Data = []byte{stream.options}
+	}
+	if _, err := s.writeFrame(syn, stream.addr, time.After(OpenCloseTimeout)); err != nil {
+		s.streamClosed(stream.sid, stream.rid)
+		return stream, err
+	}
+	return stream, nil
 }
 
 // IsClosed returns true if the session is closed
@@ -212,10 +367,17 @@ func (s *Session) recv() {
 		// Switch case to handle different frame types
 		switch hdr.Flag() {
 		case SYN:
+			var options byte
+			if hdr.Length() > 0 {
+				options = b[HeaderSize]
+			}
+			s.registerEndpoint(addr, options)
 			s.streamLock.Lock()
 			// Create new stream
 			if _, ok := s.streams[sidRid]; !ok {
 				stream := NewStream(s, sid, rid, s.maxFrameSize, addr)
+				stream.seq = seqId
+				stream.options = options
 				s.streams[sidRid] = stream
 				select {
 				case <-s.chDie:
@@ -232,6 +394,7 @@ func (s *Session) recv() {
 				newbuf := make([]byte, int(hdr.Length()))
 				copy(newbuf, b[HeaderSize:HeaderSize+int(hdr.Length())])
 				stream.pushBytes(seqId, newbuf)
+				stream.sampleRTT()
 				// atomic.AddInt32(&s.bucket, -int32(written))
 				stream.notifyReadEvent()
 			}
@@ -251,6 +414,19 @@ func (s *Session) recv() {
 				stream.notifyReadEvent()
 			}
 			s.streamLock.Unlock()
+		case ACK:
+			if s.ackHandler != nil {
+				s.ackHandler(addr, append([]byte(nil), sid...), seqId)
+			}
+		case RST:
+			s.streamLock.Lock()
+			if stream, ok := s.streams[sidRid]; ok {
+				stream.peerCancel()
+			}
+			s.streamLock.Unlock()
+			if s.cancelHandler != nil {
+				s.cancelHandler(addr, append([]byte(nil), sid...), seqId)
+			}
 		case NOP:
 		default:
 			s.notifyProtoError(ErrInvalidProtocol)
@@ -289,8 +465,6 @@ func (s *Session) notifyProtoError(err error) {
 
 func (s *Session) send() {
 	var buf []byte
-	var n int
-	var err error
 
 	// 2^16 + 7 buffer size
 	buf = make([]byte, (1<<16)+HeaderSize)
@@ -299,39 +473,17 @@ func (s *Session) send() {
 		select {
 		case <-s.chDie:
 			return
-		case request := <-s.chWrites:
-			header := request.frame.Header()
-			copy(buf[:HeaderSize], header[:])
-			copy(buf[HeaderSize:], request.frame.Data)
-
-			if s.client {
-				s.conn.Write(buf[:HeaderSize+len(request.frame.Data)])
-			} else {
-				// Retrieve the stream
-				stream, ok := s.streams[fmt.Sprintf("%v%v", request.frame.Sid, request.frame.Rid)]
-				if ok {
-					s.conn.WriteToUDP(buf[:HeaderSize+len(request.frame.Data)], stream.addr)
-				} else {
-					// Stream does not exist
-					n, err = 0, errors.New("Stream not found. Might have been closed")
-				}
-			}
-
-			n -= HeaderSize
-			if n < 0 {
-				n = 0
-			}
+		case <-s.sched.chReady:
+		}
 
-			result := writeResult{
-				n:   n,
-				err: err,
+		// Drain frames in scheduled order
+		for {
+			request, ok := s.sched.pop()
+			if !ok {
+				break
 			}
-
-			request.result <- result
-			close(request.result)
-
-			// notify connection write error
-			if err != nil {
+			if err := s.transmit(buf, request); err != nil {
+				// notify connection write error
 				s.notifyWriteError(err)
 				return
 			}
@@ -339,31 +491,111 @@ func (s *Session) send() {
 	}
 }
 
-// writeFrame writes a frame to session write background goroutine
-func (s *Session) writeFrame(f Frame, deadline <-chan time.Time) (n int, err error) {
-	req := writeRequest{
-		frame:  f,
-		result: make(chan writeResult, 1),
+// SetWriteHook sets the hook intercepting outgoing frames
+func (s *Session) SetWriteHook(hook WriteHook) {
+	s.writeHook.Store(hook)
+}
+
+// transmit writes the frame of request to the connection and notifies the result
+func (s *Session) transmit(buf []byte, request writeRequest) error {
+	var n int
+	var err error
+
+	if !s.client && request.addr == nil {
+		// Stream does not exist
+		n, err = 0, errors.New("Stream not found. Might have been closed")
+	} else if hook, _ := s.writeHook.Load().(WriteHook); hook != nil {
+		// Frames written by the hook may outlive buf
+		hook(request.frame, func(f Frame) {
+			s.writeTo(make([]byte, HeaderSize+len(f.Data)), f, request.addr)
+		})
+	} else {
+		s.writeTo(buf, request.frame, request.addr)
 	}
 
-	select {
-	case s.chWrites <- req:
-	case <-s.chDie:
-		return 0, io.ErrClosedPipe
-	case <-s.chSocketWriteError:
-		return 0, s.socketWriteError.Load().(error)
-	case <-deadline:
-		return 0, ErrTimeout
+	n -= HeaderSize
+	if n < 0 {
+		n = 0
+	}
+
+	request.result <- writeResult{
+		n:   n,
+		err: err,
+	}
+	close(request.result)
+	return err
+}
+
+// writeTo writes frame f to addr using buf
+func (s *Session) writeTo(buf []byte, f Frame, addr *net.UDPAddr) {
+	header := f.Header()
+	copy(buf[:HeaderSize], header[:])
+	copy(buf[HeaderSize:], f.Data)
+
+	if s.client {
+		s.conn.Write(buf[:HeaderSize+len(f.Data)])
+	} else {
+		s.conn.WriteToUDP(buf[:HeaderSize+len(f.Data)], addr)
+	}
+}
+
+// writeFrame writes a control frame to session write background goroutine.
+// addr is the remote address of the stream and is ignored by client sessions.
+func (s *Session) writeFrame(f Frame, addr *net.UDPAddr, deadline <-chan time.Time) (n int, err error) {
+	return s.writeFrames([]Frame{f}, addr, PriorityControl, deadline, nil)
+}
+
+// writeFrames queues frames of a stream to the scheduler and blocks until
+// every frame is written. Data frames are scheduled under priority while
+// other frames are scheduled as control frames. Frames still queued once
+// the deadline passes or closed is closed are dropped from the scheduler.
+func (s *Session) writeFrames(frames []Frame, addr *net.UDPAddr, priority Priority, deadline <-chan time.Time, closed <-chan struct{}) (n int, err error) {
+	if len(frames) == 0 {
+		return 0, nil
 	}
 
 	select {
-	case result := <-req.result:
-		return result.n, result.err
 	case <-s.chDie:
 		return 0, io.ErrClosedPipe
 	case <-s.chSocketWriteError:
 		return 0, s.socketWriteError.Load().(error)
-	case <-deadline:
-		return 0, ErrTimeout
+	default:
+	}
+
+	reqs := make([]writeRequest, len(frames))
+	for i, f := range frames {
+		reqs[i] = writeRequest{
+			frame:  f,
+			addr:   addr,
+			result: make(chan writeResult, 1),
+		}
+	}
+
+	p := priority
+	if frames[0].Flag != PSH {
+		p = PriorityControl
+	}
+	key := fmt.Sprintf("%v%v", frames[0].Sid, frames[0].Rid)
+	s.sched.push(key, p, reqs...)
+
+	for i, req := range reqs {
+		select {
+		case result := <-req.result:
+			n += result.n
+			if result.err != nil {
+				return n, result.err
+			}
+		case <-s.chDie:
+			return n, io.ErrClosedPipe
+		case <-s.chSocketWriteError:
+			return n, s.socketWriteError.Load().(error)
+		case <-deadline:
+			s.sched.remove(key, reqs[i:])
+			return n, ErrTimeout
+		case <-closed:
+			s.sched.remove(key, reqs[i:])
+			return n, io.ErrClosedPipe
+		}
 	}
+	return n, nil
 }
@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -27,12 +28,33 @@ type Stream struct {
 
 	sid []byte
 
+	// Request sequence of the client session
+	seq uint16
+
+	// Options negotiated by the SYN frame e.g. SynCompact
+	options byte
+
 	session *Session
 
 	addr *net.UDPAddr
 
 	frameSize int
 
+	// Scheduling class of data frames
+	priority atomic.Value
+
+	// Time the last write completed and if a round trip has been sampled.
+	// A stream carries a single transmission of a request as retries are made
+	// on new streams, hence samples are never taken from retransmissions.
+	sentAt  atomic.Value
+	sampled int32
+
+	// Context of the request on the stream. Canceled once the peer
+	// cancels the request or the stream is closed
+	ctx      context.Context
+	cancel   context.CancelFunc
+	canceled int32
+
 	buffers []*ByteSeq
 
 	bufferMux sync.Mutex
@@ -53,6 +75,8 @@ var (
 	ErrGoAway          = errors.New("stream id overflows, should start a new connection")
 	ErrTimeout         = errors.New("timeout")
 	ErrMayBlock        = errors.New("op may block on IO")
+	ErrUnknownEndpoint = errors.New("endpoint not registered with session")
+	ErrCanceled        = errors.New("request canceled by peer")
 )
 
 // NewStream creates a new stream
@@ -67,9 +91,30 @@ func NewStream(sess *Session, sid []byte, rid uint32, frameSize int, addr *net.U
 	s.chAck = make(chan struct{}, 1) // limit to 1
 	s.chFin = make(chan struct{})
 	s.chDie = make(chan struct{})
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.priority.Store(PriorityBulk)
 	return s
 }
 
+// Compact returns true if data of the stream is encoded in the compact encoding mode
+func (s *Stream) Compact() bool {
+	return s.options&SynCompact != 0
+}
+
+// SetPriority sets the scheduling class of data frames written to the stream.
+// Control frames are always scheduled as PriorityControl.
+func (s *Stream) SetPriority(p Priority) {
+	if int(p) >= numPriorities {
+		p = PriorityBulk
+	}
+	s.priority.Store(p)
+}
+
+// Priority returns the scheduling class of data frames written to the stream
+func (s *Stream) Priority() Priority {
+	return s.priority.Load().(Priority)
+}
+
 func (s *Stream) SetReadDeadline(t time.Time) error {
 	s.rDeadline.Store(t)
 	s.notifyReadEvent()
@@ -93,6 +138,36 @@ func (s *Stream) RID() uint32 {
 	return s.rid
 }
 
+// Seq returns the request sequence of the stream
+func (s *Stream) Seq() uint16 {
+	return s.seq
+}
+
+// Ack acknowledges the reply of the stream so the peer may release it
+func (s *Stream) Ack() error {
+	_, err := s.session.writeFrame(NewFrame(ACK, s.sid, s.rid, s.seq), s.addr, time.After(OpenCloseTimeout))
+	return err
+}
+
+// Cancel asks the peer to abandon the request of the stream and any work it retains.
+// Cancel may be called once the stream is closed.
+func (s *Stream) Cancel() error {
+	_, err := s.session.writeFrame(NewFrame(RST, s.sid, s.rid, s.seq), s.addr, time.After(OpenCloseTimeout))
+	return err
+}
+
+// Context returns the context of the request on the stream.
+// The context is canceled once the peer cancels the request or the stream is closed.
+func (s *Stream) Context() context.Context {
+	return s.ctx
+}
+
+// peerCancel cancels the request of the stream on behalf of the peer
+func (s *Stream) peerCancel() {
+	atomic.StoreInt32(&s.canceled, 1)
+	s.cancel()
+}
+
 // SIDRID returns the concatenation of sid rid
 // Used to identify a unique stream
 func (s *Stream) SIDRID() string {
@@ -101,8 +176,9 @@ func (s *Stream) SIDRID() string {
 
 func (s *Stream) Close() error {
 	close(s.chDie)
+	s.cancel()
 
-	_, err := s.session.writeFrame(NewFrame(FIN, s.sid, s.rid, 0), time.After(OpenCloseTimeout))
+	_, err := s.session.writeFrame(NewFrame(FIN, s.sid, s.rid, 0), s.addr, time.After(OpenCloseTimeout))
 	s.session.streamClosed(s.sid, s.rid)
 	if err != nil {
 		return err
@@ -199,6 +275,11 @@ func (s *Stream) waitRead() error {
 		return ErrTimeout
 	case <-s.chDie:
 		return io.ErrClosedPipe
+	case <-s.ctx.Done():
+		if atomic.LoadInt32(&s.canceled) == 1 {
+			return ErrCanceled
+		}
+		return io.ErrClosedPipe
 	case <-s.session.chSocketReadError:
 		return s.session.socketReadError.Load().(error)
 	case <-s.session.chProtoError:
@@ -246,11 +327,12 @@ func (s *Stream) Write(b []byte) (n int, err error) {
 	default:
 	}
 
-	// frame split and transmit
-	sent := 0
+	// frame split and transmit.
+	// Frames are queued together so the session may interleave them
+	// with frames of other streams.
+	frames := []Frame{}
 	seq := uint16(0)
 
-	frame := NewFrame(PSH, s.sid, s.rid, seq)
 	bts := b
 	for len(bts) > 0 {
 		size := len(bts)
@@ -258,28 +340,40 @@ func (s *Stream) Write(b []byte) (n int, err error) {
 		if size > s.frameSize {
 			size = s.frameSize
 		}
+		frame := NewFrame(PSH, s.sid, s.rid, seq)
 		frame.Data = bts[:size]
-		frame.SeqId = seq
 		bts = bts[size:]
-		n, err := s.session.writeFrame(frame, deadline)
-
+		frames = append(frames, frame)
 		seq += 1
-		sent += n
+	}
 
-		if err != nil {
-			return sent, err
-		}
+	sent, err := s.session.writeFrames(frames, s.addr, s.Priority(), deadline, s.chDie)
+	if err != nil {
+		return sent, err
 	}
+
 	// Finish write with DNE
-	n, err = s.session.writeFrame(NewFrame(DNE, s.sid, s.rid, 0), time.After(OpenCloseTimeout))
+	n, err = s.session.writeFrame(NewFrame(DNE, s.sid, s.rid, 0), s.addr, time.After(OpenCloseTimeout))
 	sent += n
 	if err != nil {
 		return sent, err
 	}
+	s.sentAt.Store(time.Now())
 
 	return sent, nil
 }
 
+// sampleRTT records the round trip of the first response frame after a write
+func (s *Stream) sampleRTT() {
+	sentAt, ok := s.sentAt.Load().(time.Time)
+	if !ok {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&s.sampled, 0, 1) {
+		s.session.observeRTT(s.addr, time.Since(sentAt))
+	}
+}
+
 func (s *Stream) fin() {
 	close(s.chFin)
 }
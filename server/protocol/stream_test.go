@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestSessions starts a server session and a client session connected to it
+func newTestSessions(t *testing.T) (*Session, *Session) {
+	sconn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cconn, err := net.DialUDP("udp", nil, sconn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := NewSession(sconn, false), NewSession(cconn, true)
+	server.Start()
+	client.Start()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+		sconn.Close()
+		cconn.Close()
+	})
+	return server, client
+}
+
+// TestCancel verifies an RST cancels the context of the stream of the peer
+// and fails its reads with ErrCanceled
+func TestCancel(t *testing.T) {
+	server, client := newTestSessions(t)
+	canceled := make(chan uint16, 1)
+	server.OnCancel(func(addr *net.UDPAddr, sid []byte, seq uint16) {
+		canceled <- seq
+	})
+
+	stream, err := client.Open(server.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted, err := server.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if accepted.Context().Err() != nil {
+		t.Fatal("context canceled before RST")
+	}
+
+	if err = stream.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case seq := <-canceled:
+		if seq != stream.Seq() {
+			t.Fatalf("canceled request %v, want %v", seq, stream.Seq())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("RST not received")
+	}
+	select {
+	case <-accepted.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("context not canceled")
+	}
+	if _, err = accepted.Read(make([]byte, 16)); err != ErrCanceled {
+		t.Fatalf("got %v, want %v", err, ErrCanceled)
+	}
+
+	// Contexts canceled by closing the stream are not reported as canceled by the peer
+	closed := NewStream(server, []byte("closed"), 0, server.maxFrameSize, nil)
+	closed.cancel()
+	if err = closed.waitRead(); err != io.ErrClosedPipe {
+		t.Fatalf("got %v, want %v", err, io.ErrClosedPipe)
+	}
+}
@@ -0,0 +1,57 @@
+package rpc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/isaiahwong/cz4013/encoding"
+)
+
+// TestSelfDescribing verifies self-describing values decode to their type and without it
+func TestSelfDescribing(t *testing.T) {
+	opts := []encoding.Option{encoding.WithFormat(encoding.SelfDescribing)}
+
+	for _, v := range []interface{}{testMessage(), testReserveFlight(), []*Flight{testFlight(), nil}} {
+		b, err := marshal(v, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := reflect.New(reflect.TypeOf(v))
+		if err = encoding.Unmarshal(b, got.Interface(), opts...); err != nil {
+			t.Fatalf("%T: %v", v, err)
+		}
+		if !reflect.DeepEqual(got.Elem().Interface(), v) {
+			t.Fatalf("%T: got %+v, want %+v", v, got.Elem().Interface(), v)
+		}
+	}
+
+	b, err := encoding.Marshal(testReserveFlight(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := encoding.UnmarshalAny(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"ID": "513a3af1-7f50-46ae-8030-4982f30b920a",
+		"Flight": map[string]interface{}{
+			"ID":              int64(6734),
+			"Source":          "Phoenix",
+			"Destination":     "San Antonio",
+			"Airfare":         float32(499),
+			"SeatAvailablity": int64(66),
+			"Timestamp":       uint64(1681260739),
+		},
+		"SeatReserved": int64(2),
+		"CheckIn":      true,
+		"Cancelled":    false,
+		"Meals": []interface{}{
+			map[string]interface{}{"ID": int64(0), "Name": "Steak"},
+			map[string]interface{}{"ID": int64(2), "Name": "Wine"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+}
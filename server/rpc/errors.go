@@ -3,6 +3,5 @@ package rpc
 import "errors"
 
 var (
-	ErrFailCast           = errors.New("Failed to cast")
-	ErrOverrideMonitoring = errors.New("Override Monitoring")
+	ErrFailCast = errors.New("Failed to cast")
 )
@@ -0,0 +1,466 @@
+// Code generated by czidl from flight.idl. DO NOT EDIT.
+
+package rpc
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+
+	"github.com/isaiahwong/cz4013/encoding"
+)
+
+// Methods of the FlightSystem service
+const (
+	MethodFindFlights    = "FindFlights"
+	MethodFindFlight     = "FindFlight"
+	MethodReserveFlight  = "ReserveFlight"
+	MethodMonitorUpdates = "MonitorUpdates"
+	MethodCheckInFlight  = "CheckInFlight"
+	MethodGetMeals       = "GetMeals"
+	MethodAddMeals       = "AddMeals"
+	MethodCancelFlight   = "CancelFlight"
+)
+
+// Flight is a scheduled flight and its seats
+type Flight struct {
+	ID              int32
+	Source          string
+	Destination     string
+	Airfare         float32
+	SeatAvailablity int32
+	Timestamp       uint32
+}
+
+// Food is a meal that can be added to a reservation
+type Food struct {
+	ID   int32
+	Name string
+}
+
+// ReserveFlight is a reservation of seats of a flight
+type ReserveFlight struct {
+	ID           string
+	Flight       *Flight
+	SeatReserved int32
+	CheckIn      bool
+	Cancelled    bool
+	Meals        []*Food
+}
+
+// FindFlightsRequest is the request of FindFlights sent as query params
+type FindFlightsRequest struct {
+	Source      string
+	Destination string
+}
+
+// Query returns the query params of the request
+func (x *FindFlightsRequest) Query() map[string]string {
+	q := make(map[string]string)
+	q["source"] = x.Source
+	q["destination"] = x.Destination
+	return q
+}
+
+// ParseQuery sets the request from query params. Empty params are missing
+func (x *FindFlightsRequest) ParseQuery(q map[string]string) error {
+	if v := q["source"]; v == "" {
+		return invalidParam("source", nil)
+	} else {
+		x.Source = v
+	}
+	if v := q["destination"]; v == "" {
+		return invalidParam("destination", nil)
+	} else {
+		x.Destination = v
+	}
+	return nil
+}
+
+// FindFlightRequest is the request of FindFlight sent as query params
+type FindFlightRequest struct {
+	ID int32
+}
+
+// Query returns the query params of the request
+func (x *FindFlightRequest) Query() map[string]string {
+	q := make(map[string]string)
+	q["id"] = strconv.FormatInt(int64(x.ID), 10)
+	return q
+}
+
+// ParseQuery sets the request from query params. Empty params are missing
+func (x *FindFlightRequest) ParseQuery(q map[string]string) error {
+	if v := q["id"]; v == "" {
+		return invalidParam("id", nil)
+	} else {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return invalidParam("id", err)
+		}
+		x.ID = int32(n)
+	}
+	return nil
+}
+
+// ReserveFlightRequest is the request of ReserveFlight sent as query params
+type ReserveFlightRequest struct {
+	ID    int32
+	Seats int32
+}
+
+// Query returns the query params of the request
+func (x *ReserveFlightRequest) Query() map[string]string {
+	q := make(map[string]string)
+	q["id"] = strconv.FormatInt(int64(x.ID), 10)
+	q["seats"] = strconv.FormatInt(int64(x.Seats), 10)
+	return q
+}
+
+// ParseQuery sets the request from query params. Empty params are missing
+func (x *ReserveFlightRequest) ParseQuery(q map[string]string) error {
+	if v := q["id"]; v == "" {
+		return invalidParam("id", nil)
+	} else {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return invalidParam("id", err)
+		}
+		x.ID = int32(n)
+	}
+	if v := q["seats"]; v == "" {
+		return invalidParam("seats", nil)
+	} else {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return invalidParam("seats", err)
+		}
+		x.Seats = int32(n)
+	}
+	return nil
+}
+
+// MonitorUpdatesRequest is the request of MonitorUpdates sent as query params
+type MonitorUpdatesRequest struct {
+	Timestamp int64
+	ID        *int32 // Optional
+}
+
+// Query returns the query params of the request
+func (x *MonitorUpdatesRequest) Query() map[string]string {
+	q := make(map[string]string)
+	q["timestamp"] = strconv.FormatInt(int64(x.Timestamp), 10)
+	if x.ID != nil {
+		q["id"] = strconv.FormatInt(int64(*x.ID), 10)
+	}
+	return q
+}
+
+// ParseQuery sets the request from query params. Empty params are missing
+func (x *MonitorUpdatesRequest) ParseQuery(q map[string]string) error {
+	if v := q["timestamp"]; v == "" {
+		return invalidParam("timestamp", nil)
+	} else {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return invalidParam("timestamp", err)
+		}
+		x.Timestamp = int64(n)
+	}
+	if v := q["id"]; v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return invalidParam("id", err)
+		}
+		x.ID = new(int32)
+		*x.ID = int32(n)
+	}
+	return nil
+}
+
+// CheckInFlightRequest is the request of CheckInFlight sent as query params
+type CheckInFlightRequest struct {
+	ID string
+}
+
+// Query returns the query params of the request
+func (x *CheckInFlightRequest) Query() map[string]string {
+	q := make(map[string]string)
+	q["id"] = x.ID
+	return q
+}
+
+// ParseQuery sets the request from query params. Empty params are missing
+func (x *CheckInFlightRequest) ParseQuery(q map[string]string) error {
+	if v := q["id"]; v == "" {
+		return invalidParam("id", nil)
+	} else {
+		x.ID = v
+	}
+	return nil
+}
+
+// GetMealsRequest is the request of GetMeals sent as query params
+type GetMealsRequest struct {
+}
+
+// Query returns the query params of the request
+func (x *GetMealsRequest) Query() map[string]string {
+	q := make(map[string]string)
+	return q
+}
+
+// ParseQuery sets the request from query params. Empty params are missing
+func (x *GetMealsRequest) ParseQuery(q map[string]string) error {
+	return nil
+}
+
+// AddMealsRequest is the request of AddMeals sent as query params
+type AddMealsRequest struct {
+	ID     string
+	MealID int32
+}
+
+// Query returns the query params of the request
+func (x *AddMealsRequest) Query() map[string]string {
+	q := make(map[string]string)
+	q["id"] = x.ID
+	q["meal_id"] = strconv.FormatInt(int64(x.MealID), 10)
+	return q
+}
+
+// ParseQuery sets the request from query params. Empty params are missing
+func (x *AddMealsRequest) ParseQuery(q map[string]string) error {
+	if v := q["id"]; v == "" {
+		return invalidParam("id", nil)
+	} else {
+		x.ID = v
+	}
+	if v := q["meal_id"]; v == "" {
+		return invalidParam("meal_id", nil)
+	} else {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			return invalidParam("meal_id", err)
+		}
+		x.MealID = int32(n)
+	}
+	return nil
+}
+
+// CancelFlightRequest is the request of CancelFlight sent as query params
+type CancelFlightRequest struct {
+	ID string
+}
+
+// Query returns the query params of the request
+func (x *CancelFlightRequest) Query() map[string]string {
+	q := make(map[string]string)
+	q["id"] = x.ID
+	return q
+}
+
+// ParseQuery sets the request from query params. Empty params are missing
+func (x *CancelFlightRequest) ParseQuery(q map[string]string) error {
+	if v := q["id"]; v == "" {
+		return invalidParam("id", nil)
+	} else {
+		x.ID = v
+	}
+	return nil
+}
+
+// FlightSystemServer handles the methods of the FlightSystem service
+type FlightSystemServer interface {
+	// FindFlights finds flights whose source and destination match the patterns
+	FindFlights(ctx context.Context, req *FindFlightsRequest) ([]*Flight, error)
+
+	// FindFlight finds a flight by id
+	FindFlight(ctx context.Context, req *FindFlightRequest) (*Flight, error)
+
+	// ReserveFlight reserves seats of a flight
+	ReserveFlight(ctx context.Context, req *ReserveFlightRequest) (*ReserveFlight, error)
+
+	// MonitorUpdates pushes updates of a flight, or of every flight without an id,
+	// until timestamp in unix milliseconds or microseconds
+	MonitorUpdates(ctx context.Context, req *MonitorUpdatesRequest) error
+
+	// CheckInFlight checks in a reservation
+	CheckInFlight(ctx context.Context, req *CheckInFlightRequest) (*ReserveFlight, error)
+
+	// GetMeals lists the meals
+	GetMeals(ctx context.Context, req *GetMealsRequest) ([]*Food, error)
+
+	// AddMeals adds a meal to a reservation
+	AddMeals(ctx context.Context, req *AddMealsRequest) (*ReserveFlight, error)
+
+	// CancelFlight cancels a reservation and releases its seats
+	CancelFlight(ctx context.Context, req *CancelFlightRequest) (*ReserveFlight, error)
+}
+
+// RegisterFlightSystemServer registers the methods of the FlightSystem service handled by srv
+func RegisterFlightSystemServer(r *RPC, srv FlightSystemServer) {
+	Register(r, MethodFindFlights, srv.FindFlights, WithIdempotency(ReadOnly))
+	Register(r, MethodFindFlight, srv.FindFlight, WithIdempotency(ReadOnly))
+	Register(r, MethodReserveFlight, srv.ReserveFlight, WithIdempotency(NonIdempotent))
+	Register(r, MethodMonitorUpdates, func(ctx context.Context, req *MonitorUpdatesRequest) (Empty, error) {
+		return Empty{}, srv.MonitorUpdates(ctx, req)
+	}, WithIdempotency(NonIdempotent), WithLossless())
+	Register(r, MethodCheckInFlight, srv.CheckInFlight, WithIdempotency(Idempotent))
+	Register(r, MethodGetMeals, srv.GetMeals, WithIdempotency(ReadOnly))
+	Register(r, MethodAddMeals, srv.AddMeals, WithIdempotency(NonIdempotent))
+	Register(r, MethodCancelFlight, srv.CancelFlight, WithIdempotency(Idempotent))
+}
+
+// FlightSystemService describes the FlightSystem service
+var FlightSystemService = ServiceDesc{
+	Name: "FlightSystem",
+	Methods: []MethodDesc{
+		{
+			Name:        MethodFindFlights,
+			Idempotency: ReadOnly,
+			Params: []ParamDesc{
+				{Name: "source", Type: "string"},
+				{Name: "destination", Type: "string"},
+			},
+			Request:  reflect.TypeOf((*FindFlightsRequest)(nil)).Elem(),
+			Response: reflect.TypeOf((*[]*Flight)(nil)).Elem(),
+		},
+		{
+			Name:        MethodFindFlight,
+			Idempotency: ReadOnly,
+			Params: []ParamDesc{
+				{Name: "id", Type: "int32"},
+			},
+			Request:  reflect.TypeOf((*FindFlightRequest)(nil)).Elem(),
+			Response: reflect.TypeOf((**Flight)(nil)).Elem(),
+		},
+		{
+			Name:        MethodReserveFlight,
+			Idempotency: NonIdempotent,
+			Params: []ParamDesc{
+				{Name: "id", Type: "int32"},
+				{Name: "seats", Type: "int32"},
+			},
+			Request:  reflect.TypeOf((*ReserveFlightRequest)(nil)).Elem(),
+			Response: reflect.TypeOf((**ReserveFlight)(nil)).Elem(),
+		},
+		{
+			Name:        MethodMonitorUpdates,
+			Idempotency: NonIdempotent,
+			Params: []ParamDesc{
+				{Name: "timestamp", Type: "int64"},
+				{Name: "id", Type: "int32", Optional: true},
+			},
+			Request: reflect.TypeOf((*MonitorUpdatesRequest)(nil)).Elem(),
+			Push:    reflect.TypeOf((**Flight)(nil)).Elem(),
+		},
+		{
+			Name:        MethodCheckInFlight,
+			Idempotency: Idempotent,
+			Params: []ParamDesc{
+				{Name: "id", Type: "string"},
+			},
+			Request:  reflect.TypeOf((*CheckInFlightRequest)(nil)).Elem(),
+			Response: reflect.TypeOf((**ReserveFlight)(nil)).Elem(),
+		},
+		{
+			Name:        MethodGetMeals,
+			Idempotency: ReadOnly,
+			Request:     reflect.TypeOf((*GetMealsRequest)(nil)).Elem(),
+			Response:    reflect.TypeOf((*[]*Food)(nil)).Elem(),
+		},
+		{
+			Name:        MethodAddMeals,
+			Idempotency: NonIdempotent,
+			Params: []ParamDesc{
+				{Name: "id", Type: "string"},
+				{Name: "meal_id", Type: "int32"},
+			},
+			Request:  reflect.TypeOf((*AddMealsRequest)(nil)).Elem(),
+			Response: reflect.TypeOf((**ReserveFlight)(nil)).Elem(),
+		},
+		{
+			Name:        MethodCancelFlight,
+			Idempotency: Idempotent,
+			Params: []ParamDesc{
+				{Name: "id", Type: "string"},
+			},
+			Request:  reflect.TypeOf((*CancelFlightRequest)(nil)).Elem(),
+			Response: reflect.TypeOf((**ReserveFlight)(nil)).Elem(),
+		},
+	},
+}
+
+// FlightSystemClient calls the methods of the FlightSystem service through an Invoker
+type FlightSystemClient struct {
+	invoker Invoker
+	opts    []encoding.Option
+}
+
+// NewFlightSystemClient returns a client of invoker decoding replies with opts
+func NewFlightSystemClient(invoker Invoker, opts ...encoding.Option) *FlightSystemClient {
+	return &FlightSystemClient{invoker: invoker, opts: opts}
+}
+
+// FindFlights finds flights whose source and destination match the patterns
+func (c *FlightSystemClient) FindFlights(ctx context.Context, in *FindFlightsRequest) ([]*Flight, error) {
+	var out []*Flight
+	err := invoke(ctx, c.invoker, MethodFindFlights, in.Query(), &out, c.opts)
+	return out, err
+}
+
+// FindFlight finds a flight by id
+func (c *FlightSystemClient) FindFlight(ctx context.Context, in *FindFlightRequest) (*Flight, error) {
+	out := new(Flight)
+	err := invoke(ctx, c.invoker, MethodFindFlight, in.Query(), out, c.opts)
+	return out, err
+}
+
+// ReserveFlight reserves seats of a flight
+func (c *FlightSystemClient) ReserveFlight(ctx context.Context, in *ReserveFlightRequest) (*ReserveFlight, error) {
+	out := new(ReserveFlight)
+	err := invoke(ctx, c.invoker, MethodReserveFlight, in.Query(), out, c.opts)
+	return out, err
+}
+
+// MonitorUpdates pushes updates of a flight, or of every flight without an id,
+// until timestamp in unix milliseconds or microseconds
+func (c *FlightSystemClient) MonitorUpdates(ctx context.Context, in *MonitorUpdatesRequest) error {
+	return invoke(ctx, c.invoker, MethodMonitorUpdates, in.Query(), nil, c.opts)
+}
+
+// DecodeMonitorUpdates decodes a body pushed by MonitorUpdates
+func (c *FlightSystemClient) DecodeMonitorUpdates(body []byte) (*Flight, error) {
+	out := new(Flight)
+	err := encoding.Unmarshal(body, out, c.opts...)
+	return out, err
+}
+
+// CheckInFlight checks in a reservation
+func (c *FlightSystemClient) CheckInFlight(ctx context.Context, in *CheckInFlightRequest) (*ReserveFlight, error) {
+	out := new(ReserveFlight)
+	err := invoke(ctx, c.invoker, MethodCheckInFlight, in.Query(), out, c.opts)
+	return out, err
+}
+
+// GetMeals lists the meals
+func (c *FlightSystemClient) GetMeals(ctx context.Context, in *GetMealsRequest) ([]*Food, error) {
+	var out []*Food
+	err := invoke(ctx, c.invoker, MethodGetMeals, in.Query(), &out, c.opts)
+	return out, err
+}
+
+// AddMeals adds a meal to a reservation
+func (c *FlightSystemClient) AddMeals(ctx context.Context, in *AddMealsRequest) (*ReserveFlight, error) {
+	out := new(ReserveFlight)
+	err := invoke(ctx, c.invoker, MethodAddMeals, in.Query(), out, c.opts)
+	return out, err
+}
+
+// CancelFlight cancels a reservation and releases its seats
+func (c *FlightSystemClient) CancelFlight(ctx context.Context, in *CancelFlightRequest) (*ReserveFlight, error) {
+	out := new(ReserveFlight)
+	err := invoke(ctx, c.invoker, MethodCancelFlight, in.Query(), out, c.opts)
+	return out, err
+}
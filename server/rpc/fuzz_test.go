@@ -0,0 +1,129 @@
+package rpc
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/isaiahwong/cz4013/encoding"
+)
+
+// fuzzOptions returns the encoding options selected by mode
+func fuzzOptions(mode uint8) []encoding.Option {
+	format := encoding.Positional
+	if mode&2 != 0 {
+		format = encoding.TLV
+	}
+	if mode&4 != 0 {
+		format = encoding.SelfDescribing
+	}
+	return []encoding.Option{encoding.WithCompact(mode&1 != 0), encoding.WithFormat(format)}
+}
+
+// marshal encodes v. Slices are decoded through a pointer and encoded as the slice
+func marshal(v interface{}, opts []encoding.Option) ([]byte, error) {
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Slice {
+		v = rv.Elem().Interface()
+	}
+	return encoding.Marshal(v, opts...)
+}
+
+// fuzzUnmarshal fuzzes Unmarshal of the values returned by newV. Seeds are
+// the encodings of seed in every mode. Decoded values must encode to an
+// encoding that decodes and encodes to the same bytes, or to the same value
+// as maps are encoded in random order.
+func fuzzUnmarshal(f *testing.F, seed interface{}, newV func() interface{}) {
+	for mode := uint8(0); mode < 8; mode++ {
+		b, err := encoding.Marshal(seed, fuzzOptions(mode)...)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b, mode)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, mode uint8) {
+		opts := fuzzOptions(mode)
+		v := newV()
+		if err := encoding.Unmarshal(data, v, opts...); err != nil {
+			return
+		}
+		b, err := marshal(v, opts)
+		if err != nil {
+			t.Fatalf("marshal of decoded value: %v", err)
+		}
+		again := newV()
+		if err = encoding.Unmarshal(b, again, opts...); err != nil {
+			t.Fatalf("unmarshal of encoded value: %v", err)
+		}
+		c, err := marshal(again, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, c) && !reflect.DeepEqual(v, again) {
+			t.Fatalf("round trip differs\n got: %x\nwant: %x", c, b)
+		}
+	})
+}
+
+func FuzzUnmarshalMessage(f *testing.F) {
+	fuzzUnmarshal(f, testMessage(), func() interface{} { return new(Message) })
+}
+
+func FuzzUnmarshalError(f *testing.F) {
+	fuzzUnmarshal(f, testMessage().Error, func() interface{} { return new(Error) })
+}
+
+func FuzzUnmarshalFlight(f *testing.F) {
+	fuzzUnmarshal(f, testFlight(), func() interface{} { return new(Flight) })
+}
+
+func FuzzUnmarshalFlights(f *testing.F) {
+	flights := []*Flight{testFlight(), nil, testFlight()}
+	fuzzUnmarshal(f, flights, func() interface{} { return new([]*Flight) })
+}
+
+func FuzzUnmarshalReserveFlight(f *testing.F) {
+	fuzzUnmarshal(f, testReserveFlight(), func() interface{} { return new(ReserveFlight) })
+}
+
+func FuzzUnmarshalFood(f *testing.F) {
+	fuzzUnmarshal(f, testReserveFlight().Meals, func() interface{} { return new([]*Food) })
+}
+
+// TestLimits verifies forged lengths fail with a LimitError before allocating
+func TestLimits(t *testing.T) {
+	// Message with an empty RPC and a query of 2^62 entries
+	forged := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x40}
+	err := encoding.Unmarshal(forged, new(Message))
+	if !errors.Is(err, encoding.ErrMaxElements) {
+		t.Fatalf("forged map length: got %v, want %v", err, encoding.ErrMaxElements)
+	}
+
+	b, err := encoding.Marshal(testReserveFlight())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		limits encoding.Limits
+		want   error
+	}{
+		{"bytes", encoding.Limits{MaxBytes: len(b) - 1}, encoding.ErrMaxBytes},
+		{"elements", encoding.Limits{MaxElements: 1}, encoding.ErrMaxElements},
+		{"depth", encoding.Limits{MaxDepth: 1}, encoding.ErrMaxDepth},
+		{"within", encoding.Limits{MaxBytes: len(b), MaxElements: 2, MaxDepth: 3}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := encoding.Unmarshal(b, new(ReserveFlight), encoding.WithLimits(c.limits))
+			if c.want == nil && err != nil || !errors.Is(err, c.want) {
+				t.Fatalf("got %v, want %v", err, c.want)
+			}
+			var limitErr *encoding.LimitError
+			if c.want != nil && !errors.As(err, &limitErr) {
+				t.Fatalf("%T is not a LimitError", err)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strconv"
@@ -8,8 +9,6 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/isaiahwong/cz4013/common"
-
-	"github.com/isaiahwong/cz4013/encoding"
 )
 
 var (
@@ -23,25 +22,11 @@ var (
 )
 
 // FindFlights finds flights from source to destination. This is an idempotent method
-// Takes in `source` and `destination` as its query params
-func (r *RPC) FindFlights(m *Message, read Readable, write Writable) error {
-	method := "FindFlights"
-	lossy := true
-
+func (r *RPC) FindFlights(ctx context.Context, req *FindFlightsRequest) ([]*Flight, error) {
 	// Retrieve all flights
 	flights, err := r.flightRepo.GetAll()
 	if err != nil {
-		return r.error(method, err, "", read, write)
-	}
-
-	// Process query params
-	src, ok := m.Query["source"]
-	if !ok || src == "" {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", src), read, write)
-	}
-	dest, ok := m.Query["destination"]
-	if !ok || dest == "" {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", dest), read, write)
+		return nil, err
 	}
 
 	filteredFlights := []*Flight{}
@@ -60,387 +45,195 @@ func (r *RPC) FindFlights(m *Message, read Readable, write Writable) error {
 
 	// flight flights base on predicates
 	for _, f := range flights {
-		if sourceCriteria(src, f) && destCriteria(dest, f) {
+		if sourceCriteria(req.Source, f) && destCriteria(req.Destination, f) {
 			filteredFlights = append(filteredFlights, f)
 		}
 	}
 
 	if len(filteredFlights) == 0 {
-		return r.error(method, ErrNoFlightsFound, fmt.Sprintf("No flights found from %v to %v", src, dest), read, write)
+		return nil, fmt.Errorf("%w: No flights found from %v to %v", ErrNoFlightsFound, req.Source, req.Destination)
 	}
-
-	// Marshal response
-	b, err := encoding.Marshal(filteredFlights)
-	if err != nil {
-		return r.error(method, err, "", read, write)
-	}
-	return r.ok(method, b, lossy, read, write)
+	return filteredFlights, nil
 }
 
-// FindFlight finds a flight by `id` in query params. This is an idempotent method
-func (r *RPC) FindFlight(m *Message, read Readable, write Writable) error {
-	method := "FindFlight"
-	lossy := true
-
-	id, ok := m.Query["id"]
-	if !ok || id == "" {
-		return r.error(method, ErrInvalidParams, "", read, write)
-	}
-
-	// Converts query param to int
-	idInt, err := strconv.ParseInt(id, 10, 64)
-	if err != nil {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", idInt), read, write)
-	}
-
+// FindFlight finds a flight by id. This is an idempotent method
+func (r *RPC) FindFlight(ctx context.Context, req *FindFlightRequest) (*Flight, error) {
 	// Finds a flight by id
-	flight, err := r.flightRepo.FindByID(int32(idInt))
-
+	flight, err := r.flightRepo.FindByID(req.ID)
 	if err != nil {
-		return r.error(method, err, "", read, write)
+		return nil, err
 	}
 	if flight == nil {
-		return r.error(method, ErrNoFlightFound, fmt.Sprintf("No flights found with %v", idInt), read, write)
+		return nil, fmt.Errorf("%w: No flights found with %v", ErrNoFlightFound, req.ID)
 	}
-
-	// Marshal response
-	b, err := encoding.Marshal(flight)
-	if err != nil {
-		return r.error(method, err, "", read, write)
-	}
-	return r.ok(method, b, lossy, read, write)
+	return flight, nil
 }
 
-// ReserveFlight reserves a flight by `id` in query params. This is a non-idempotent method
-func (r *RPC) ReserveFlight(m *Message, read Readable, write Writable) error {
-	method := "ReserveFlight"
-	lossy := true
-
-	id, ok := m.Query["id"]
-	if !ok || id == "" {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", id), read, write)
-	}
-
-	seats, ok := m.Query["seats"]
-	if !ok || id == "" {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", id), read, write)
-	}
-
-	// Convert id to int
-	idInt, err := strconv.ParseInt(id, 10, 64)
-	if err != nil {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", idInt), read, write)
-	}
-
-	// Convert seats to int
-	seatsInt, err := strconv.ParseInt(seats, 10, 64)
-	if err != nil || seatsInt <= 0 {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", seatsInt), read, write)
-	}
-	seatsInt32 := int32(seatsInt)
-
+// ReserveFlight reserves seats of a flight by id. This is a non-idempotent method.
+// Requests are validated by ReserveFlightRequest.Validate before the handler runs
+func (r *RPC) ReserveFlight(ctx context.Context, req *ReserveFlightRequest) (*ReserveFlight, error) {
 	// Find flight by id from repo
-	flight, err := r.flightRepo.FindByID(int32(idInt))
+	flight, err := r.flightRepo.FindByID(req.ID)
 	if err != nil {
-		return r.error(method, err, "", read, write)
+		return nil, err
 	}
 	if flight == nil {
-		return r.error(method, ErrNoFlightFound, fmt.Sprintf("No flights found with %v", idInt), read, write)
+		return nil, fmt.Errorf("%w: No flights found with %v", ErrNoFlightFound, req.ID)
 	}
 
 	// Check if flight has enough seats to reserve
-	if flight.SeatAvailablity-seatsInt32 < 0 {
-		return r.error(method, ErrFailToReserve, fmt.Sprintf("Not enough seats to reserve for flight %v", idInt), read, write)
+	if flight.SeatAvailablity-req.Seats < 0 {
+		return nil, fmt.Errorf("%w: Not enough seats to reserve for flight %v", ErrFailToReserve, req.ID)
 	}
 
 	// Update flight seat availability
-	flight.SeatAvailablity -= seatsInt32
+	flight.SeatAvailablity -= req.Seats
 
 	// Update flight in repo
 	if err = r.flightRepo.Update(flight); err != nil {
-		return r.error(method, ErrFailToReserve, err.Error(), read, write)
+		return nil, fmt.Errorf("%w: %v", ErrFailToReserve, err)
 	}
 
 	reserve := &ReserveFlight{
 		ID:           uuid.NewString(),
 		Flight:       flight,
-		SeatReserved: seatsInt32,
+		SeatReserved: req.Seats,
 		CheckIn:      false,
 	}
 
 	// Create a reservation in repo
 	if err = r.reservationRepo.Insert(reserve); err != nil {
-		return r.error(method, ErrFailToReserve, err.Error(), read, write)
+		return nil, fmt.Errorf("%w: %v", ErrFailToReserve, err)
 	}
 
 	// Broadcast flight updates to listening channels
 	r.broadcastFlights(flight)
-
-	b, err := encoding.Marshal(reserve)
-	if err != nil {
-		return r.error(method, ErrInternalError, err.Error(), read, write)
-	}
-
-	return r.ok(method, b, lossy, read, write)
+	return reserve, nil
 }
 
-// CheckInFlight checks in a flight by `id` in query params. This is an idempotent method
-func (r *RPC) CheckInFlight(m *Message, read Readable, write Writable) error {
-	method := "CheckInFlight"
-	lossy := true
-
-	id, ok := m.Query["id"]
-	if !ok || id == "" {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", id), read, write)
-	}
-
+// CheckInFlight checks in a reservation by id. This is an idempotent method
+func (r *RPC) CheckInFlight(ctx context.Context, req *CheckInFlightRequest) (*ReserveFlight, error) {
 	// Retrieve reservation
-	rf, err := r.reservationRepo.FindByID(id)
-	if err != nil {
-		return r.error(method, ErrNoReserveFlightFound, "", read, write)
-	}
-
-	// Marshal function to return response
-	marshal := func(reserve *ReserveFlight) error {
-		b, err := encoding.Marshal(rf)
-		if err != nil {
-			return r.error(method, ErrInternalError, err.Error(), read, write)
-		}
-		return r.ok(method, b, lossy, read, write)
+	rf, err := r.reservationRepo.FindByID(req.ID)
+	if err != nil || rf == nil {
+		return nil, ErrNoReserveFlightFound
 	}
 
 	// returns if already checked in
 	if rf.CheckIn {
-		return marshal(rf)
+		return rf, nil
 	}
 
 	// Update reservation
 	rf.CheckIn = true
 	if err = r.reservationRepo.Update(rf); err != nil {
-		return r.error(method, ErrInternalError, err.Error(), read, write)
+		return nil, fmt.Errorf("%w: %v", ErrInternalError, err)
 	}
-	return marshal(rf)
+	return rf, nil
 }
 
 // GetMeals returns a list of meals. This is an idempotent method
-func (r *RPC) GetMeals(read Readable, write Writable) error {
-	method := "GetMeals"
+func (r *RPC) GetMeals(ctx context.Context, req *GetMealsRequest) ([]*Food, error) {
 	meals := GetFood()
-	lossy := true
 
 	// convert meals to list
 	mealList := []*Food{}
 	for _, meal := range meals {
 		mealList = append(mealList, meal)
 	}
-
-	b, err := encoding.Marshal(mealList)
-	if err != nil {
-		return r.error(method, ErrInternalError, err.Error(), read, write)
-	}
-
-	return r.ok(method, b, lossy, read, write)
+	return mealList, nil
 }
 
-// AddMeals adds a meal to the list of meals. This is a non-idempotent method
-func (r *RPC) AddMeals(m *Message, read Readable, write Writable) error {
-	method := "AddMeals"
-	lossy := true
-
-	id, ok := m.Query["id"]
-	if !ok || id == "" {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", id), read, write)
-	}
-
-	mealIdStr, ok := m.Query["meal_id"]
-	if !ok || id == "" {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", id), read, write)
-	}
-
-	mealId, err := strconv.ParseInt(mealIdStr, 10, 64)
-	if err != nil {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", id), read, write)
-	}
-
+// AddMeals adds a meal to a reservation by id. This is a non-idempotent method
+func (r *RPC) AddMeals(ctx context.Context, req *AddMealsRequest) (*ReserveFlight, error) {
 	// Retrieve reservation
-	rf, err := r.reservationRepo.FindByID(id)
+	rf, _ := r.reservationRepo.FindByID(req.ID)
 	if rf == nil {
-		return r.error(method, ErrNoReserveFlightFound, fmt.Sprintf("No reservation found with %v", id), read, write)
+		return nil, fmt.Errorf("%w: No reservation found with %v", ErrNoReserveFlightFound, req.ID)
 	}
 
 	meals := GetFood()
-	meal, ok := meals[int32(mealId)]
+	meal, ok := meals[req.MealID]
 	if !ok {
-		return r.error(method, ErrMealsNotFound, fmt.Sprintf("Meal not found with %v", mealIdStr), read, write)
+		return nil, fmt.Errorf("%w: Meal not found with %v", ErrMealsNotFound, req.MealID)
 	}
 
 	rf.Meals = append(rf.Meals, meal)
-	if err = r.reservationRepo.Update(rf); err != nil {
-		return r.error(method, ErrInternalError, err.Error(), read, write)
+	if err := r.reservationRepo.Update(rf); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternalError, err)
 	}
-
-	b, err := encoding.Marshal(rf)
-	if err != nil {
-		return r.error(method, ErrInternalError, err.Error(), read, write)
-	}
-	return r.ok(method, b, lossy, read, write)
+	return rf, nil
 }
 
-// CancelFlight cancels a flight by `id` in query params. This is an idempotent method
-func (r *RPC) CancelFlight(m *Message, read Readable, write Writable) error {
-	method := "CancelFlight"
-	lossy := true
-
-	res := func(rf *ReserveFlight) error {
-		b, err := encoding.Marshal(rf)
-		if err != nil {
-			return r.error(method, ErrInternalError, err.Error(), read, write)
-		}
-		return r.ok(method, b, lossy, read, write)
-	}
-
-	id, ok := m.Query["id"]
-	if !ok || id == "" {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", id), read, write)
-	}
-
+// CancelFlight cancels a reservation by id. This is an idempotent method
+func (r *RPC) CancelFlight(ctx context.Context, req *CancelFlightRequest) (*ReserveFlight, error) {
 	// Retrieve reservation
-	rf, err := r.reservationRepo.FindByID(id)
+	rf, err := r.reservationRepo.FindByID(req.ID)
 	if err != nil {
-		return r.error(method, ErrInternalError, err.Error(), read, write)
+		return nil, fmt.Errorf("%w: %v", ErrInternalError, err)
 	}
 	if rf == nil {
-		return r.error(method, ErrNoReserveFlightFound, fmt.Sprintf("No reservation found with %v", id), read, write)
+		return nil, fmt.Errorf("%w: No reservation found with %v", ErrNoReserveFlightFound, req.ID)
 	}
 
 	// Return if already cancelled
 	if rf.Cancelled {
-		return res(rf)
+		return rf, nil
 	}
 
 	// Prevent cancellation for checked in flights
 	if rf.CheckIn {
-		return r.error(method, ErrNoReserveFlightFound, "Can't cancel. Reservation checked in", read, write)
+		return nil, fmt.Errorf("%w: Can't cancel. Reservation checked in", ErrNoReserveFlightFound)
 	}
 
 	// Retrieve flight
 	flight, err := r.flightRepo.FindByID(rf.Flight.ID)
 	if err != nil {
-		return r.error(method, ErrInternalError, err.Error(), read, write)
+		return nil, fmt.Errorf("%w: %v", ErrInternalError, err)
 	}
 	if flight == nil {
-		return r.error(method, ErrNoFlightFound, "No flights associated with reserve flight ", read, write)
+		return nil, fmt.Errorf("%w: No flights associated with reserve flight", ErrNoFlightFound)
 	}
 
 	rf.Cancelled = true
 	// Update reservation
-	err = r.reservationRepo.Update(rf)
-	if err != nil {
-		return r.error(method, ErrInternalError, err.Error(), read, write)
+	if err = r.reservationRepo.Update(rf); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInternalError, err)
 	}
 
 	// Update seats availability
 	flight.SeatAvailablity += rf.SeatReserved
 	if err = r.flightRepo.Update(flight); err != nil {
-		return r.error(method, ErrInternalError, err.Error(), read, write)
+		return nil, fmt.Errorf("%w: %v", ErrInternalError, err)
 	}
 
 	r.broadcastFlights(flight)
-
-	return res(rf)
+	return rf, nil
 }
 
-// MonitorUpdates monitors flight updates for a given timestamp deadline in query params. This is a non-idempotent method
-// Caveat for this method is that if a client disconnect, it will not immediately delete the channel
-func (r *RPC) MonitorUpdates(addr string, m *Message, read Readable, write Writable) error {
-	method := "MonitorUpdates"
-	// We ensure MonitorUpdates is not susceptible to frame drops
-	lossy := false
-	t, ok := m.Query["timestamp"]
-	if !ok || t == "" {
-		return r.error(method, ErrInvalidParams, fmt.Sprintf("%v: is invalid", t), read, write)
-	}
-
-	id, ok := m.Query["id"]
+// MonitorUpdates subscribes the client endpoint to flight updates until the timestamp deadline.
+// Updates are pushed on server initiated streams, hence the request stream is released once subscribed.
+// The subscription is released early once the client cancels the request.
+// This is a non-idempotent method
+func (r *RPC) MonitorUpdates(ctx context.Context, req *MonitorUpdatesRequest) error {
 	fid := int32(-1)
-	if ok && t != "" {
-		fid64, err := strconv.ParseInt(id, 10, 32)
-		if err == nil {
-			fid = int32(fid64)
-		}
+	if req.ID != nil {
+		fid = *req.ID
 	}
 
-	// convert string unix timestamp to time.Time
-	monitorUntil, err := common.StrToUnixTime(t)
+	// convert unix timestamp to time.Time
+	monitorUntil, err := common.StrToUnixTime(strconv.FormatInt(req.Timestamp, 10))
 	if err != nil {
-		return r.error(method, ErrInvalidParams, err.Error(), read, write)
+		return invalidParam("timestamp", err)
 	}
 
-	// Create channel
-	if fCh, ok := r.chFlightUpdates[addr]; ok {
-		fCh <- &FlightChannel{
-			release: true,
-		}
-	}
-
-	r.chFlightUpdatesMux.Lock()
-	fCh := make(chan *FlightChannel)
-	r.chFlightUpdates[addr] = fCh
-	r.chFlightUpdatesMux.Unlock()
-
 	r.logger.Info("Current Time : ", time.Now().Local().Format(time.RFC3339))
 	r.logger.Info("Deadline     : ", monitorUntil.Local().Format(time.RFC3339))
 
-	duration := time.Until(*monitorUntil)
-
-	broadcast := func() error {
-		// Listen for updates
-		for {
-			select {
-			// Listen for updates via flight channel
-			case flightCh := <-fCh:
-				if flightCh.release {
-					return ErrOverrideMonitoring
-				}
-
-				flight := flightCh.flight
-				if flight == nil {
-					continue
-				}
-
-				if fid != -1 && fid != flight.ID {
-					continue
-				}
-
-				// Updates client
-				b, err := encoding.Marshal(flight)
-				if err != nil {
-					return r.error(method, err, "", read, write)
-				}
-				r.ok(method, b, lossy, read, write)
-
-			// Listens for deadline
-			case <-time.After(duration):
-				return nil
-			}
-		}
-	}
-
-	err = broadcast()
-	if err != nil && err != ErrOverrideMonitoring {
-		return err
-	}
-
-	// Don't remove channel if client is still connected
-	if err != ErrOverrideMonitoring {
-		// Remove channel
-		r.chFlightUpdatesMux.Lock()
-		// Remove via index
-		delete(r.chFlightUpdates, addr)
-		r.chFlightUpdatesMux.Unlock()
-		r.logger.Info(fmt.Sprintf("Released: %v", addr))
-	} else {
-		r.logger.Info(fmt.Sprintf("Monitor Override: %v", addr))
+	served, ok := RequestFrom(ctx)
+	if !ok {
+		return fmt.Errorf("%w: MonitorUpdates is not served over a transport", ErrInternalError)
 	}
-	return r.ok(method, []byte{}, lossy, read, write)
+	r.subscribe(ctx, served.Peer, fid, *monitorUntil, served.Encoding)
+	return nil
 }
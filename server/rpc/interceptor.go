@@ -0,0 +1,81 @@
+package rpc
+
+import (
+	"context"
+)
+
+// MethodInfo describes the method of an intercepted request
+type MethodInfo struct {
+	Name        string
+	Idempotency Idempotency
+	// Whether the method is registered. Requests of unknown methods are
+	// only seen by stream interceptors
+	Registered bool
+}
+
+// UnaryHandler handles a decoded request and returns its reply
+type UnaryHandler func(ctx context.Context, req interface{}) (interface{}, error)
+
+// UnaryInterceptor intercepts a request decoded by Register before its handler.
+// req is a pointer to the request type of the method. The interceptor continues
+// the chain by calling handler and returns the reply or an error replied to the client.
+type UnaryInterceptor func(ctx context.Context, req interface{}, info *MethodInfo, handler UnaryHandler) (interface{}, error)
+
+// StreamHandler serves a request read from its stream and writes its replies to w
+type StreamHandler func(req *Request, w ResponseWriter) error
+
+// StreamInterceptor intercepts a request read from its stream before it is routed
+// and decoded. It sees requests of every method and may wrap w to observe replies.
+// The interceptor continues the chain by calling handler.
+type StreamInterceptor func(req *Request, w ResponseWriter, info *MethodInfo, handler StreamHandler) error
+
+// Option sets options of an RPC
+type Option func(*RPC)
+
+// WithUnaryInterceptors returns an Option which appends unary interceptors.
+// The first interceptor is the outermost.
+func WithUnaryInterceptors(interceptors ...UnaryInterceptor) Option {
+	return func(r *RPC) {
+		r.unary = append(r.unary, interceptors...)
+	}
+}
+
+// WithStreamInterceptors returns an Option which appends stream interceptors.
+// The first interceptor is the outermost.
+func WithStreamInterceptors(interceptors ...StreamInterceptor) Option {
+	return func(r *RPC) {
+		r.stream = append(r.stream, interceptors...)
+	}
+}
+
+// info returns the description of method name
+func (r *RPC) info(name string) *MethodInfo {
+	info := &MethodInfo{Name: name, Idempotency: NonIdempotent}
+	if m, ok := r.methods[name]; ok {
+		info.Idempotency = m.idempotency
+		info.Registered = true
+	}
+	return info
+}
+
+// interceptUnary calls handler through the unary interceptors
+func (r *RPC) interceptUnary(ctx context.Context, req interface{}, info *MethodInfo, handler UnaryHandler) (interface{}, error) {
+	for i := len(r.unary) - 1; i >= 0; i-- {
+		interceptor, next := r.unary[i], handler
+		handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+			return interceptor(ctx, req, info, next)
+		}
+	}
+	return handler(ctx, req)
+}
+
+// interceptStream calls handler through the stream interceptors
+func (r *RPC) interceptStream(req *Request, w ResponseWriter, info *MethodInfo, handler StreamHandler) error {
+	for i := len(r.stream) - 1; i >= 0; i-- {
+		interceptor, next := r.stream[i], handler
+		handler = func(req *Request, w ResponseWriter) error {
+			return interceptor(req, w, info, next)
+		}
+	}
+	return handler(req, w)
+}
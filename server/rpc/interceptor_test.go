@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestInterceptors verifies interceptors run in order and replies of the built-in interceptors
+func TestInterceptors(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	order := []string{}
+	trace := func(name string) UnaryInterceptor {
+		return func(ctx context.Context, req interface{}, info *MethodInfo, handler UnaryHandler) (interface{}, error) {
+			order = append(order, name)
+			return handler(ctx, req)
+		}
+	}
+	metrics := NewMetrics()
+	r := &RPC{logger: logger, methods: make(map[string]*method)}
+	for _, opt := range []Option{
+		WithStreamInterceptors(Recovery(logger), Logging(logger)),
+		WithUnaryInterceptors(trace("outer"), trace("inner"), metrics.Interceptor(), RateLimit(0, 2), Validation()),
+	} {
+		opt(r)
+	}
+
+	Register(r, MethodReserveFlight, func(ctx context.Context, req *ReserveFlightRequest) (*ReserveFlight, error) {
+		order = append(order, "handler")
+		return &ReserveFlight{ID: "1", SeatReserved: req.Seats}, nil
+	})
+
+	l := NewLoopback(r, "peer")
+	client := NewFlightSystemClient(l)
+	if _, err := client.ReserveFlight(context.Background(), &ReserveFlightRequest{ID: 1, Seats: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"outer", "inner", "handler"}; len(order) != len(want) || order[0] != want[0] || order[1] != want[1] || order[2] != want[2] {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+
+	// Invalid requests are replied by Validation
+	reply, err := l.Call(context.Background(), MethodReserveFlight, map[string]string{"id": "1", "seats": "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error == nil || reply.Error.Error != ErrInvalidParams.Error() {
+		t.Fatalf("got %+v", reply.Error)
+	}
+
+	// The bucket of 2 requests is empty
+	reply, err = l.Call(context.Background(), MethodReserveFlight, map[string]string{"id": "0", "seats": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error == nil || reply.Error.Error != ErrRateLimited.Error() {
+		t.Fatalf("got %+v", reply.Error)
+	}
+
+	stats := metrics.Stats()[MethodReserveFlight]
+	if stats.Calls != 3 || stats.Errors != 2 {
+		t.Fatalf("got %+v", stats)
+	}
+
+	// Panics are replied by Recovery
+	r = &RPC{logger: logger, methods: make(map[string]*method), stream: r.stream}
+	Register(r, MethodGetMeals, func(ctx context.Context, req *GetMealsRequest) ([]*Food, error) {
+		panic("no meals")
+	})
+	reply, err = NewLoopback(r, "peer").Call(context.Background(), MethodGetMeals, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error == nil || reply.Error.Error != ErrInternalError.Error() {
+		t.Fatalf("got %+v", reply.Error)
+	}
+}
+
+// TestRateLimit verifies buckets are refilled at the rate and dropped once
+// idle long enough to be full
+func TestRateLimit(t *testing.T) {
+	l := &limiter{rate: 1, burst: 2, buckets: make(map[string]*bucket)}
+	now := time.Now()
+	for i, want := range []bool{true, true, false} {
+		if l.allow("a", now) != want {
+			t.Fatalf("request %v: want allowed %v", i, want)
+		}
+	}
+	if !l.allow("b", now) || !l.allow("a", now.Add(time.Second)) {
+		t.Fatal("bucket not refilled")
+	}
+
+	// a is drained by now + 1s and refilled by now + 3s, b is refilled by now + 2s
+	l.allow("c", now.Add(2*time.Second))
+	if _, ok := l.buckets["b"]; ok || len(l.buckets) != 2 {
+		t.Fatalf("got %v buckets, want a and c", len(l.buckets))
+	}
+	l.allow("c", now.Add(4*time.Second))
+	if _, ok := l.buckets["c"]; !ok || len(l.buckets) != 1 {
+		t.Fatalf("got %v buckets, want c", len(l.buckets))
+	}
+
+	// Buckets are never refilled at a rate of 0
+	l = &limiter{burst: 1, buckets: make(map[string]*bucket)}
+	if !l.allow("a", now) || l.allow("a", now.Add(time.Hour)) {
+		t.Fatal("bucket refilled at a rate of 0")
+	}
+}
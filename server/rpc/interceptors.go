@@ -0,0 +1,230 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrUnauthorized = errors.New("Unauthorized")
+	ErrRateLimited  = errors.New("Rate limited")
+)
+
+// Logging returns a StreamInterceptor logging requests and their error replies
+func Logging(logger *logrus.Logger) StreamInterceptor {
+	return func(req *Request, w ResponseWriter, info *MethodInfo, handler StreamHandler) error {
+		logger.Info(fmt.Sprintf("[%v] - %v", info.Name, req.Peer))
+		start := time.Now()
+		err := handler(req, &loggingWriter{ResponseWriter: w, logger: logger, req: req, start: start})
+		if err != nil {
+			logger.WithError(err).Error(fmt.Sprintf("[%v] - %v failed", info.Name, req.Peer))
+		}
+		return err
+	}
+}
+
+// loggingWriter logs error replies
+type loggingWriter struct {
+	ResponseWriter
+	logger *logrus.Logger
+	req    *Request
+	start  time.Time
+}
+
+func (w *loggingWriter) WriteMessage(m *Message, lossy bool) error {
+	if m.Error != nil {
+		w.logger.WithFields(logrus.Fields{
+			"error":   m.Error.Error,
+			"body":    m.Error.Body,
+			"elapsed": time.Since(w.start),
+		}).Warn(fmt.Sprintf("[%v] - %v replied with error", w.req.Method(), w.req.Peer))
+	}
+	return w.ResponseWriter.WriteMessage(m, lossy)
+}
+
+// Recovery returns a StreamInterceptor replying ErrInternalError to requests
+// whose handler panics instead of crashing the server
+func Recovery(logger *logrus.Logger) StreamInterceptor {
+	return func(req *Request, w ResponseWriter, info *MethodInfo, handler StreamHandler) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				logger.Error(fmt.Sprintf("[%v] - Recovered from panic: %v\n%s", info.Name, p, debug.Stack()))
+				err = w.WriteMessage(NewError(info.Name, ErrInternalError, ""), false)
+			}
+		}()
+		return handler(req, w)
+	}
+}
+
+// Validator is a request validating its params beyond their types
+type Validator interface {
+	Validate() error
+}
+
+// validate returns the error of req if it is a Validator and invalid
+func validate(req interface{}) error {
+	if v, ok := req.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// Validation returns a UnaryInterceptor replying the error of requests that are
+// Validators and invalid. Register always validates requests before their handler,
+// Validation rejects them earlier in the chain of interceptors.
+func Validation() UnaryInterceptor {
+	return func(ctx context.Context, req interface{}, info *MethodInfo, handler UnaryHandler) (interface{}, error) {
+		if err := validate(req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Authorize returns a UnaryInterceptor replying ErrUnauthorized to requests
+// for which authorize returns an error. The Request is carried by ctx, see RequestFrom.
+func Authorize(authorize func(ctx context.Context, info *MethodInfo) error) UnaryInterceptor {
+	return func(ctx context.Context, req interface{}, info *MethodInfo, handler UnaryHandler) (interface{}, error) {
+		if err := authorize(ctx, info); err != nil {
+			if errors.Is(err, ErrUnauthorized) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// RateLimit returns a UnaryInterceptor limiting the requests of each client
+// endpoint to rate per second with bursts of up to burst requests.
+// Requests beyond the limit are replied ErrRateLimited.
+func RateLimit(rate float64, burst int) UnaryInterceptor {
+	l := &limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+	return func(ctx context.Context, req interface{}, info *MethodInfo, handler UnaryHandler) (interface{}, error) {
+		peer := ""
+		if served, ok := RequestFrom(ctx); ok {
+			peer = served.Peer
+		}
+		if !l.allow(peer, time.Now()) {
+			return nil, fmt.Errorf("%w: Too many requests from %v", ErrRateLimited, peer)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// limiter is a token bucket per client endpoint.
+// Buckets idle long enough to be refilled are dropped as a new bucket is full,
+// hence buckets never refilled at a rate of 0 are kept.
+type limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token of peer at now if there is one
+func (l *limiter) allow(peer string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate > 0 && now.Sub(l.swept).Seconds()*l.rate >= l.burst {
+		l.expire(now)
+	}
+
+	b, ok := l.buckets[peer]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[peer] = b
+	}
+	// Refill tokens since the last request
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// expire drops the buckets refilled by now
+func (l *limiter) expire(now time.Time) {
+	for peer, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, peer)
+		}
+	}
+	l.swept = now
+}
+
+// MethodStats are the counters of a method
+type MethodStats struct {
+	Calls  uint64
+	Errors uint64
+	// Total time spent handling calls
+	Latency time.Duration
+}
+
+// Metrics counts the calls of methods handled through its interceptor
+type Metrics struct {
+	mu      sync.Mutex
+	methods map[string]*MethodStats
+}
+
+// NewMetrics creates Metrics
+func NewMetrics() *Metrics {
+	return &Metrics{methods: make(map[string]*MethodStats)}
+}
+
+// Interceptor returns a UnaryInterceptor counting calls, errors and latency per method
+func (m *Metrics) Interceptor() UnaryInterceptor {
+	return func(ctx context.Context, req interface{}, info *MethodInfo, handler UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		elapsed := time.Since(start)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		stats, ok := m.methods[info.Name]
+		if !ok {
+			stats = new(MethodStats)
+			m.methods[info.Name] = stats
+		}
+		stats.Calls++
+		stats.Latency += elapsed
+		if err != nil {
+			stats.Errors++
+		}
+		return resp, err
+	}
+}
+
+// Stats returns the counters of every called method
+func (m *Metrics) Stats() map[string]MethodStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[string]MethodStats, len(m.methods))
+	for name, s := range m.methods {
+		stats[name] = *s
+	}
+	return stats
+}
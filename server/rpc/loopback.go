@@ -0,0 +1,62 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/isaiahwong/cz4013/encoding"
+)
+
+var ErrNoReply = errors.New("No reply written")
+
+// Loopback invokes methods of an RPC in process without a transport.
+// Requests and replies are marshalled so handlers observe the wire format.
+type Loopback struct {
+	rpc  *RPC
+	peer string
+}
+
+// NewLoopback creates a loopback of r for requests of peer
+func NewLoopback(r *RPC, peer string) *Loopback {
+	return &Loopback{rpc: r, peer: peer}
+}
+
+// Invoke invokes the method of m and returns its reply
+func (l *Loopback) Invoke(ctx context.Context, m *Message) (*Message, error) {
+	b, err := encoding.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	in := new(Message)
+	if err = encoding.Unmarshal(b, in); err != nil {
+		return nil, err
+	}
+
+	rec := NewResponseRecorder()
+	if err = l.rpc.Serve(NewRequest(ctx, l.peer, in), rec); err != nil {
+		return nil, err
+	}
+	reply, _ := rec.Reply()
+	if reply == nil {
+		return nil, ErrNoReply
+	}
+
+	b, err = encoding.Marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+	out := new(Message)
+	if err = encoding.Unmarshal(b, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Call invokes method with query params and returns its reply
+func (l *Loopback) Call(ctx context.Context, method string, query map[string]string) (*Message, error) {
+	return l.Invoke(ctx, &Message{
+		RPC:   method,
+		Query: query,
+		Body:  []byte{},
+	})
+}
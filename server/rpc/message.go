@@ -1,5 +1,7 @@
 package rpc
 
+import "errors"
+
 type Error struct {
 	Error string
 	Body  string
@@ -28,3 +30,15 @@ func NewError(method string, err error, body string) *Message {
 		},
 	}
 }
+
+// Err returns the error of a reply. The body of the error describes it
+// unless it is empty. Nil if the reply is not an error.
+func (m *Message) Err() error {
+	if m.Error == nil {
+		return nil
+	}
+	if m.Error.Body == "" {
+		return errors.New(m.Error.Error)
+	}
+	return errors.New(m.Error.Body)
+}
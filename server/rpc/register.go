@@ -0,0 +1,113 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/isaiahwong/cz4013/encoding"
+)
+
+// Handler handles the request of a method decoded as Req and returns its reply.
+// The Request being served is carried by ctx, see RequestFrom.
+type Handler[Req, Resp any] func(ctx context.Context, req *Req) (Resp, error)
+
+// Empty is the reply of methods without a body
+type Empty struct{}
+
+// QueryParser is a request carried by the query params of a message
+type QueryParser interface {
+	ParseQuery(q map[string]string) error
+}
+
+// MethodOption sets options of a registered method
+type MethodOption func(*method)
+
+// WithIdempotency returns a MethodOption which sets the idempotency class of the method.
+// Methods are NonIdempotent by default.
+func WithIdempotency(idempotency Idempotency) MethodOption {
+	return func(m *method) {
+		m.idempotency = idempotency
+	}
+}
+
+// WithLossless returns a MethodOption which ensures replies of the method
+// are not dropped by loss simulation
+func WithLossless() MethodOption {
+	return func(m *method) {
+		m.lossy = false
+	}
+}
+
+// Register registers method name of r handled by h through the unary interceptors of r.
+// Requests that are Validators are validated before h is called.
+//
+// Requests are decoded from their query params if *Req is a QueryParser,
+// otherwise from the body of the message marshalled as *Req. Replies are
+// marshalled with the encoding of the request, Empty replies have no body.
+// Errors returned by h are replied as the innermost error they wrap with the
+// rest of their message as the body, e.g.
+//
+//	return nil, fmt.Errorf("%w: No flights found with %v", ErrNoFlightFound, id)
+func Register[Req, Resp any](r *RPC, name string, h Handler[Req, Resp], opts ...MethodOption) {
+	m := &method{
+		idempotency: NonIdempotent,
+		lossy:       true,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	m.handle = func(req *Request, w ResponseWriter) error {
+		in, err := decodeRequest[Req](req)
+		if err != nil {
+			return r.replyError(w, name, err)
+		}
+
+		res, err := r.interceptUnary(withRequest(req.Context(), req), in, r.info(name), func(ctx context.Context, in interface{}) (interface{}, error) {
+			if err := validate(in); err != nil {
+				return nil, err
+			}
+			return h(ctx, in.(*Req))
+		})
+		if err != nil {
+			return r.replyError(w, name, err)
+		}
+		out, _ := res.(Resp)
+
+		body := []byte{}
+		if _, ok := any(out).(Empty); !ok {
+			if body, err = encoding.Marshal(out, req.Encoding...); err != nil {
+				return r.error(w, name, ErrInternalError, err.Error())
+			}
+		}
+		return r.ok(w, name, body, m.lossy)
+	}
+	r.methods[name] = m
+}
+
+// decodeRequest decodes the request of req as Req
+func decodeRequest[Req any](req *Request) (*Req, error) {
+	in := new(Req)
+	if p, ok := any(in).(QueryParser); ok {
+		return in, p.ParseQuery(req.Message.Query)
+	}
+	if len(req.Message.Body) == 0 {
+		return in, nil
+	}
+	if err := encoding.Unmarshal(req.Message.Body, in, req.Encoding...); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMarshal, err)
+	}
+	return in, nil
+}
+
+// replyError writes the reply of err returned by the handler of method
+func (r *RPC) replyError(w ResponseWriter, method string, err error) error {
+	cause := err
+	for errors.Unwrap(cause) != nil {
+		cause = errors.Unwrap(cause)
+	}
+	body := strings.TrimPrefix(strings.TrimPrefix(err.Error(), cause.Error()), ": ")
+	return r.error(w, method, cause, body)
+}
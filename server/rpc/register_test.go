@@ -0,0 +1,121 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/isaiahwong/cz4013/encoding"
+	"github.com/isaiahwong/cz4013/store"
+	"github.com/sirupsen/logrus"
+)
+
+// TestRegister verifies requests are decoded, replies encoded and errors mapped by Register
+func TestRegister(t *testing.T) {
+	r := &RPC{logger: logrus.New(), methods: make(map[string]*method)}
+	Register(r, MethodFindFlight, func(ctx context.Context, req *FindFlightRequest) (*Flight, error) {
+		if req.ID != 6734 {
+			return nil, fmt.Errorf("%w: No flights found with %v", ErrNoFlightFound, req.ID)
+		}
+		return testFlight(), nil
+	}, WithIdempotency(ReadOnly))
+	Register(r, "Echo", func(ctx context.Context, req *Flight) (Empty, error) {
+		if served, ok := RequestFrom(ctx); !ok || served.Peer != "peer" {
+			return Empty{}, ErrInternalError
+		}
+		return Empty{}, nil
+	}, WithLossless())
+
+	if i, _ := r.Idempotency(MethodFindFlight); i != ReadOnly {
+		t.Fatalf("got %v, want ReadOnly", i)
+	}
+	if i, _ := r.Idempotency("Echo"); i != NonIdempotent {
+		t.Fatalf("got %v, want NonIdempotent", i)
+	}
+
+	l := NewLoopback(r, "peer")
+	client := NewFlightSystemClient(l)
+	f, err := client.FindFlight(context.Background(), &FindFlightRequest{ID: 6734})
+	if err != nil || *f != *testFlight() {
+		t.Fatalf("got %+v, %v", f, err)
+	}
+
+	reply, err := l.Call(context.Background(), MethodFindFlight, map[string]string{"id": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error == nil || reply.Error.Error != ErrNoFlightFound.Error() || reply.Error.Body != "No flights found with 1" {
+		t.Fatalf("got %+v", reply.Error)
+	}
+
+	reply, err = l.Call(context.Background(), MethodFindFlight, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Error == nil || reply.Error.Error != ErrInvalidParams.Error() || reply.Error.Body != "id is missing" {
+		t.Fatalf("got %+v", reply.Error)
+	}
+
+	body, err := encoding.Marshal(testFlight())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := NewResponseRecorder()
+	if err = r.Serve(NewRequest(context.Background(), "peer", NewMessage("Echo", body)), rec); err != nil {
+		t.Fatal(err)
+	}
+	reply, lossy := rec.Reply()
+	if reply.Error != nil || len(reply.Body) != 0 || lossy {
+		t.Fatalf("got %+v, lossy %v", reply, lossy)
+	}
+}
+
+// newTestRPC creates an RPC of the flight application without interceptors
+// holding testFlight with 10 available seats
+func newTestRPC(t *testing.T) (*RPC, *FlightRepo) {
+	db := store.New()
+	flights, reservations := NewFlightRepo(db), NewReservationRepo(db)
+	if err := db.CreateRelation(flights.Relation, reflect.TypeOf(new(Flight))); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateRelation(reservations.Relation, reflect.TypeOf(new(ReserveFlight))); err != nil {
+		t.Fatal(err)
+	}
+	f := testFlight()
+	f.SeatAvailablity = 10
+	if err := db.BulkInsert(flights.Relation, []*Flight{f}); err != nil {
+		t.Fatal(err)
+	}
+	push := func(addr string, b []byte) error { return nil }
+	return New(flights, reservations, time.Second, push), flights
+}
+
+// TestValidate verifies requests are validated without the Validation interceptor
+func TestValidate(t *testing.T) {
+	r, flights := newTestRPC(t)
+	r.logger.SetOutput(io.Discard)
+	l := NewLoopback(r, "peer")
+	client := NewFlightSystemClient(l)
+
+	id := testFlight().ID
+	for _, seats := range []string{"0", "-5"} {
+		reply, err := l.Call(context.Background(), MethodReserveFlight, map[string]string{"id": fmt.Sprint(id), "seats": seats})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Error == nil || reply.Error.Error != ErrInvalidParams.Error() {
+			t.Fatalf("%v seats: got %+v, want %v", seats, reply.Error, ErrInvalidParams)
+		}
+	}
+	if f, _ := flights.FindByID(id); f.SeatAvailablity != 10 {
+		t.Fatalf("got %v available seats, want 10", f.SeatAvailablity)
+	}
+
+	rf, err := client.ReserveFlight(context.Background(), &ReserveFlightRequest{ID: id, Seats: 3})
+	if err != nil || rf.SeatReserved != 3 || rf.Flight.SeatAvailablity != 7 {
+		t.Fatalf("got %+v, %v", rf, err)
+	}
+}
@@ -0,0 +1,138 @@
+package rpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/isaiahwong/cz4013/encoding"
+)
+
+// Request is a request of a client routed to a handler
+type Request struct {
+	// Message of the request
+	Message *Message
+
+	// Address of the client endpoint
+	Peer string
+
+	// Metadata set by the transport e.g. the semantic of the method
+	Metadata map[string]string
+
+	// Time by which the reply should be written. Zero if there is no deadline
+	Deadline time.Time
+
+	// Options of the encoding negotiated by the transport. Bodies of replies
+	// are marshalled with them
+	Encoding []encoding.Option
+
+	ctx context.Context
+}
+
+// NewRequest creates a request of peer for message m.
+// ctx is canceled once the client cancels the request.
+func NewRequest(ctx context.Context, peer string, m *Message) *Request {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Request{
+		Message:  m,
+		Peer:     peer,
+		Metadata: make(map[string]string),
+		ctx:      ctx,
+	}
+}
+
+// requestKey is the context key of the request being served
+type requestKey struct{}
+
+// withRequest returns a context carrying req
+func withRequest(ctx context.Context, req *Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// RequestFrom returns the request served with ctx e.g. by handlers registered
+// with Register to access the peer of the request
+func RequestFrom(ctx context.Context) (*Request, bool) {
+	req, ok := ctx.Value(requestKey{}).(*Request)
+	return req, ok
+}
+
+// Context returns the context of the request
+func (req *Request) Context() context.Context {
+	return req.ctx
+}
+
+// Method returns the RPC method of the request
+func (req *Request) Method() string {
+	return req.Message.RPC
+}
+
+// Query returns the query param of key
+func (req *Request) Query(key string) (string, bool) {
+	v, ok := req.Message.Query[key]
+	return v, ok
+}
+
+// ResponseWriter writes the reply of a request
+type ResponseWriter interface {
+	// WriteMessage writes the reply. Lossy replies may be dropped by loss simulation.
+	WriteMessage(m *Message, lossy bool) error
+}
+
+// writableResponse writes replies to a transport Writable
+type writableResponse struct {
+	write Writable
+	opts  []encoding.Option
+}
+
+// NewResponseWriter returns a ResponseWriter marshalling replies with opts to write
+func NewResponseWriter(write Writable, opts ...encoding.Option) ResponseWriter {
+	return &writableResponse{write: write, opts: opts}
+}
+
+func (w *writableResponse) WriteMessage(m *Message, lossy bool) error {
+	b, err := encoding.Marshal(m, w.opts...)
+	if err != nil {
+		return err
+	}
+	_, err = w.write(b, lossy)
+	return err
+}
+
+// ResponseRecorder is a ResponseWriter recording replies in memory
+type ResponseRecorder struct {
+	mu       sync.Mutex
+	messages []*Message
+	lossy    []bool
+}
+
+// NewResponseRecorder creates a ResponseRecorder
+func NewResponseRecorder() *ResponseRecorder {
+	return new(ResponseRecorder)
+}
+
+func (rec *ResponseRecorder) WriteMessage(m *Message, lossy bool) error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.messages = append(rec.messages, m)
+	rec.lossy = append(rec.lossy, lossy)
+	return nil
+}
+
+// Messages returns the recorded replies in order
+func (rec *ResponseRecorder) Messages() []*Message {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]*Message(nil), rec.messages...)
+}
+
+// Reply returns the last recorded reply and whether it is lossy
+func (rec *ResponseRecorder) Reply() (*Message, bool) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.messages) == 0 {
+		return nil, false
+	}
+	return rec.messages[len(rec.messages)-1], rec.lossy[len(rec.lossy)-1]
+}
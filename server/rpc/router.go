@@ -1,8 +1,10 @@
 package rpc
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -21,106 +23,269 @@ type RPC struct {
 	flightRepo      *FlightRepo
 	reservationRepo *ReservationRepo
 
-	chFlightUpdatesMux sync.Mutex
-	chFlightUpdates    map[string]chan *FlightChannel
+	push Pusher
+
+	// Registered methods by name
+	methods map[string]*method
+
+	// Interceptors of requests in order from the outermost
+	unary  []UnaryInterceptor
+	stream []StreamInterceptor
+
+	subscriptionsMux sync.Mutex
+	subscriptions    map[string]*subscription
+}
+
+// subscription of a client endpoint to flight updates
+type subscription struct {
+	addr     string
+	request  string // id of the request that subscribed
+	fid      int32
+	until    time.Time
+	timer    *time.Timer
+	encoding []encoding.Option // encoding of updates negotiated by the request
+}
+
+// requestIDKey is the context key of the request id
+type requestIDKey struct{}
+
+// WithRequestID returns a context carrying the id of the request being handled.
+// Work retained by a request beyond its reply is released by Cancel with the same id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
 }
 
-type FlightChannel struct {
-	flight  *Flight
-	release bool
+// RequestID returns the id of the request carried by ctx
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
 }
 
 // function to handle the request
 type Writable func([]byte, bool) (int, error)
 type Readable func(time.Duration) ([]byte, error)
 
+// Pusher delivers a message to a client endpoint on a server initiated stream
+type Pusher func(addr string, b []byte) error
+
+// Idempotency is the class of a method used to choose its invocation semantics
+type Idempotency byte
+
+const (
+	// ReadOnly methods do not mutate data and are safe to repeat
+	ReadOnly Idempotency = iota
+	// Idempotent methods mutate data and repeated calls have the same effect
+	Idempotent
+	// NonIdempotent methods mutate data and repeated calls have additional effects
+	NonIdempotent
+)
+
+func (i Idempotency) String() string {
+	switch i {
+	case ReadOnly:
+		return "ReadOnly"
+	case Idempotent:
+		return "Idempotent"
+	case NonIdempotent:
+		return "NonIdempotent"
+	default:
+		return "Unknown"
+	}
+}
+
+// method is a registered RPC method
+type method struct {
+	idempotency Idempotency
+	// Replies may be dropped by loss simulation
+	lossy  bool
+	handle handler
+}
+
+// handler handles a request and writes its reply to w
+type handler func(req *Request, w ResponseWriter) error
+
 // HandleRequest handles the request from the client
-func (r *RPC) HandleRequest(addr string, read Readable, write Writable) error {
+func (r *RPC) HandleRequest(ctx context.Context, addr string, read Readable, write Writable) error {
+	m, err := r.ReadMessage(read, write)
+	if err != nil || m == nil {
+		return err
+	}
+	req := NewRequest(ctx, addr, m)
+	req.Deadline = time.Now().Add(r.deadline)
+	return r.Serve(req, NewResponseWriter(write))
+}
+
+// ReadMessage reads the request message from the client encoded with opts.
+// A nil message is returned if the request is malformed and an error has been replied.
+func (r *RPC) ReadMessage(read Readable, write Writable, opts ...encoding.Option) (*Message, error) {
 	// Read message
 	buf, err := read(r.deadline)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Unmarhsal message
 	m := new(Message)
-	err = encoding.Unmarshal(buf, m)
+	err = encoding.Unmarshal(buf, m, opts...)
 	if err != nil {
-		return r.error("", ErrMarshal, "", read, write)
+		return nil, r.error(NewResponseWriter(write, opts...), "", ErrMarshal, "")
 	}
+	return m, nil
+}
 
-	r.logger.Info(fmt.Sprintf("[%v] - %v", m.RPC, addr))
-	return r.router(addr, m, read, write)
+// Serve routes a request to its method through the stream interceptors.
+// Requests canceled before they are routed are abandoned without a reply.
+func (r *RPC) Serve(req *Request, w ResponseWriter) error {
+	if err := req.Context().Err(); err != nil {
+		r.logger.Info(fmt.Sprintf("[%v] - %v canceled", req.Method(), req.Peer))
+		return err
+	}
+	return r.interceptStream(req, w, r.info(req.Method()), r.router)
 }
 
-func (r *RPC) router(addr string, m *Message, read Readable, write Writable) error {
-	if m.RPC == "FindFlights" {
-		return r.FindFlights(m, read, write)
-	} else if m.RPC == "FindFlight" {
-		return r.FindFlight(m, read, write)
-	} else if m.RPC == "ReserveFlight" {
-		return r.ReserveFlight(m, read, write)
-	} else if m.RPC == "MonitorUpdates" {
-		return r.MonitorUpdates(addr, m, read, write)
-	} else if m.RPC == "CheckInFlight" {
-		return r.CheckInFlight(m, read, write)
-	} else if m.RPC == "GetMeals" {
-		return r.GetMeals(read, write)
-	} else if m.RPC == "AddMeals" {
-		return r.AddMeals(m, read, write)
-	} else if m.RPC == "CancelFlight" {
-		return r.CancelFlight(m, read, write)
+// Cancel releases the work retained by request id of addr such as subscriptions
+func (r *RPC) Cancel(addr string, id string) bool {
+	r.subscriptionsMux.Lock()
+	sub, ok := r.subscriptions[addr]
+	r.subscriptionsMux.Unlock()
+	if !ok || sub.request != id {
+		return false
 	}
-	return r.error(m.RPC, ErrNotFound, "RPC method not found", read, write)
+	r.unsubscribe(sub)
+	return true
 }
 
-func (r *RPC) error(method string, err error, body string, read Readable, write Writable) error {
-	b, err := encoding.Marshal(NewError(method, err, body))
-	if err != nil {
-		return err
+// Idempotency returns the idempotency class of a method
+func (r *RPC) Idempotency(name string) (Idempotency, bool) {
+	m, ok := r.methods[name]
+	if !ok {
+		return NonIdempotent, false
 	}
-	if _, err := write(b, false); err != nil {
-		return err
+	return m.idempotency, true
+}
+
+// Methods returns the names of registered methods
+func (r *RPC) Methods() []string {
+	names := []string{}
+	for name := range r.methods {
+		names = append(names, name)
 	}
-	return nil
+	sort.Strings(names)
+	return names
 }
 
-func (r *RPC) ok(rpc string, body []byte, lossy bool, read Readable, write Writable) error {
-	message := NewMessage(rpc, body)
-	b, err := encoding.Marshal(message)
-	if err != nil {
-		return err
+// routes registers the methods of the flight application
+func (r *RPC) routes() {
+	RegisterFlightSystemServer(r, r)
+}
+
+func (r *RPC) router(req *Request, w ResponseWriter) error {
+	if method, ok := r.methods[req.Method()]; ok {
+		return method.handle(req, w)
 	}
-	if _, err := write(b, lossy); err != nil {
-		return err
+	return r.error(w, req.Method(), ErrNotFound, "RPC method not found")
+}
+
+func (r *RPC) error(w ResponseWriter, method string, err error, body string) error {
+	return w.WriteMessage(NewError(method, err, body), false)
+}
+
+func (r *RPC) ok(w ResponseWriter, rpc string, body []byte, lossy bool) error {
+	return w.WriteMessage(NewMessage(rpc, body), lossy)
+}
+
+// subscribe registers addr for flight updates until the given time.
+// Updates are encoded with opts. An existing subscription of addr is overridden.
+func (r *RPC) subscribe(ctx context.Context, addr string, fid int32, until time.Time, opts []encoding.Option) {
+	sub := &subscription{
+		addr:     addr,
+		request:  RequestID(ctx),
+		fid:      fid,
+		until:    until,
+		encoding: opts,
 	}
-	return nil
+
+	r.subscriptionsMux.Lock()
+	defer r.subscriptionsMux.Unlock()
+	if old, ok := r.subscriptions[addr]; ok {
+		old.timer.Stop()
+		r.logger.Info(fmt.Sprintf("Monitor Override: %v", addr))
+	}
+
+	// Release subscription once deadline is reached
+	sub.timer = time.AfterFunc(time.Until(until), func() {
+		r.unsubscribe(sub)
+	})
+	r.subscriptions[addr] = sub
 }
 
+// unsubscribe removes sub if it is still the active subscription of its endpoint
+func (r *RPC) unsubscribe(sub *subscription) {
+	r.subscriptionsMux.Lock()
+	defer r.subscriptionsMux.Unlock()
+	if cur, ok := r.subscriptions[sub.addr]; ok && cur == sub {
+		cur.timer.Stop()
+		delete(r.subscriptions, sub.addr)
+		r.logger.Info(fmt.Sprintf("Released: %v", sub.addr))
+	}
+}
+
+// broadcastFlights pushes flight updates to subscribed client endpoints
 func (r *RPC) broadcastFlights(flight *Flight) {
-	r.chFlightUpdatesMux.Lock()
-	defer r.chFlightUpdatesMux.Unlock()
+	r.subscriptionsMux.Lock()
+	defer r.subscriptionsMux.Unlock()
 	// Broadcast
-	for _, f := range r.chFlightUpdates {
-		select {
-		case f <- &FlightChannel{flight: flight, release: false}:
-		default:
+	for _, sub := range r.subscriptions {
+		if sub.fid != -1 && sub.fid != flight.ID {
+			continue
+		}
+		// Updates are encoded as negotiated by each subscriber
+		m, err := marshalUpdate(flight, sub.encoding)
+		if err != nil {
+			r.logger.WithError(err).Error(fmt.Sprintf("Unable to marshal flight update for %v", sub.addr))
+			continue
 		}
+		go func(sub *subscription) {
+			if err := r.push(sub.addr, m); err != nil {
+				r.logger.WithError(err).Error(fmt.Sprintf("Unable to push update to %v", sub.addr))
+				r.unsubscribe(sub)
+			}
+		}(sub)
 	}
 }
 
-func New(f *FlightRepo, r *ReservationRepo, deadline time.Duration) *RPC {
+// marshalUpdate marshals the MonitorUpdates message of flight
+func marshalUpdate(flight *Flight, opts []encoding.Option) ([]byte, error) {
+	b, err := encoding.Marshal(flight, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return encoding.Marshal(NewMessage("MonitorUpdates", b), opts...)
+}
+
+// New creates an RPC of the flight application pushing updates with push
+func New(f *FlightRepo, r *ReservationRepo, deadline time.Duration, push Pusher, opts ...Option) *RPC {
 	if f == nil {
 		panic("flightRepo cannot be nil")
 	}
 	if r == nil {
 		panic("reservationRepo cannot be nil")
 	}
-	return &RPC{
+	if push == nil {
+		panic("push cannot be nil")
+	}
+	rpc := &RPC{
 		logger:          logrus.New(),
 		deadline:        deadline,
 		flightRepo:      f,
 		reservationRepo: r,
-		chFlightUpdates: make(map[string]chan *FlightChannel),
+		push:            push,
+		methods:         make(map[string]*method),
+		subscriptions:   make(map[string]*subscription),
+	}
+	for _, opt := range opts {
+		opt(rpc)
 	}
+	rpc.routes()
+	return rpc
 }
@@ -0,0 +1,107 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/isaiahwong/cz4013/encoding"
+)
+
+// Invoker sends the request message of a method and returns its reply.
+// Implemented by transports for the generated client stubs.
+type Invoker interface {
+	Invoke(ctx context.Context, m *Message) (*Message, error)
+}
+
+// ServiceDesc describes a service declared in an IDL file
+type ServiceDesc struct {
+	Name    string
+	Methods []MethodDesc
+}
+
+// Method returns the description of method name
+func (s *ServiceDesc) Method(name string) (MethodDesc, bool) {
+	for _, m := range s.Methods {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return MethodDesc{}, false
+}
+
+// MethodDesc describes a method and the types of its messages
+type MethodDesc struct {
+	Name        string
+	Idempotency Idempotency
+	Params      []ParamDesc
+
+	// Type of the request carried by the query params
+	Request reflect.Type
+	// Type of the reply body. Nil if the reply has no body
+	Response reflect.Type
+	// Type of the bodies pushed to the client. Nil if nothing is pushed
+	Push reflect.Type
+}
+
+// ParamDesc describes a query param of a request
+type ParamDesc struct {
+	Name     string
+	Type     string
+	Optional bool
+}
+
+// DecodeResponse decodes the reply body b of the method
+func (m *MethodDesc) DecodeResponse(b []byte, opts ...encoding.Option) (interface{}, error) {
+	return decodeBody(m.Response, b, opts)
+}
+
+// DecodePush decodes the body b pushed by the method
+func (m *MethodDesc) DecodePush(b []byte, opts ...encoding.Option) (interface{}, error) {
+	return decodeBody(m.Push, b, opts)
+}
+
+// decodeBody decodes a body of type t. Pointers are decoded as marshalled,
+// slices are decoded through a pointer.
+func decodeBody(t reflect.Type, b []byte, opts []encoding.Option) (interface{}, error) {
+	if t == nil {
+		return nil, nil
+	}
+	if t.Kind() == reflect.Ptr {
+		v := reflect.New(t.Elem())
+		err := encoding.Unmarshal(b, v.Interface(), opts...)
+		return v.Interface(), err
+	}
+	v := reflect.New(t)
+	err := encoding.Unmarshal(b, v.Interface(), opts...)
+	return v.Elem().Interface(), err
+}
+
+// invoke invokes method with query through invoker and decodes the reply body into out.
+// An empty body or a nil out leaves out untouched.
+func invoke(ctx context.Context, invoker Invoker, method string, query map[string]string, out interface{}, opts []encoding.Option) error {
+	reply, err := invoker.Invoke(ctx, &Message{
+		RPC:   method,
+		Query: query,
+		Body:  []byte{},
+	})
+	if err != nil {
+		return err
+	}
+	if err = reply.Err(); err != nil {
+		return err
+	}
+	if out == nil || len(reply.Body) == 0 {
+		return nil
+	}
+	return encoding.Unmarshal(reply.Body, out, opts...)
+}
+
+// invalidParam returns the error of query param key that is missing if err is nil,
+// or that can't be parsed
+func invalidParam(key string, err error) error {
+	if err == nil {
+		return fmt.Errorf("%w: %v is missing", ErrInvalidParams, key)
+	}
+	return fmt.Errorf("%w: %v: %v", ErrInvalidParams, key, err)
+}
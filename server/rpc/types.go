@@ -1,5 +1,8 @@
 package rpc
 
+//go:generate go run ../cmd/czidl -output flight_gen.go -schema flight.schema.json flight.idl
+//go:generate go run ../cmd/czgen -type Flight,ReserveFlight,Food,Message -output wire_gen.go
+
 import (
 	"fmt"
 	"strconv"
@@ -8,15 +11,6 @@ import (
 	"github.com/isaiahwong/cz4013/common"
 )
 
-type Flight struct {
-	ID              int32
-	Source          string
-	Destination     string
-	Airfare         float32
-	SeatAvailablity int32
-	Timestamp       uint32
-}
-
 func (f *Flight) Parse(data []string) error {
 	id, err := strconv.ParseInt(data[0], 10, 64)
 	if err != nil {
@@ -62,11 +56,6 @@ func (f *Flight) String() string {
 	)
 }
 
-type Food struct {
-	ID   int32
-	Name string
-}
-
 func (r *Food) String() string {
 	return fmt.Sprintf("%v%v",
 		common.TitleValueLine("ID", r.ID, 1),
@@ -97,13 +86,12 @@ func GetFood() map[int32]*Food {
 	return foodMap
 }
 
-type ReserveFlight struct {
-	ID           string
-	Flight       *Flight
-	SeatReserved int32
-	CheckIn      bool
-	Cancelled    bool
-	Meals        []*Food
+// Validate reports reservations of no seats
+func (x *ReserveFlightRequest) Validate() error {
+	if x.Seats <= 0 {
+		return invalidParam("seats", fmt.Errorf("%v is invalid", x.Seats))
+	}
+	return nil
 }
 
 func (r *ReserveFlight) String() string {
@@ -0,0 +1,379 @@
+// Code generated by czgen. DO NOT EDIT.
+
+package rpc
+
+import "github.com/isaiahwong/cz4013/encoding"
+
+// MarshalCZ encodes Error to e without reflection
+func (x *Error) MarshalCZ(e *encoding.Encoder) error {
+	if err := e.WriteString(x.Error); err != nil {
+		return err
+	}
+	if err := e.WriteString(x.Body); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalCZ decodes Error from d without reflection
+func (x *Error) UnmarshalCZ(d *encoding.Decoder) error {
+	if err := d.Enter(); err != nil {
+		return err
+	}
+	defer d.Leave()
+	n1, err := d.ReadString()
+	if err != nil {
+		return err
+	}
+	x.Error = n1
+	n2, err := d.ReadString()
+	if err != nil {
+		return err
+	}
+	x.Body = n2
+	return nil
+}
+
+// MarshalBinary encodes Error in the wire format of encoding.Marshal
+func (x *Error) MarshalBinary() ([]byte, error) {
+	return encoding.Marshal(x)
+}
+
+// UnmarshalBinary decodes Error in the wire format of encoding.Unmarshal
+func (x *Error) UnmarshalBinary(b []byte) error {
+	return encoding.Unmarshal(b, x)
+}
+
+// MarshalCZ encodes Flight to e without reflection
+func (x *Flight) MarshalCZ(e *encoding.Encoder) error {
+	if err := e.WriteInt32(x.ID); err != nil {
+		return err
+	}
+	if err := e.WriteString(x.Source); err != nil {
+		return err
+	}
+	if err := e.WriteString(x.Destination); err != nil {
+		return err
+	}
+	if err := e.WriteFloat32(x.Airfare); err != nil {
+		return err
+	}
+	if err := e.WriteInt32(x.SeatAvailablity); err != nil {
+		return err
+	}
+	if err := e.WriteUint32(x.Timestamp); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalCZ decodes Flight from d without reflection
+func (x *Flight) UnmarshalCZ(d *encoding.Decoder) error {
+	if err := d.Enter(); err != nil {
+		return err
+	}
+	defer d.Leave()
+	n1, err := d.ReadInt32()
+	if err != nil {
+		return err
+	}
+	x.ID = n1
+	n2, err := d.ReadString()
+	if err != nil {
+		return err
+	}
+	x.Source = n2
+	n3, err := d.ReadString()
+	if err != nil {
+		return err
+	}
+	x.Destination = n3
+	n4, err := d.ReadFloat32()
+	if err != nil {
+		return err
+	}
+	x.Airfare = n4
+	n5, err := d.ReadInt32()
+	if err != nil {
+		return err
+	}
+	x.SeatAvailablity = n5
+	n6, err := d.ReadUint32()
+	if err != nil {
+		return err
+	}
+	x.Timestamp = n6
+	return nil
+}
+
+// MarshalBinary encodes Flight in the wire format of encoding.Marshal
+func (x *Flight) MarshalBinary() ([]byte, error) {
+	return encoding.Marshal(x)
+}
+
+// UnmarshalBinary decodes Flight in the wire format of encoding.Unmarshal
+func (x *Flight) UnmarshalBinary(b []byte) error {
+	return encoding.Unmarshal(b, x)
+}
+
+// MarshalCZ encodes Food to e without reflection
+func (x *Food) MarshalCZ(e *encoding.Encoder) error {
+	if err := e.WriteInt32(x.ID); err != nil {
+		return err
+	}
+	if err := e.WriteString(x.Name); err != nil {
+		return err
+	}
+	return nil
+}
+
+// UnmarshalCZ decodes Food from d without reflection
+func (x *Food) UnmarshalCZ(d *encoding.Decoder) error {
+	if err := d.Enter(); err != nil {
+		return err
+	}
+	defer d.Leave()
+	n1, err := d.ReadInt32()
+	if err != nil {
+		return err
+	}
+	x.ID = n1
+	n2, err := d.ReadString()
+	if err != nil {
+		return err
+	}
+	x.Name = n2
+	return nil
+}
+
+// MarshalBinary encodes Food in the wire format of encoding.Marshal
+func (x *Food) MarshalBinary() ([]byte, error) {
+	return encoding.Marshal(x)
+}
+
+// UnmarshalBinary decodes Food in the wire format of encoding.Unmarshal
+func (x *Food) UnmarshalBinary(b []byte) error {
+	return encoding.Unmarshal(b, x)
+}
+
+// MarshalCZ encodes Message to e without reflection
+func (x *Message) MarshalCZ(e *encoding.Encoder) error {
+	if err := e.WriteString(x.RPC); err != nil {
+		return err
+	}
+	if err := e.WriteUint64(uint64(len(x.Query))); err != nil {
+		return err
+	}
+	for k1, v2 := range x.Query {
+		if err := e.WriteString(k1); err != nil {
+			return err
+		}
+		if err := e.WriteString(v2); err != nil {
+			return err
+		}
+	}
+	if err := e.WriteUint64(uint64(len(x.Body))); err != nil {
+		return err
+	}
+	if err := e.WriteBytes(x.Body); err != nil {
+		return err
+	}
+	if err := e.WriteBool(x.Error == nil); err != nil {
+		return err
+	}
+	if x.Error != nil {
+		if err := (*x.Error).MarshalCZ(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnmarshalCZ decodes Message from d without reflection
+func (x *Message) UnmarshalCZ(d *encoding.Decoder) error {
+	if err := d.Enter(); err != nil {
+		return err
+	}
+	defer d.Leave()
+	n1, err := d.ReadString()
+	if err != nil {
+		return err
+	}
+	x.RPC = n1
+	l2, err := d.ReadLength()
+	if err != nil {
+		return err
+	}
+	x.Query = make(map[string]string)
+	for i3 := 0; i3 < l2; i3++ {
+		var k4 string
+		var v5 string
+		n6, err := d.ReadString()
+		if err != nil {
+			return err
+		}
+		k4 = n6
+		n7, err := d.ReadString()
+		if err != nil {
+			return err
+		}
+		v5 = n7
+		x.Query[k4] = v5
+	}
+	l8, err := d.ReadLength()
+	if err != nil {
+		return err
+	}
+	if l8 > 0 {
+		b9, err := d.ReadBytes(l8)
+		if err != nil {
+			return err
+		}
+		x.Body = b9
+	} else {
+		x.Body = nil
+	}
+	isNil10, err := d.ReadBool()
+	if err != nil {
+		return err
+	}
+	if isNil10 {
+		x.Error = nil
+	} else {
+		if x.Error == nil {
+			x.Error = new(Error)
+		}
+		if err := (*x.Error).UnmarshalCZ(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalBinary encodes Message in the wire format of encoding.Marshal
+func (x *Message) MarshalBinary() ([]byte, error) {
+	return encoding.Marshal(x)
+}
+
+// UnmarshalBinary decodes Message in the wire format of encoding.Unmarshal
+func (x *Message) UnmarshalBinary(b []byte) error {
+	return encoding.Unmarshal(b, x)
+}
+
+// MarshalCZ encodes ReserveFlight to e without reflection
+func (x *ReserveFlight) MarshalCZ(e *encoding.Encoder) error {
+	if err := e.WriteString(x.ID); err != nil {
+		return err
+	}
+	if err := e.WriteBool(x.Flight == nil); err != nil {
+		return err
+	}
+	if x.Flight != nil {
+		if err := (*x.Flight).MarshalCZ(e); err != nil {
+			return err
+		}
+	}
+	if err := e.WriteInt32(x.SeatReserved); err != nil {
+		return err
+	}
+	if err := e.WriteBool(x.CheckIn); err != nil {
+		return err
+	}
+	if err := e.WriteBool(x.Cancelled); err != nil {
+		return err
+	}
+	if err := e.WriteUint64(uint64(len(x.Meals))); err != nil {
+		return err
+	}
+	for _, v1 := range x.Meals {
+		if err := e.WriteBool(v1 == nil); err != nil {
+			return err
+		}
+		if v1 != nil {
+			if err := (*v1).MarshalCZ(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// UnmarshalCZ decodes ReserveFlight from d without reflection
+func (x *ReserveFlight) UnmarshalCZ(d *encoding.Decoder) error {
+	if err := d.Enter(); err != nil {
+		return err
+	}
+	defer d.Leave()
+	n1, err := d.ReadString()
+	if err != nil {
+		return err
+	}
+	x.ID = n1
+	isNil2, err := d.ReadBool()
+	if err != nil {
+		return err
+	}
+	if isNil2 {
+		x.Flight = nil
+	} else {
+		if x.Flight == nil {
+			x.Flight = new(Flight)
+		}
+		if err := (*x.Flight).UnmarshalCZ(d); err != nil {
+			return err
+		}
+	}
+	n3, err := d.ReadInt32()
+	if err != nil {
+		return err
+	}
+	x.SeatReserved = n3
+	n4, err := d.ReadBool()
+	if err != nil {
+		return err
+	}
+	x.CheckIn = n4
+	n5, err := d.ReadBool()
+	if err != nil {
+		return err
+	}
+	x.Cancelled = n5
+	l6, err := d.ReadLength()
+	if err != nil {
+		return err
+	}
+	if l6 > 0 {
+		x.Meals = make([]*Food, 0, d.Capacity(l6))
+		for i7 := 0; i7 < l6; i7++ {
+			var e8 *Food
+			isNil9, err := d.ReadBool()
+			if err != nil {
+				return err
+			}
+			if isNil9 {
+				e8 = nil
+			} else {
+				if e8 == nil {
+					e8 = new(Food)
+				}
+				if err := (*e8).UnmarshalCZ(d); err != nil {
+					return err
+				}
+			}
+			x.Meals = append(x.Meals, e8)
+		}
+	} else {
+		x.Meals = nil
+	}
+	return nil
+}
+
+// MarshalBinary encodes ReserveFlight in the wire format of encoding.Marshal
+func (x *ReserveFlight) MarshalBinary() ([]byte, error) {
+	return encoding.Marshal(x)
+}
+
+// UnmarshalBinary decodes ReserveFlight in the wire format of encoding.Unmarshal
+func (x *ReserveFlight) UnmarshalBinary(b []byte) error {
+	return encoding.Unmarshal(b, x)
+}
@@ -0,0 +1,205 @@
+package rpc
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/isaiahwong/cz4013/encoding"
+)
+
+// Mirrors of the wire types without generated methods, encoded with reflection
+type (
+	reflectFlight struct {
+		ID              int32
+		Source          string
+		Destination     string
+		Airfare         float32
+		SeatAvailablity int32
+		Timestamp       uint32
+	}
+	reflectFood struct {
+		ID   int32
+		Name string
+	}
+	reflectReserveFlight struct {
+		ID           string
+		Flight       *reflectFlight
+		SeatReserved int32
+		CheckIn      bool
+		Cancelled    bool
+		Meals        []*reflectFood
+	}
+	reflectError struct {
+		Error string
+		Body  string
+	}
+	reflectMessage struct {
+		RPC   string
+		Query map[string]string
+		Body  []byte
+		Error *reflectError
+	}
+)
+
+func testFlight() *Flight {
+	return &Flight{
+		ID:              6734,
+		Source:          "Phoenix",
+		Destination:     "San Antonio",
+		Airfare:         499,
+		SeatAvailablity: 66,
+		Timestamp:       1681260739,
+	}
+}
+
+func testReserveFlight() *ReserveFlight {
+	return &ReserveFlight{
+		ID:           "513a3af1-7f50-46ae-8030-4982f30b920a",
+		Flight:       testFlight(),
+		SeatReserved: 2,
+		CheckIn:      true,
+		Meals:        []*Food{{ID: 0, Name: "Steak"}, {ID: 2, Name: "Wine"}},
+	}
+}
+
+func testMessage() *Message {
+	return &Message{
+		RPC:   "FindFlight",
+		Query: map[string]string{"id": "6734"},
+		Body:  bytes.Repeat([]byte{0x2a}, 512),
+		Error: &Error{Error: "Flight not found", Body: "No flights found with 1"},
+	}
+}
+
+func reflectFlightOf(f *Flight) *reflectFlight {
+	r := reflectFlight(*f)
+	return &r
+}
+
+func reflectReserveFlightOf(r *ReserveFlight) *reflectReserveFlight {
+	meals := []*reflectFood{}
+	for _, m := range r.Meals {
+		f := reflectFood(*m)
+		meals = append(meals, &f)
+	}
+	return &reflectReserveFlight{
+		ID:           r.ID,
+		Flight:       reflectFlightOf(r.Flight),
+		SeatReserved: r.SeatReserved,
+		CheckIn:      r.CheckIn,
+		Cancelled:    r.Cancelled,
+		Meals:        meals,
+	}
+}
+
+func reflectMessageOf(m *Message) *reflectMessage {
+	e := reflectError(*m.Error)
+	return &reflectMessage{RPC: m.RPC, Query: m.Query, Body: m.Body, Error: &e}
+}
+
+// TestWireFormat verifies generated codecs produce the wire format of reflection
+func TestWireFormat(t *testing.T) {
+	cases := []struct {
+		name      string
+		generated interface{}
+		reflected interface{}
+		decoded   interface{}
+	}{
+		{"Flight", testFlight(), reflectFlightOf(testFlight()), new(Flight)},
+		{"ReserveFlight", testReserveFlight(), reflectReserveFlightOf(testReserveFlight()), new(ReserveFlight)},
+		{"Message", testMessage(), reflectMessageOf(testMessage()), new(Message)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			want, err := encoding.Marshal(c.reflected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := encoding.Marshal(c.generated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("wire format differs\n got: %x\nwant: %x", got, want)
+			}
+
+			if err = encoding.Unmarshal(want, c.decoded); err != nil {
+				t.Fatal(err)
+			}
+			again, err := encoding.Marshal(c.decoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, want) {
+				t.Fatalf("round trip differs\n got: %x\nwant: %x", again, want)
+			}
+		})
+	}
+}
+
+func benchmarkMarshal(b *testing.B, v interface{}) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := encoding.Marshal(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkUnmarshal(b *testing.B, v interface{}, newV func() interface{}) {
+	data, err := encoding.Marshal(v)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := encoding.Unmarshal(data, newV()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalFlight(b *testing.B) {
+	b.Run("generated", func(b *testing.B) { benchmarkMarshal(b, testFlight()) })
+	b.Run("reflect", func(b *testing.B) { benchmarkMarshal(b, reflectFlightOf(testFlight())) })
+}
+
+func BenchmarkUnmarshalFlight(b *testing.B) {
+	b.Run("generated", func(b *testing.B) {
+		benchmarkUnmarshal(b, testFlight(), func() interface{} { return new(Flight) })
+	})
+	b.Run("reflect", func(b *testing.B) {
+		benchmarkUnmarshal(b, reflectFlightOf(testFlight()), func() interface{} { return new(reflectFlight) })
+	})
+}
+
+func BenchmarkMarshalReserveFlight(b *testing.B) {
+	b.Run("generated", func(b *testing.B) { benchmarkMarshal(b, testReserveFlight()) })
+	b.Run("reflect", func(b *testing.B) { benchmarkMarshal(b, reflectReserveFlightOf(testReserveFlight())) })
+}
+
+func BenchmarkUnmarshalReserveFlight(b *testing.B) {
+	b.Run("generated", func(b *testing.B) {
+		benchmarkUnmarshal(b, testReserveFlight(), func() interface{} { return new(ReserveFlight) })
+	})
+	b.Run("reflect", func(b *testing.B) {
+		benchmarkUnmarshal(b, reflectReserveFlightOf(testReserveFlight()), func() interface{} { return new(reflectReserveFlight) })
+	})
+}
+
+func BenchmarkMarshalMessage(b *testing.B) {
+	b.Run("generated", func(b *testing.B) { benchmarkMarshal(b, testMessage()) })
+	b.Run("reflect", func(b *testing.B) { benchmarkMarshal(b, reflectMessageOf(testMessage())) })
+}
+
+func BenchmarkUnmarshalMessage(b *testing.B) {
+	b.Run("generated", func(b *testing.B) {
+		benchmarkUnmarshal(b, testMessage(), func() interface{} { return new(Message) })
+	})
+	b.Run("reflect", func(b *testing.B) {
+		benchmarkUnmarshal(b, reflectMessageOf(testMessage()), func() interface{} { return new(reflectMessage) })
+	})
+}
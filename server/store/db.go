@@ -16,6 +16,11 @@ type Relation struct {
 type DB struct {
 	relationMux sync.Mutex
 	Relation    map[string]*Relation
+
+	// Journal of committed transactions. nil if the DB is in memory only
+	journal *Log
+	txMux   sync.Mutex
+	tx      *Tx
 }
 
 var (
@@ -44,9 +49,9 @@ func (db *DB) Insert(relation string, tuple interface{}) error {
 	db.relationMux.Lock()
 	defer db.relationMux.Unlock()
 
-	r, ok := db.Relation[relation]
-	if !ok {
-		return ErrRelationNotFound
+	r, err := db.getRelation(relation)
+	if err != nil {
+		return err
 	}
 	if reflect.TypeOf(tuple) != r.T {
 		return ErrTupleRelation
@@ -59,7 +64,7 @@ func (db *DB) Insert(relation string, tuple interface{}) error {
 		slice, e,
 	).Interface()
 
-	return nil
+	return db.record(OpInsert, relation, slice.Len(), tuple)
 }
 
 // Update updates a tuple in a relation
@@ -67,9 +72,9 @@ func (db *DB) Update(relation string, tuple interface{}, predicate func(old inte
 	db.relationMux.Lock()
 	defer db.relationMux.Unlock()
 
-	r, ok := db.Relation[relation]
-	if !ok {
-		return ErrRelationNotFound
+	r, err := db.getRelation(relation)
+	if err != nil {
+		return err
 	}
 	if reflect.TypeOf(tuple) != r.T {
 		return ErrTupleRelation
@@ -78,7 +83,7 @@ func (db *DB) Update(relation string, tuple interface{}, predicate func(old inte
 	for i := 0; i < reflect.ValueOf(r.Tuples).Len(); i++ {
 		if predicate(reflect.ValueOf(r.Tuples).Index(i).Interface(), tuple) {
 			reflect.ValueOf(r.Tuples).Index(i).Set(e)
-			break
+			return db.record(OpUpdate, relation, i, tuple)
 		}
 	}
 	return nil
@@ -97,17 +102,23 @@ func (db *DB) Delete(relation string, predicate func(v interface{}) bool) error
 	slice := reflect.ValueOf(r.Tuples)
 
 	n := slice.Len()
-	newSlice := reflect.MakeSlice(slice.Type(), n-1, n-1)
+	newSlice := reflect.MakeSlice(slice.Type(), n, n)
 
 	j := 0
 	for i := 0; i < slice.Len(); i++ {
 		if !predicate(slice.Index(i).Interface()) {
 			newSlice.Index(j).Set(slice.Index(i))
 			j++
+			continue
+		}
+		// Deleted tuples are shifted by the tuples kept before them
+		if err := db.record(OpDelete, relation, j, slice.Index(i).Interface()); err != nil {
+			return err
 		}
 	}
 
-	r.Tuples = newSlice.Interface()
+	// Any number of tuples may be deleted
+	r.Tuples = newSlice.Slice(0, j).Interface()
 	return nil
 }
 
@@ -138,12 +149,15 @@ func (db *DB) GetRelation(relation string) (*Relation, error) {
 	return r, nil
 }
 
+// getRelation returns a relation accessed by the active transaction
 func (db *DB) getRelation(relation string) (*Relation, error) {
-
 	r, ok := db.Relation[relation]
 	if !ok {
 		return nil, ErrRelationNotFound
 	}
+	if err := db.touch(relation, r); err != nil {
+		return nil, err
+	}
 	return r, nil
 }
 
@@ -0,0 +1,242 @@
+package store
+
+import (
+	"errors"
+	"reflect"
+
+	"github.com/isaiahwong/cz4013/encoding"
+)
+
+// Operations of a mutation
+const (
+	OpInsert uint8 = iota + 1
+	OpUpdate
+	OpDelete
+)
+
+var (
+	ErrNoJournal     = errors.New("Journal not opened")
+	ErrTxDone        = errors.New("Transaction has already been committed or rolled back")
+	ErrInvalidTuple  = errors.New("Invalid tuple index")
+	ErrInvalidCommit = errors.New("Invalid mutation operation")
+)
+
+// Mutation is a change of a tuple in a relation.
+// Tuples are identified by their index as relations are replayed
+// in the same order from the same initial state.
+type Mutation struct {
+	Op       uint8
+	Relation string
+	Index    int64
+	Tuple    []byte
+}
+
+// Commit is a journal record of mutations committed together with a payload
+type Commit struct {
+	Mutations []*Mutation
+	Payload   []byte
+}
+
+// Tx records the mutations of the DB until it is committed or rolled back.
+// Only a single transaction is active at a time.
+type Tx struct {
+	db        *DB
+	mutations []*Mutation
+	done      bool
+
+	// Tuples of relations encoded when the transaction first accessed them.
+	// Handlers mutate tuples in place, hence rollbacks restore the encoded tuples.
+	snapshot map[string][]byte
+}
+
+// OpenJournal opens the journal of the DB at path and replays committed mutations.
+// replay is called with the payload of every commit in order.
+func (db *DB) OpenJournal(path string, replay func(payload []byte) error) error {
+	log, err := OpenLog(path)
+	if err != nil {
+		return err
+	}
+
+	err = log.Replay(func(b []byte) error {
+		c := new(Commit)
+		if err := encoding.Unmarshal(b, c); err != nil {
+			return err
+		}
+		for _, m := range c.Mutations {
+			if err := db.apply(m); err != nil {
+				return err
+			}
+		}
+		if replay != nil {
+			return replay(c.Payload)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Close()
+		return err
+	}
+
+	db.journal = log
+	return nil
+}
+
+// Begin starts a transaction recording mutations of the DB.
+// Begin blocks until the active transaction is committed or rolled back.
+func (db *DB) Begin() (*Tx, error) {
+	if db.journal == nil {
+		return nil, ErrNoJournal
+	}
+
+	db.txMux.Lock()
+	tx := &Tx{db: db, snapshot: map[string][]byte{}}
+
+	db.relationMux.Lock()
+	db.tx = tx
+	db.relationMux.Unlock()
+	return tx, nil
+}
+
+// Commit atomically persists the recorded mutations with payload.
+// The journal is fsync'd before Commit returns.
+func (tx *Tx) Commit(payload []byte) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	db := tx.db
+	defer db.txMux.Unlock()
+
+	db.relationMux.Lock()
+	db.tx = nil
+	db.relationMux.Unlock()
+
+	if len(tx.mutations) == 0 && payload == nil {
+		return nil
+	}
+
+	b, err := encoding.Marshal(&Commit{
+		Mutations: tx.mutations,
+		Payload:   payload,
+	})
+	if err != nil {
+		return err
+	}
+	return db.journal.Append(b, true)
+}
+
+// Rollback restores the relations accessed by the transaction as they were
+// when it began. Nothing is journaled.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	db := tx.db
+	defer db.txMux.Unlock()
+
+	db.relationMux.Lock()
+	defer db.relationMux.Unlock()
+	db.tx = nil
+	for name, b := range tx.snapshot {
+		r, ok := db.Relation[name]
+		if !ok {
+			continue
+		}
+		tuples := reflect.New(reflect.SliceOf(r.T))
+		if err := encoding.Unmarshal(b, tuples.Interface()); err != nil {
+			return err
+		}
+		r.Tuples = tuples.Elem().Interface()
+	}
+	return nil
+}
+
+// touch snapshots the tuples of relation r once the active transaction first
+// accesses it. Relations are only accessed with relationMux held.
+func (db *DB) touch(relation string, r *Relation) error {
+	if db.tx == nil {
+		return nil
+	}
+	if _, ok := db.tx.snapshot[relation]; ok {
+		return nil
+	}
+	b, err := encoding.Marshal(r.Tuples)
+	if err != nil {
+		return err
+	}
+	db.tx.snapshot[relation] = b
+	return nil
+}
+
+// record adds a mutation of a tuple to the active transaction
+func (db *DB) record(op uint8, relation string, index int, tuple interface{}) error {
+	if db.tx == nil {
+		return nil
+	}
+
+	m := &Mutation{
+		Op:       op,
+		Relation: relation,
+		Index:    int64(index),
+		Tuple:    []byte{},
+	}
+	if tuple != nil {
+		b, err := encoding.Marshal(tuple)
+		if err != nil {
+			return err
+		}
+		m.Tuple = b
+	}
+	db.tx.mutations = append(db.tx.mutations, m)
+	return nil
+}
+
+// apply replays a mutation on the DB
+func (db *DB) apply(m *Mutation) error {
+	db.relationMux.Lock()
+	defer db.relationMux.Unlock()
+
+	r, err := db.getRelation(m.Relation)
+	if err != nil {
+		return err
+	}
+
+	slice := reflect.ValueOf(r.Tuples)
+	i := int(m.Index)
+
+	// Decodes the tuple of the mutation
+	tuple := func() (reflect.Value, error) {
+		v := reflect.New(r.T.Elem())
+		if err := encoding.Unmarshal(m.Tuple, v.Interface()); err != nil {
+			return v, err
+		}
+		return v, nil
+	}
+
+	switch m.Op {
+	case OpInsert:
+		v, err := tuple()
+		if err != nil {
+			return err
+		}
+		r.Tuples = reflect.Append(slice, v).Interface()
+	case OpUpdate:
+		if i < 0 || i >= slice.Len() {
+			return ErrInvalidTuple
+		}
+		v, err := tuple()
+		if err != nil {
+			return err
+		}
+		slice.Index(i).Set(v)
+	case OpDelete:
+		if i < 0 || i >= slice.Len() {
+			return ErrInvalidTuple
+		}
+		r.Tuples = reflect.AppendSlice(slice.Slice(0, i), slice.Slice(i+1, slice.Len())).Interface()
+	default:
+		return ErrInvalidCommit
+	}
+	return nil
+}
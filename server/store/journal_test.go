@@ -0,0 +1,151 @@
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type seat struct {
+	ID    int32
+	Taken bool
+}
+
+// newSeatDB creates a DB of 4 seats and another empty relation journaled at path.
+// replayed collects the payloads of replayed commits.
+func newSeatDB(t *testing.T, path string, replayed *[]string) *DB {
+	db := New()
+	for _, relation := range []string{"seats", "other"} {
+		if err := db.CreateRelation(relation, reflect.TypeOf(new(seat))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seats := []*seat{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}
+	if err := db.BulkInsert("seats", seats); err != nil {
+		t.Fatal(err)
+	}
+	err := db.OpenJournal(path, func(payload []byte) error {
+		if replayed != nil {
+			*replayed = append(*replayed, string(payload))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.journal.Close() })
+	return db
+}
+
+// seatsOf returns the seats of db
+func seatsOf(t *testing.T, db *DB) []seat {
+	r, err := db.GetRelation("seats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seats := []seat{}
+	for _, s := range r.Tuples.([]*seat) {
+		seats = append(seats, *s)
+	}
+	return seats
+}
+
+// mutate takes seat 2 in place, deletes seats 1 and 3 and inserts seat 5
+func mutate(t *testing.T, db *DB) {
+	r, err := db.GetRelation("seats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := r.Tuples.([]*seat)[1]
+	s.Taken = true
+	sameID := func(old, new interface{}) bool { return old.(*seat).ID == new.(*seat).ID }
+	if err = db.Update("seats", s, sameID); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Delete("seats", func(v interface{}) bool { return v.(*seat).ID%2 == 1 }); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Insert("seats", &seat{ID: 5}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCommit verifies committed mutations and payloads are replayed once the DB restarts
+func TestCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	db := newSeatDB(t, path, nil)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutate(t, db)
+	if err = tx.Commit([]byte("reply")); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Commit(nil); err != ErrTxDone {
+		t.Fatalf("got %v, want %v", err, ErrTxDone)
+	}
+	want := []seat{{ID: 2, Taken: true}, {ID: 4}, {ID: 5}}
+	if got := seatsOf(t, db); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+
+	// Mutations outside transactions are not journaled
+	if err = db.Insert("seats", &seat{ID: 6}); err != nil {
+		t.Fatal(err)
+	}
+	db.journal.Close()
+
+	replayed := []string{}
+	restarted := newSeatDB(t, path, &replayed)
+	if got := seatsOf(t, restarted); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %+v, want %+v", got, want)
+	}
+	if !reflect.DeepEqual(replayed, []string{"reply"}) {
+		t.Fatalf("replayed payloads %q", replayed)
+	}
+}
+
+// TestRollback verifies rolled back mutations are reverted and never journaled
+func TestRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	db := newSeatDB(t, path, nil)
+	want := seatsOf(t, db)
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutate(t, db)
+	if err = tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err = tx.Rollback(); err != ErrTxDone {
+		t.Fatalf("got %v, want %v", err, ErrTxDone)
+	}
+	if got := seatsOf(t, db); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	// Only the accessed relation is restored
+	if _, ok := tx.snapshot["other"]; ok || len(tx.snapshot) != 1 {
+		t.Fatalf("got snapshots of %v relations", len(tx.snapshot))
+	}
+
+	// Transactions begin once the previous one is rolled back
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutate(t, db)
+	if err = tx.Commit(nil); err != nil {
+		t.Fatal(err)
+	}
+	db.journal.Close()
+
+	restarted := newSeatDB(t, path, nil)
+	want = []seat{{ID: 2, Taken: true}, {ID: 4}, {ID: 5}}
+	if got := seatsOf(t, restarted); !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// sizeOfRecordHeader is the size of the length and checksum prefixing a record
+const sizeOfRecordHeader = 4 + 4
+
+var ErrLogClosed = errors.New("Log closed")
+
+// Log is an append-only log of records persisted to a file.
+// Each record is prefixed by its length and crc32 checksum so a
+// torn write at the tail of the log is discarded on replay.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+}
+
+// OpenLog opens or creates the log at path
+func OpenLog(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{path: path, file: f}, nil
+}
+
+// Replay reads every intact record from the start of the log.
+// The log is truncated after the last intact record.
+func (l *Log) Replay(fn func(record []byte) error) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(l.file)
+	var offset int64
+	var hdr [sizeOfRecordHeader]byte
+	for {
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			break
+		}
+		length := binary.LittleEndian.Uint32(hdr[:4])
+		sum := binary.LittleEndian.Uint32(hdr[4:])
+
+		record := make([]byte, length)
+		if _, err := io.ReadFull(r, record); err != nil {
+			break
+		}
+		// Discard torn or corrupted records
+		if crc32.ChecksumIEEE(record) != sum {
+			break
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+		offset += sizeOfRecordHeader + int64(length)
+	}
+
+	// Truncate the torn tail so new records are appended after intact ones
+	if err := l.file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	l.size = offset
+	return nil
+}
+
+// Append appends a record to the log. The log is fsync'd if sync is true
+func (l *Log) Append(record []byte, sync bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return ErrLogClosed
+	}
+
+	buf := make([]byte, sizeOfRecordHeader+len(record))
+	binary.LittleEndian.PutUint32(buf[:4], uint32(len(record)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(record))
+	copy(buf[sizeOfRecordHeader:], record)
+
+	n, err := l.file.Write(buf)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if sync {
+		return l.file.Sync()
+	}
+	return nil
+}
+
+// Compact atomically replaces the log with records
+func (l *Log) Compact(records [][]byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return ErrLogClosed
+	}
+
+	tmp := l.path + ".compact"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	var size int64
+	var hdr [sizeOfRecordHeader]byte
+	for _, record := range records {
+		binary.LittleEndian.PutUint32(hdr[:4], uint32(len(record)))
+		binary.LittleEndian.PutUint32(hdr[4:], crc32.ChecksumIEEE(record))
+		w.Write(hdr[:])
+		w.Write(record)
+		size += sizeOfRecordHeader + int64(len(record))
+	}
+
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, l.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	l.file.Close()
+	l.file = f
+	l.size = size
+	return nil
+}
+
+// Size returns the size of the log in bytes
+func (l *Log) Size() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.size
+}
+
+// Close closes the log
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return ErrLogClosed
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// records replays the records of the log at path
+func records(t *testing.T, path string) (*Log, []string) {
+	l, err := OpenLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	if err = l.Replay(func(record []byte) error {
+		got = append(got, string(record))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return l, got
+}
+
+// TestLog verifies records are replayed in order, torn tails are truncated
+// and compacted logs replace their records
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	l, got := records(t, path)
+	if len(got) != 0 {
+		t.Fatalf("got %q of a new log", got)
+	}
+	for _, record := range []string{"first", "second", ""} {
+		if err := l.Append([]byte(record), true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	intact := l.Size()
+	l.Close()
+	if err := l.Append([]byte("closed"), true); err != ErrLogClosed {
+		t.Fatalf("got %v, want %v", err, ErrLogClosed)
+	}
+
+	// Tear the header and payload of a record written after the intact ones
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{5, 0, 0, 0, 1, 2, 3, 4, 't', 'o'})
+	f.Close()
+
+	l, got = records(t, path)
+	if want := []string{"first", "second", ""}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if l.Size() != intact {
+		t.Fatalf("got %v bytes, want the torn tail truncated to %v", l.Size(), intact)
+	}
+	if err = l.Append([]byte("third"), true); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	// Corrupted records end the log
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[sizeOfRecordHeader+len("first")+sizeOfRecordHeader] ^= 0xff
+	if err = os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, got = records(t, path)
+	if want := []string{"first"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	if err = l.Compact([][]byte{[]byte("compacted")}); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Append([]byte("appended"), true); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	l, got = records(t, path)
+	defer l.Close()
+	if want := []string{"compacted", "appended"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if _, err = os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Fatalf("got %v, want the compacted log renamed", err)
+	}
+}